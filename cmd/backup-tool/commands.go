@@ -0,0 +1,544 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"docker-backup-tool/internal/backup"
+	"docker-backup-tool/internal/catalog"
+	"docker-backup-tool/internal/config"
+	"docker-backup-tool/internal/logutil"
+	"docker-backup-tool/internal/notify"
+	"docker-backup-tool/internal/repository"
+	"docker-backup-tool/internal/util"
+)
+
+// dataCommands print tables or JSON on stdout, so their log output goes to stderr.
+var dataCommands = map[string]bool{"history": true, "list": true, "config": true}
+
+// runCommand dispatches the commands given after the global flags. problems are the
+// invalid settings found by config.LoadConfig; only 'config' is run despite them.
+// It returns the process exit code.
+func runCommand(cfg config.Config, problems []config.Problem, name string, args []string) int {
+	switch name {
+	case "restore":
+		return runRestore(cfg, args)
+	case "verify":
+		return runVerify(cfg, args)
+	case "snapshots":
+		return runSnapshots(cfg, args)
+	case "prune":
+		return runPrune(cfg, args)
+	case "check":
+		return runCheck(cfg, args)
+	case "notify-test":
+		return runNotifyTest(cfg, args)
+	case "history":
+		return runHistory(cfg, args)
+	case "list":
+		return runList(cfg, args)
+	case "config":
+		return runConfig(cfg, problems, args)
+	default:
+		logutil.Error("Unknown command '%s'. Available commands: restore, verify, snapshots, prune, check, notify-test, history, list, config check, daemon", name)
+		return 2
+	}
+}
+
+// runRestore restores an archive, following incremental chains back to their full backup,
+// or a snapshot from the repository.
+func runRestore(cfg config.Config, args []string) int {
+	fs := flag.NewFlagSet("restore", flag.ContinueOnError)
+	target := fs.String("target", "./restore", "Directory to restore the archive into")
+	snapshotID := fs.String("snapshot", "", "Restore a repository snapshot by ID, or 'latest' together with --project")
+	project := fs.String("project", "", "Project whose latest snapshot is restored with --snapshot latest")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *snapshotID != "" {
+		return restoreSnapshot(cfg, *snapshotID, *project, *target)
+	}
+	if fs.NArg() != 1 {
+		logutil.Error("Usage: backup-tool [global flags] restore [--target dir] <archive> | --snapshot <id|latest> [--project name]")
+		return 2
+	}
+
+	archive := resolveArchivePath(cfg, fs.Arg(0))
+	chain, err := backup.ResolveChain(archive, cfg)
+	if err != nil {
+		logutil.Error("Cannot restore %s: %v", archive, err)
+		return 1
+	}
+	logutil.Info("Restoring %s into %s (%d archive(s) in chain).", archive, *target, len(chain))
+
+	if cfg.DryRun {
+		for _, a := range chain {
+			logutil.Info("[DRY RUN] Would extract %s", a)
+		}
+		return 0
+	}
+
+	if err := backup.Restore(archive, *target, cfg); err != nil {
+		logutil.Error("Restore failed: %v", err)
+		return 1
+	}
+	logutil.Success("Restored %s into %s", filepath.Base(archive), *target)
+	return 0
+}
+
+// runVerify checks that archives can be decrypted and read completely.
+func runVerify(cfg config.Config, args []string) int {
+	fs := flag.NewFlagSet("verify", flag.ContinueOnError)
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() == 0 {
+		logutil.Error("Usage: backup-tool [global flags] verify <archive>...")
+		return 2
+	}
+
+	code := 0
+	for _, name := range fs.Args() {
+		archive := resolveArchivePath(cfg, name)
+		res, err := backup.Verify(archive, cfg)
+		if err != nil {
+			logutil.Error("%s: verification failed: %v", archive, err)
+			code = 1
+			continue
+		}
+		kind := "archive"
+		if res.Manifest != nil {
+			kind = res.Manifest.Type + " archive"
+		}
+		logutil.Success("%s: OK (%s, %d files, %s)", archive, kind, res.Files, util.FormatBytes(int64(res.Bytes)))
+	}
+	return code
+}
+
+// resolveArchivePath looks up bare archive names in the configured backup directory.
+func resolveArchivePath(cfg config.Config, name string) string {
+	if strings.ContainsRune(name, filepath.Separator) {
+		return name
+	}
+	if _, err := os.Stat(name); err == nil {
+		return name
+	}
+	return filepath.Join(cfg.BackupDir, name)
+}
+
+// restoreSnapshot restores a snapshot from the repository into target.
+func restoreSnapshot(cfg config.Config, id, project, target string) int {
+	repo, err := repository.OpenReadOnly(cfg.Repository.Path)
+	if err != nil {
+		logutil.Error("Failed to open repository: %v", err)
+		return 1
+	}
+	defer repo.Close()
+
+	var snap *repository.Snapshot
+	if id == "latest" {
+		if project == "" {
+			logutil.Error("--snapshot latest requires --project")
+			return 2
+		}
+		snap, err = repo.Latest(project)
+	} else {
+		snap, err = repo.LoadSnapshot(id)
+	}
+	if err != nil {
+		logutil.Error("Cannot restore: %v", err)
+		return 1
+	}
+
+	logutil.Info("Restoring snapshot %s of %s (%s) into %s.", snap.ID, snap.Project, snap.Time.Local().Format(time.RFC3339), target)
+	if cfg.DryRun {
+		logutil.Info("[DRY RUN] Would restore %d entries (%s).", len(snap.Nodes), util.FormatBytes(snap.Size))
+		return 0
+	}
+	if err := repo.Restore(snap, target); err != nil {
+		logutil.Error("Restore failed: %v", err)
+		return 1
+	}
+	logutil.Success("Restored snapshot %s into %s", snap.ID, target)
+	return 0
+}
+
+// runSnapshots lists the snapshots stored in the repository.
+func runSnapshots(cfg config.Config, args []string) int {
+	fs := flag.NewFlagSet("snapshots", flag.ContinueOnError)
+	project := fs.String("project", "", "Only list snapshots of this project")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	repo, err := repository.OpenReadOnly(cfg.Repository.Path)
+	if err != nil {
+		logutil.Error("Failed to open repository: %v", err)
+		return 1
+	}
+	defer repo.Close()
+
+	snapshots, err := repo.Snapshots(*project)
+	if err != nil {
+		logutil.Error("Failed to list snapshots: %v", err)
+		return 1
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tPROJECT\tTIME\tFILES\tSIZE")
+	for _, s := range snapshots {
+		files := 0
+		for _, n := range s.Nodes {
+			if n.Type == repository.NodeFile {
+				files++
+			}
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\n", s.ID, s.Project, s.Time.Local().Format("2006-01-02 15:04:05"), files, util.FormatBytes(s.Size))
+	}
+	w.Flush()
+	return 0
+}
+
+// runPrune removes snapshots outside the retention policy and garbage collects unreferenced chunks.
+func runPrune(cfg config.Config, args []string) int {
+	fs := flag.NewFlagSet("prune", flag.ContinueOnError)
+	keepLast := fs.Int("keep-last", cfg.Repository.KeepLast, "Keep the newest N snapshots of each project")
+	keepDays := fs.Int("keep-days", cfg.Repository.KeepDays, "Keep snapshots younger than N days")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	repo, err := repository.Open(cfg.Repository.Path)
+	if err != nil {
+		logutil.Error("Failed to open repository: %v", err)
+		return 1
+	}
+	defer repo.Close()
+
+	prefix := "Removed"
+	if cfg.DryRun {
+		prefix = "[DRY RUN] Would remove"
+	}
+
+	removed, err := repo.Forget(repository.Policy{KeepLast: *keepLast, KeepDays: *keepDays}, cfg.DryRun)
+	for _, s := range removed {
+		logutil.Info("%s snapshot %s of %s (%s)", prefix, s.ID, s.Project, s.Time.Local().Format(time.RFC3339))
+	}
+	if err != nil {
+		logutil.Error("Prune failed: %v", err)
+		return 1
+	}
+
+	count, freed, err := repo.GarbageCollect(cfg.DryRun)
+	if err != nil {
+		logutil.Error("Garbage collection failed: %v", err)
+		return 1
+	}
+	logutil.Success("%s %d snapshot(s) and %d unreferenced chunk(s), %s freed.", prefix, len(removed), count, util.FormatBytes(freed))
+	return 0
+}
+
+// runCheck verifies the repository structure and, optionally, the chunk contents.
+func runCheck(cfg config.Config, args []string) int {
+	fs := flag.NewFlagSet("check", flag.ContinueOnError)
+	readData := fs.Bool("read-data", false, "Re-hash every referenced chunk")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	repo, err := repository.OpenReadOnly(cfg.Repository.Path)
+	if err != nil {
+		logutil.Error("Failed to open repository: %v", err)
+		return 1
+	}
+	defer repo.Close()
+
+	res, err := repo.Check(*readData)
+	if err != nil {
+		logutil.Error("Check failed: %v", err)
+		return 1
+	}
+	logutil.Info("Checked %d snapshot(s) and %d chunk(s), %d unreferenced.", res.Snapshots, res.Chunks, res.UnusedChunks)
+	for _, id := range res.BrokenSnapshot {
+		logutil.Error("Snapshot %s cannot be read.", id)
+	}
+	for _, hash := range res.MissingChunks {
+		logutil.Error("Chunk %s is referenced but missing.", hash)
+	}
+	for _, hash := range res.CorruptChunks {
+		logutil.Error("Chunk %s is corrupt.", hash)
+	}
+	if !res.OK() {
+		return 1
+	}
+	logutil.Success("Repository %s is consistent.", cfg.Repository.Path)
+	return 0
+}
+
+// runNotifyTest sends a sample run summary to every notification provider.
+func runNotifyTest(cfg config.Config, args []string) int {
+	notifier, err := notify.New(cfg.Notify)
+	if err != nil {
+		logutil.Error("Invalid notification configuration: %v", err)
+		return 1
+	}
+	if notifier == nil {
+		logutil.Error("No notification providers configured (notify.providers).")
+		return 1
+	}
+	if err := notifier.Test(context.Background()); err != nil {
+		return 1
+	}
+	logutil.Success("Test notification sent to %d provider(s).", len(cfg.Notify.Providers))
+	return 0
+}
+
+// runHistory lists past backups from the catalog, newest first.
+func runHistory(cfg config.Config, args []string) int {
+	fs := flag.NewFlagSet("history", flag.ContinueOnError)
+	project := fs.String("project", "", "Only show backups of this project")
+	failed := fs.Bool("failed", false, "Only show failed backups")
+	since := fs.String("since", "", "Only show backups since a date (2006-01-02) or within a duration (36h, 30d)")
+	until := fs.String("until", "", "Only show backups before a date (2006-01-02) or duration ago")
+	limit := fs.Int("limit", 50, "Show at most N backups, 0 for all")
+	runs := fs.Bool("runs", false, "Show whole runs instead of one line per project")
+	jsonOutput := fs.Bool("json", false, "Print JSON instead of a table")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	filter := catalog.Filter{Project: *project, Failed: *failed, Limit: *limit}
+	var err error
+	if filter.Since, err = parseTimeArg(*since); err != nil {
+		logutil.Error("Invalid --since: %v", err)
+		return 2
+	}
+	if filter.Until, err = parseTimeArg(*until); err != nil {
+		logutil.Error("Invalid --until: %v", err)
+		return 2
+	}
+
+	c, err := catalog.OpenReadOnly(cfg.Catalog.Path)
+	if err != nil {
+		logutil.Error("%v", err)
+		return 1
+	}
+	defer c.Close()
+
+	if *runs {
+		list, err := c.Runs(filter)
+		if err != nil {
+			logutil.Error("Failed to read catalog: %v", err)
+			return 1
+		}
+		if *jsonOutput {
+			return printJSON(list)
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "RUN\tSTART\tDURATION\tPROJECTS\tFAILED\tSTATUS")
+		for _, r := range list {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%d\t%s\n", r.ID, r.Start.Local().Format("2006-01-02 15:04:05"),
+				r.End.Sub(r.Start).Round(time.Second), r.Projects, r.Failed, status(r.Success))
+		}
+		w.Flush()
+		return 0
+	}
+
+	backups, err := c.Backups(filter)
+	if err != nil {
+		logutil.Error("Failed to read catalog: %v", err)
+		return 1
+	}
+	if *jsonOutput {
+		return printJSON(backups)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "TIME\tPROJECT\tSTATUS\tDURATION\tARCHIVE\tSIZE\tERROR")
+	for _, b := range backups {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", b.Time.Local().Format("2006-01-02 15:04:05"), b.Project, status(b.Success),
+			b.Duration.Round(time.Second), b.Archive, util.FormatBytes(b.Size), b.Error)
+	}
+	w.Flush()
+	return 0
+}
+
+// runList shows the latest successful backup of each project from the catalog.
+func runList(cfg config.Config, args []string) int {
+	fs := flag.NewFlagSet("list", flag.ContinueOnError)
+	project := fs.String("project", "", "Only show backups of this project")
+	all := fs.Bool("all", false, "Show every successful backup instead of the latest per project")
+	jsonOutput := fs.Bool("json", false, "Print JSON instead of a table")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	c, err := catalog.OpenReadOnly(cfg.Catalog.Path)
+	if err != nil {
+		logutil.Error("%v", err)
+		return 1
+	}
+	defer c.Close()
+
+	var backups []catalog.Backup
+	if *all {
+		backups, err = c.Backups(catalog.Filter{Project: *project, Success: true})
+	} else {
+		backups, err = c.Latest(*project)
+	}
+	if err != nil {
+		logutil.Error("Failed to read catalog: %v", err)
+		return 1
+	}
+	if *jsonOutput {
+		return printJSON(backups)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "PROJECT\tTIME\tPATH\tSIZE\tFILES\tDESTINATIONS\tSHA256")
+	for _, b := range backups {
+		var destinations []string
+		for _, t := range b.Destinations {
+			if t.Error == "" {
+				destinations = append(destinations, t.Destination)
+			}
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%s\t%s\n", b.Project, b.Time.Local().Format("2006-01-02 15:04:05"), b.Path,
+			util.FormatBytes(b.Size), b.Files, strings.Join(destinations, ","), b.Checksum)
+	}
+	w.Flush()
+	return 0
+}
+
+// runConfig handles the config subcommands; 'config check' prints the effective
+// configuration with the source of every value and checks the paths it uses.
+// Unknown keys and invalid values found by config.LoadConfig are passed in as problems.
+func runConfig(cfg config.Config, problems []config.Problem, args []string) int {
+	if len(args) == 0 || args[0] != "check" {
+		logutil.Error("Usage: backup-tool [global flags] config check [--changed] [--json]")
+		return 2
+	}
+	fs := flag.NewFlagSet("config check", flag.ContinueOnError)
+	changed := fs.Bool("changed", false, "Only show settings that differ from the defaults")
+	jsonOutput := fs.Bool("json", false, "Print JSON instead of a table")
+	if err := fs.Parse(args[1:]); err != nil {
+		return 2
+	}
+
+	settings, err := cfg.Settings()
+	if err != nil {
+		logutil.Error("Failed to list settings: %v", err)
+		return 1
+	}
+	if *changed {
+		var set []config.Setting
+		for _, s := range settings {
+			if s.Source != config.SourceDefault {
+				set = append(set, s)
+			}
+		}
+		settings = set
+	}
+	if *jsonOutput {
+		if code := printJSON(settings); code != 0 {
+			return code
+		}
+	} else {
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "KEY\tVALUE\tSOURCE")
+		for _, s := range settings {
+			fmt.Fprintf(w, "%s\t%s\t%s\n", s.Key, s.Value, s.Source)
+		}
+		w.Flush()
+	}
+
+	errors := len(problems)
+	reported := make(map[string]bool)
+	for _, p := range problems {
+		logutil.Error("%s", p)
+		reported[p.String()] = true
+	}
+	for _, p := range cfg.CheckPaths() {
+		if p.Warning {
+			logutil.Warn("%s", p)
+		} else {
+			logutil.Error("%s", p)
+			errors++
+		}
+	}
+	// The settings above are those of one profile; the others are at least loaded.
+	// Problems they share with it, e.g. in the base configuration, are reported once.
+	for _, name := range append([]string{""}, cfg.Profiles...) {
+		if name == cfg.Profile {
+			continue
+		}
+		label := "Base configuration"
+		if name != "" {
+			label = fmt.Sprintf("Profile '%s'", name)
+		}
+		_, profileProblems, err := config.LoadProfile(name)
+		if err != nil {
+			logutil.Error("%s: %v", label, err)
+			errors++
+		}
+		for _, p := range profileProblems {
+			if reported[p.String()] {
+				continue
+			}
+			reported[p.String()] = true
+			logutil.Error("%s: %s", label, p)
+			errors++
+		}
+	}
+	if errors > 0 {
+		logutil.Error("Configuration has %d problem(s).", errors)
+		return 1
+	}
+	logutil.Success("Configuration is valid.")
+	return 0
+}
+
+func status(success bool) string {
+	if success {
+		return "OK"
+	}
+	return "FAILED"
+}
+
+func printJSON(v interface{}) int {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		logutil.Error("Failed to write JSON: %v", err)
+		return 1
+	}
+	return 0
+}
+
+// parseTimeArg parses a date (2006-01-02), an RFC 3339 time or a duration before now
+// such as 36h or 30d. An empty string returns the zero time.
+func parseTimeArg(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", s, time.Local); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		if n, err := strconv.Atoi(days); err == nil {
+			return time.Now().AddDate(0, 0, -n), nil
+		}
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("'%s' is neither a date (2006-01-02) nor a duration (36h, 30d)", s)
+	}
+	return time.Now().Add(-d), nil
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"docker-backup-tool/internal/config"
+	"docker-backup-tool/internal/logutil"
+
+	"github.com/robfig/cron/v3"
+)
+
+// scheduledJob is a backup job run by the daemon on its own schedule.
+type scheduledJob struct {
+	job        *backupJob
+	spec       string
+	schedule   cron.Schedule
+	runOnStart bool
+}
+
+// name describes the backup of the job in log messages.
+func (s scheduledJob) name() string {
+	if s.job.cfg.Profile == "" {
+		return "backup"
+	}
+	return fmt.Sprintf("backup of profile '%s'", s.job.cfg.Profile)
+}
+
+// runDaemon keeps running and starts a backup on every tick of the cron schedule.
+// Started without --profile, it also runs every profile of the config file that sets
+// its own daemon.schedule. With metrics.listen set it also serves /metrics. SIGINT and
+// SIGTERM stop the daemon after a running backup has finished, so no stack is left stopped.
+func runDaemon(job *backupJob, args []string) int {
+	fs := flag.NewFlagSet("daemon", flag.ContinueOnError)
+	schedule := fs.String("schedule", job.cfg.Daemon.Schedule, "Cron expression for backup runs, e.g. \"0 3 * * *\" or \"@daily\"")
+	runOnStart := fs.Bool("run-on-start", job.cfg.Daemon.RunOnStart, "Run a backup immediately after starting")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	flagSet := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { flagSet[f.Name] = true })
+
+	var jobs []scheduledJob
+	add := func(job *backupJob, spec string, runOnStart bool) bool {
+		sched, err := cron.ParseStandard(spec)
+		if err != nil {
+			logutil.Error("Invalid schedule '%s' for the %s: %v", spec, scheduledJob{job: job}.name(), err)
+			return false
+		}
+		jobs = append(jobs, scheduledJob{job: job, spec: spec, schedule: sched, runOnStart: runOnStart})
+		return true
+	}
+	if *schedule != "" && !add(job, *schedule, *runOnStart) {
+		return 2
+	}
+	// The profiles are loaded from the same file, environment and flags; logging and
+	// metrics stay those of the configuration the daemon was started with
+	if job.cfg.Profile == "" && !flagSet["schedule"] {
+		for _, name := range job.cfg.ScheduledProfiles {
+			cfg, problems, err := config.LoadProfile(name)
+			if err != nil {
+				logutil.Error("Error loading profile '%s': %v", name, err)
+				return 2
+			}
+			for _, p := range problems {
+				logutil.Error("Profile '%s': %s", name, p)
+			}
+			if len(problems) > 0 {
+				return 2
+			}
+			if !checkPaths(cfg) {
+				logutil.Error("Invalid configuration of profile '%s', see the errors above.", name)
+				return 2
+			}
+			profileJob, err := newBackupJob(cfg, job.metrics)
+			if err != nil {
+				logutil.Error("Failed to set up the backup of profile '%s': %v", name, err)
+				return 2
+			}
+			if !add(profileJob, cfg.Daemon.Schedule, cfg.Daemon.RunOnStart || flagSet["run-on-start"] && *runOnStart) {
+				return 2
+			}
+		}
+	}
+	if len(jobs) == 0 {
+		logutil.Error("The daemon needs a schedule (daemon.schedule, DOCKER_BACKUP_SCHEDULE, --schedule or a profile with daemon.schedule).")
+		return 2
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	var server *http.Server
+	if job.cfg.Metrics.Listen != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", job.metrics.Handler())
+		server = &http.Server{Addr: job.cfg.Metrics.Listen, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+		go func() {
+			if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				logutil.Error("Metrics server on %s failed: %v", job.cfg.Metrics.Listen, err)
+			}
+		}()
+		logutil.Info("Serving metrics on %s/metrics", job.cfg.Metrics.Listen)
+	}
+
+	// A run that is still going when the next tick of its schedule arrives makes that
+	// tick a no-op. Runs of different schedules wait for each other, so no two runs stop
+	// the same stacks. Runs use their own context: stopping a backup halfway would leave
+	// stacks down.
+	var running sync.Mutex
+	c := cron.New()
+	var starts []func()
+	for _, j := range jobs {
+		var pending sync.Mutex
+		tick := func() {
+			if !pending.TryLock() {
+				logutil.Warn("Skipping scheduled %s: the previous run is still in progress.", j.name())
+				return
+			}
+			defer pending.Unlock()
+			running.Lock()
+			defer running.Unlock()
+			if ctx.Err() != nil {
+				return // Waited for another run while the daemon was stopped
+			}
+			if j.job.cfg.Profile != "" {
+				logutil.Info("Starting scheduled %s", j.name())
+			}
+			j.job.run(context.Background())
+		}
+		c.Schedule(j.schedule, cron.FuncJob(func() {
+			tick()
+			logutil.Info("Next %s at %s", j.name(), j.schedule.Next(time.Now()).Format(time.RFC1123))
+		}))
+		logutil.Info("Daemon started with schedule '%s'. Next %s at %s", j.spec, j.name(), j.schedule.Next(time.Now()).Format(time.RFC1123))
+		if j.runOnStart {
+			starts = append(starts, tick)
+		}
+	}
+	c.Start()
+	go func() {
+		for _, tick := range starts {
+			tick()
+		}
+	}()
+
+	<-ctx.Done()
+	logutil.Info("Shutting down, waiting for a running backup to finish...")
+	<-c.Stop().Done()
+	running.Lock() // Also covers the runs started by run-on-start
+	if server != nil {
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}
+	logutil.Info("Daemon stopped.")
+	return 0
+}
@@ -1,21 +1,31 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"fmt"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strings"
+	"time"
 
 	// Use the actual module path defined in go.mod
 	"docker-backup-tool/internal/config"
 	// Import the new discovery package
 	"docker-backup-tool/internal/backup"
+	"docker-backup-tool/internal/catalog"
 	"docker-backup-tool/internal/discovery"
+	"docker-backup-tool/internal/encryption"
 
 	// Import the docker package
+	"docker-backup-tool/internal/destination"
 	"docker-backup-tool/internal/docker"
-	// Import the rsync package
-	"docker-backup-tool/internal/rsync"
+	"docker-backup-tool/internal/metrics"
+	"docker-backup-tool/internal/notify"
+	"docker-backup-tool/internal/report"
+	"docker-backup-tool/internal/util"
 	// Import the pflag package
 	// "github.com/spf13/pflag"
 
@@ -26,21 +36,60 @@ import (
 var dockerComposeCmd string
 
 func main() {
-	cfg, err := config.LoadConfig()
+	cfg, problems, err := config.LoadConfig()
 	if err != nil {
 		logutil.Fatal("Error loading configuration: %v", err)
 	}
 
 	// --- Initialize Logger ---
-	logutil.Init(
-		cfg.LogFile,
-		cfg.Verbose,
-		cfg.LogRotationMaxSizeMB,
-		cfg.LogRotationMaxBackups,
-		cfg.LogRotationMaxAgeDays,
-		cfg.LogRotationCompress,
-	)
+	if args := flag.Args(); len(args) > 0 && dataCommands[args[0]] {
+		logutil.ConsoleToStderr()
+	}
+	logutil.Init(logutil.Options{
+		LogFile:      cfg.LogFile,
+		ConsoleLevel: cfg.ConsoleLogLevel,
+		FileLevel:    cfg.FileLogLevel,
+		Quiet:        cfg.Quiet,
+		MaxSizeMB:    cfg.LogRotationMaxSizeMB,
+		MaxBackups:   cfg.LogRotationMaxBackups,
+		MaxAgeDays:   cfg.LogRotationMaxAgeDays,
+		Compress:     cfg.LogRotationCompress,
+		Format:       cfg.LogFormat,
+	})
 	defer logutil.Close() // Ensure log file is closed on exit
+	if cfg.ConfigFile != "" {
+		logutil.Info("Using configuration file: %s", cfg.ConfigFile)
+		if cfg.Profile != "" {
+			logutil.Info("Using profile: %s", cfg.Profile)
+		}
+	} else {
+		logutil.Info("No configuration file found. Using defaults/env/flags.")
+	}
+
+	// An invalid configuration stops everything but 'config', which shows the problems
+	// together with the settings and where they came from
+	if args := flag.Args(); len(args) == 0 || args[0] != "config" {
+		for _, p := range problems {
+			logutil.Error("%s", p)
+		}
+		if len(problems) > 0 {
+			logutil.Fatal("Invalid configuration, see the errors above. Run 'backup-tool config check' to review the settings.")
+		}
+	}
+
+	// --- Commands ---
+	// Anything left after the global flags selects a command; without one a backup run starts.
+	// The daemon command needs the same setup as a backup run and is started further down.
+	if args := flag.Args(); len(args) > 0 && args[0] != "daemon" {
+		code := runCommand(cfg, problems, args[0], args[1:])
+		logutil.Close()
+		os.Exit(code)
+	}
+
+	// Paths are only checked for backups; the other commands may run on another host
+	if !checkPaths(cfg) {
+		logutil.Fatal("Invalid configuration, see the errors above. Run 'backup-tool config check' to review the settings.")
+	}
 
 	// Dependency Checks
 	// Docker Compose
@@ -66,21 +115,30 @@ func main() {
 		logutil.Fatal("Docker Compose command not found. Please install Docker Compose (v1 or v2).")
 	}
 
-	// Rsync (only if enabled)
-	if cfg.Rsync.Enabled {
-		if _, err := exec.LookPath(cfg.Rsync.Command); err != nil {
-			logutil.Fatal("Rsync command '%s' not found in PATH. Please install rsync or correct the rsync.command configuration.", cfg.Rsync.Command)
+	ctx := context.Background()
+	var metricsStore *metrics.Store
+	if cfg.Metrics.Textfile != "" || cfg.Metrics.Listen != "" {
+		if metricsStore, err = metrics.Open(cfg.Metrics.StateFile); err != nil {
+			logutil.Fatal("Failed to read metrics state: %v", err)
 		}
-		logutil.Info("Rsync enabled. Using command: %s", cfg.Rsync.Command)
+	}
+	job, err := newBackupJob(cfg, metricsStore)
+	if err != nil {
+		logutil.Fatal("Failed to set up the backup: %v", err)
 	}
 
-	// Optionally print loaded config if verbose
+	// Optionally print loaded config if verbose; secrets are masked like in 'config check'
 	if cfg.Verbose {
-		logutil.Info("Configuration loaded: %+v", cfg)
+		if settings, err := cfg.Settings(); err == nil {
+			logutil.Info("Configuration loaded:")
+			for _, s := range settings {
+				logutil.Info("  %s: %s (%s)", s.Key, s.Value, s.Source)
+			}
+		}
 	}
 
 	// Always log basic paths
-	logutil.Info("Using Compose Dir: %s", cfg.ComposeDir)
+	logutil.Info("Using Compose Dir: %s", strings.Join(cfg.ComposeRoots(), ", "))
 	logutil.Info("Using Appdata Dir: %s", cfg.AppdataDir)
 	logutil.Info("Using Backup Dir : %s", cfg.BackupDir)
 
@@ -89,53 +147,307 @@ func main() {
 		logutil.Warn("--- DRY RUN MODE ENABLED --- Actions will be logged but not executed.")
 	}
 
+	if args := flag.Args(); len(args) > 0 && args[0] == "daemon" {
+		code := runDaemon(job, args[1:])
+		logutil.Close()
+		os.Exit(code)
+	}
+	if !job.run(ctx) {
+		logutil.Close()
+		os.Exit(1)
+	}
+}
+
+// backupJob holds everything a backup run needs. It is set up once in main and
+// reused by every scheduled run in daemon mode.
+type backupJob struct {
+	cfg           config.Config
+	targets       []destination.Target
+	mirrorTargets []*destination.Rsync
+	notifier      *notify.Notifier
+	metrics       *metrics.Store
+}
+
+// newBackupJob sets up the destinations, notifications and encryption of cfg. Daemon
+// mode creates one job per scheduled profile; metricsStore is shared by all of them.
+func newBackupJob(cfg config.Config, metricsStore *metrics.Store) (*backupJob, error) {
+	// Destinations. A missing rsync binary only fails the rsync destinations, the others still work.
+	targets, err := destination.FromConfig(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("invalid destination configuration: %v", err)
+	}
+	for _, target := range targets {
+		if r, ok := target.Destination.(*destination.Rsync); ok {
+			if _, err := exec.LookPath(r.Command()); err != nil {
+				logutil.Error("Rsync command '%s' not found in PATH. Uploads to '%s' will fail; install rsync, correct the rsync command or use an sftp destination instead.", r.Command(), r.Name())
+			}
+		}
+		logutil.Info("Destination enabled: %s", target.Destination.Name())
+	}
+
+	// Mirror mode syncs the appdata directories to the rsync destinations instead of creating archives
+	var mirrorTargets []*destination.Rsync
+	if cfg.Mode == config.ModeMirror {
+		for _, target := range targets {
+			if r, ok := target.Destination.(*destination.Rsync); ok {
+				mirrorTargets = append(mirrorTargets, r)
+			} else {
+				logutil.Warn("Destination '%s' is ignored in mirror mode (only rsync destinations can mirror directories).", target.Destination.Name())
+			}
+		}
+		if len(mirrorTargets) == 0 {
+			return nil, errors.New("mirror mode requires at least one rsync destination")
+		}
+		targets = nil // Nothing is archived, so there is nothing to upload or sync afterwards
+		logutil.Info("Mirror mode enabled. Appdata is synced to %d rsync destination(s) without creating archives.", len(mirrorTargets))
+	}
+
+	notifier, err := notify.New(cfg.Notify)
+	if err != nil {
+		return nil, fmt.Errorf("invalid notification configuration: %v", err)
+	}
+
+	// Encryption keys are checked up front so a typo does not fail every project
+	if cfg.Encryption.Enabled {
+		if _, err := encryption.Recipients(cfg.Encryption); err != nil {
+			return nil, fmt.Errorf("invalid encryption configuration: %v", err)
+		}
+		logutil.Info("Encryption enabled. Archives are written as *.zip%s", encryption.Extension)
+	}
+	return &backupJob{cfg: cfg, targets: targets, mirrorTargets: mirrorTargets, notifier: notifier, metrics: metricsStore}, nil
+}
+
+// checkPaths logs the problems found by cfg.CheckPaths and reports whether there
+// were no errors.
+func checkPaths(cfg config.Config) bool {
+	ok := true
+	for _, p := range cfg.CheckPaths() {
+		if p.Warning {
+			logutil.Warn("%s", p)
+		} else {
+			logutil.Error("%s", p)
+			ok = false
+		}
+	}
+	return ok
+}
+
+// run backs up all projects, then sends notifications and updates the metrics.
+// It reports whether everything succeeded.
+func (j *backupJob) run(ctx context.Context) bool {
+	defer logutil.SetRun("")
+	run, success := j.backup(ctx)
+	if run == nil {
+		return false
+	}
+	logutil.SetPhase("notify")
+	if j.notifier != nil {
+		if err := j.notifier.Notify(ctx, run, j.cfg.DryRun); err != nil {
+			logutil.Warn("One or more notifications could not be sent.")
+		}
+	}
+	logutil.SetPhase("record")
+	if j.cfg.Catalog.Enabled && !run.DryRun {
+		if err := recordRun(j.cfg.Catalog.Path, run); err != nil {
+			logutil.Warn("Failed to record the run in the catalog: %v", err)
+		}
+	}
+	if j.metrics != nil && !run.DryRun {
+		j.metrics.Update(run)
+		if err := j.metrics.Save(); err != nil {
+			logutil.Warn("Failed to save metrics state: %v", err)
+		}
+		if j.cfg.Metrics.Textfile != "" {
+			if err := j.metrics.WriteTextfile(j.cfg.Metrics.Textfile); err != nil {
+				logutil.Warn("Failed to write metrics to %s: %v", j.cfg.Metrics.Textfile, err)
+			}
+		}
+	}
+	return success
+}
+
+// recordRun adds a finished run to the catalog. The catalog is only opened for this
+// so the history commands can read it while a daemon waits for the next run.
+func recordRun(path string, run *report.Report) error {
+	c, err := catalog.Open(path)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	return c.Add(run)
+}
+
+// projectSettings converts the 'projects' config section for discovery.
+func projectSettings(cfg config.Config) map[string]discovery.Settings {
+	settings := make(map[string]discovery.Settings, len(cfg.Projects))
+	for key, p := range cfg.Projects {
+		settings[key] = discovery.Settings{
+			ComposeFiles: p.ComposeFiles,
+			Profiles:     p.Profiles,
+			EnvFiles:     p.EnvFile,
+			ProjectName:  p.ProjectName,
+		}
+	}
+	return settings
+}
+
+// discoverProjects finds the projects to back up in the compose roots and, depending on
+// the discovery mode, in the labels of existing containers. Stacks known to Docker whose
+// files cannot be reached are reported, running ones as warnings. Projects sharing a
+// name are returned separately, since none of them can be backed up.
+func discoverProjects(cfg config.Config) ([]discovery.Project, []discovery.Skipped, error) {
+	settings := projectSettings(cfg)
+	var projects []discovery.Project
+	if cfg.DiscoveryMode != config.DiscoveryContainers {
+		var err error
+		projects, err = discovery.FindComposeProjects(cfg.ComposeRoots(), discovery.Options{MaxDepth: cfg.DiscoveryDepth, Projects: settings})
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	if cfg.DiscoveryMode == config.DiscoveryDirectories {
+		projects, duplicates := discovery.Duplicates(projects)
+		return projects, duplicates, nil
+	}
+
+	labelled, err := docker.ListComposeProjects()
+	if err != nil {
+		if cfg.DiscoveryMode == config.DiscoveryContainers {
+			return nil, nil, fmt.Errorf("failed to list compose projects from Docker: %w", err)
+		}
+		logutil.Warn("Failed to list compose projects from Docker, using the compose directories only: %v", err)
+		projects, duplicates := discovery.Duplicates(projects)
+		return projects, duplicates, nil
+	}
+	scanned := len(projects)
+	projects, unavailable := discovery.MergeContainers(projects, labelled, settings)
+	if added := len(projects) - scanned; added > 0 && cfg.DiscoveryMode == config.DiscoveryBoth {
+		logutil.Info("Found %d project(s) outside the compose directories through their containers.", added)
+	}
+	for _, u := range unavailable {
+		if u.Project.Running {
+			logutil.Warn("Running project %s cannot be backed up: %s.", u.Project.Name, u.Reason)
+		} else {
+			logutil.Debug("Stopped project %s cannot be backed up: %s.", u.Project.Name, u.Reason)
+		}
+	}
+	projects, duplicates := discovery.Duplicates(projects)
+	return projects, duplicates, nil
+}
+
+// backup discovers the projects and backs up each of them. The report is nil if
+// no project could be discovered.
+func (j *backupJob) backup(ctx context.Context) (*report.Report, bool) {
+	cfg, targets, mirrorTargets := j.cfg, j.targets, j.mirrorTargets
+
+	run := report.New(cfg.DryRun)
+	logutil.SetRun(run.ID)
+
 	// --- Discover Projects ---
+	logutil.SetPhase("discover")
 	logutil.Info("Starting project discovery...")
 
-	projects, err := discovery.FindComposeProjects(cfg.ComposeDir)
+	projects, duplicates, err := discoverProjects(cfg)
 	if err != nil {
-		logutil.Fatal("Error finding compose projects in '%s': %v", cfg.ComposeDir, err)
+		logutil.Error("Error finding compose projects: %v", err)
+		return nil, false
+	}
+	for _, d := range duplicates {
+		logutil.Error("Cannot back up %s: %s.", d.Project.Path, d.Reason)
 	}
 
-	if len(projects) == 0 {
-		logutil.Fatal("No Docker Compose projects found in %s. Exiting.", cfg.ComposeDir)
+	if len(projects) == 0 && len(duplicates) == 0 {
+		logutil.Error("No Docker Compose projects found (discovery: %s, compose dirs: %s).", cfg.DiscoveryMode, strings.Join(cfg.ComposeRoots(), ", "))
+		return nil, false
 	}
 
 	logutil.Info("Discovered %d projects:", len(projects))
+	for _, p := range projects {
+		logutil.Info("    - %s (%s)", p.Name, p.Path)
+	}
+
+	filter := discovery.Filter{Include: cfg.IncludeProjects, Exclude: cfg.ExcludeProjects}
+	var conflicting []discovery.Project
+	for _, d := range duplicates {
+		conflicting = append(conflicting, d.Project)
+	}
+	for _, pattern := range filter.Unmatched(append(conflicting, projects...)) {
+		logutil.Warn("No project matches '%s'.", pattern)
+	}
+	projects, skipped := filter.Select(projects)
+	for _, s := range skipped {
+		logutil.Info("Skipping project %s: %s.", s.Project.Name, s.Reason)
+	}
 
 	// --- Main Processing Loop ---
 	backupSuccess := true // Track overall success
 	failedProjects := 0
 	successfulProjects := 0
 
+	// Selected projects whose name is shared fail without being touched
+	for _, d := range duplicates {
+		if selected, _ := filter.Select([]discovery.Project{d.Project}); len(selected) == 0 {
+			continue
+		}
+		projectReport := run.AddProject(d.Project.Name)
+		projectReport.Fail("%s", d.Reason)
+		projectReport.Finish()
+		backupSuccess = false
+		failedProjects++
+	}
+	if len(projects) == 0 && failedProjects == 0 {
+		logutil.Error("All %d discovered projects were skipped, nothing to back up.", len(skipped))
+		return nil, false
+	}
+	if len(skipped) > 0 {
+		logutil.Info("Backing up %d of %d projects.", len(projects), len(projects)+len(skipped))
+	}
+
 	for _, project := range projects {
 		projectName := project.Name
+		logutil.SetProject(projectName)
 		projectFailed := false // Track individual project failure
+		projectReport := run.AddProject(projectName)
+		projectLog := startProjectLog(cfg, projectName, run.ID)
 		logutil.Info("=== Processing Project: %s ===", projectName)
 
+		// Stop, check and volume parsing see the same services: with volumes_all_profiles
+		// the volumes of inactive profiles are backed up, so their services are stopped too
+		compose := project.Compose()
+		if cfg.VolumesAllProfiles {
+			compose = compose.WithAllProfiles()
+		}
+
 		// 1. Stop Stack
+		logutil.SetPhase("stop")
+		var stoppedAt time.Time // Start of the downtime reported in metrics
 		if cfg.DryRun {
 			logutil.Info("[DRY RUN] Would stop stack for project %s (path: %s)", projectName, project.Path)
 		} else {
 			logutil.Info("[%s] Stopping stack...", projectName)
-			if err := docker.Down(project.Path, dockerComposeCmd); err != nil {
+			stoppedAt = time.Now()
+			if err := docker.Down(compose, dockerComposeCmd); err != nil {
 				logutil.Error("Error stopping stack for project %s: %v", projectName, err)
+				projectReport.Fail("stopping stack: %v", err)
 				projectFailed = true
 				// Don't continue yet, still try to backup compose files etc.
 			}
 		}
 
 		// 2. Verify Stack Down
+		logutil.SetPhase("verify")
 		if !projectFailed { // Only check if stop didn't already report an error
 			if !cfg.DryRun {
 				// --- Execute real verification only if not in dry run ---
 				logutil.Info("[%s] Verifying stack is down...", projectName)
-				running, err := docker.PsQuiet(project.Path, dockerComposeCmd)
+				running, err := docker.PsQuiet(compose, dockerComposeCmd)
 				if err != nil {
 					logutil.Error("ERROR: Failed to check stack status for %s: %v. Skipping backup steps.", projectName, err)
+					projectReport.Fail("checking stack status: %v", err)
 					projectFailed = true
 				} else if running {
 					logutil.Error("ERROR: Stack for %s is still running after 'down' command. Skipping backup steps.", projectName)
+					projectReport.Fail("stack still running after 'down'")
 					projectFailed = true
 				} else {
 					logutil.Info("Stack verified down for %s.", projectName)
@@ -157,17 +469,16 @@ func main() {
 		} // else: Real verification logic handled above within the !cfg.DryRun block
 
 		// 3. Parse Volumes
+		logutil.SetPhase("volumes")
 		var appdataPaths []string
 		if !projectFailed { // Only parse if stack is confirmed down
-			logutil.Info("[%s] Parsing compose file %s for appdata volumes...", projectName, project.ComposeFilePath)
-			appdataPaths, err = backup.ParseVolumes(project.ComposeFilePath, cfg.AppdataDir, dockerComposeCmd)
+			logutil.Info("[%s] Parsing compose file(s) %s for appdata volumes...", projectName, strings.Join(project.ComposeFiles, ", "))
+			appdataPaths, err = backup.ParseVolumes(compose, cfg.AppdataDir, dockerComposeCmd)
 			if err != nil {
 				logutil.Error("ERROR: Failed to parse volumes from %s: %v. Backup will not include appdata.", project.ComposeFilePath, err)
 				// Continue without appdata, don't fail the whole project for this.
 			}
-			if cfg.Verbose {
-				logutil.Debug("[DEBUG Appdata] Parsed appdata paths: %v", appdataPaths) // Use Debug for verbose
-			}
+			logutil.Debug("[DEBUG Appdata] Parsed appdata paths: %v", appdataPaths)
 			if len(appdataPaths) > 0 {
 				logutil.Info("Found %d appdata paths to include.", len(appdataPaths)) // Keep as Info
 				for _, ap := range appdataPaths {
@@ -179,8 +490,34 @@ func main() {
 		}
 
 		// 4. Create Backup
+		logutil.SetPhase("archive")
 		var backupFile string
-		if !projectFailed { // Only create if stack is confirmed down or dry run
+		if cfg.Mode == config.ModeMirror {
+			if projectFailed {
+				logutil.Warn("[%s] Skipping mirror because previous steps failed.", projectName)
+			}
+			for _, r := range mirrorTargets {
+				if projectFailed {
+					break
+				}
+				if cfg.DryRun {
+					logutil.Info("[DRY RUN] Would mirror %s and %d appdata path(s) to '%s' (%d exclude patterns).", project.Path, len(appdataPaths), r.Name(), len(cfg.Exclude))
+					continue
+				}
+				logutil.Info("[%s] Mirroring to '%s'...", projectName, r.Name())
+				stats, err := backup.Mirror(ctx, projectName, project.Path, appdataPaths, r.Config(), cfg)
+				transfer := report.Transfer{Destination: r.Name(), Files: stats.Files, Bytes: stats.Bytes, Duration: stats.Duration, Attempts: stats.Attempts}
+				if err != nil {
+					logutil.Error("ERROR: Mirror to '%s' failed for %s: %v", r.Name(), projectName, err)
+					transfer.Error = err.Error()
+					projectReport.Fail("mirror to '%s': %v", r.Name(), err)
+					projectFailed = true
+				} else {
+					logutil.Success("[%s] Mirrored %d file(s), %s to '%s' in %s.", projectName, stats.Files, util.FormatBytes(stats.Bytes), r.Name(), stats.Duration.Round(time.Second))
+				}
+				projectReport.Transfers = append(projectReport.Transfers, transfer)
+			}
+		} else if !projectFailed { // Only create if stack is confirmed down or dry run
 			if cfg.DryRun {
 				logutil.Info("[DRY RUN] Would create backup for %s.", projectName)
 				logutil.Info("[DRY RUN]   Compose Path: %s", project.Path)
@@ -194,44 +531,94 @@ func main() {
 			} else {
 				logutil.Info("[%s] Creating backup...", projectName)
 				// Pass the full cfg object
-				backupFile, err = backup.CreateBackup(projectName, project.Path, cfg.BackupDir, appdataPaths, cfg)
+				result, err := backup.CreateBackup(projectName, project.Path, cfg.BackupDir, appdataPaths, cfg)
+				backupFile = result.Path
 				if err != nil {
 					logutil.Error("ERROR: Failed to create backup for %s: %v.", projectName, err)
+					projectReport.Fail("creating backup: %v", err)
 					projectFailed = true
 				} else {
 					logutil.Success("Successfully created backup: %s", backupFile) // Use Success
+					projectReport.Archive = filepath.Base(backupFile)
+					projectReport.Files = result.Files
+					projectReport.Path = result.Path
+					projectReport.Checksum = result.Checksum
+					for _, f := range backup.ArchiveFiles(backupFile) {
+						if info, err := os.Stat(f); err == nil {
+							projectReport.ArchiveSize += info.Size()
+						}
+					}
 				}
 			}
 		} else {
 			logutil.Warn("[%s] Skipping backup creation because previous steps failed.", projectName)
 		}
 
-		// --- Rsync Transfer (Optional) ---
-		if cfg.Rsync.Enabled {
+		// --- Transfer to Destinations (Optional) ---
+		logutil.SetPhase("transfer")
+		var uploaded []destination.Destination // Destinations that received the archive, which also get its log
+		for _, target := range targets {
+			dest := target.Destination
+			if r, ok := dest.(*destination.Rsync); ok && r.SyncsBackupDir() {
+				continue // Synced once after all projects
+			}
 			if projectFailed {
-				logutil.Warn("[%s] Skipping rsync because previous steps failed.", projectName)
-			} else if backupFile == "" {
-				logutil.Warn("[%s] Skipping rsync because backup file was not created (likely due to previous errors).", projectName)
-			} else if cfg.Rsync.Destination == "" {
-				logutil.Warn("[%s] Skipping rsync because rsync.destination is not set.", projectName)
+				logutil.Warn("[%s] Skipping transfer to '%s' because previous steps failed.", projectName, dest.Name())
+				continue
+			}
+			if backupFile == "" {
+				logutil.Warn("[%s] Skipping transfer to '%s' because backup file was not created (likely due to previous errors).", projectName, dest.Name())
+				continue
+			}
+			// A snapshot file is useless without its chunks, so repository output syncs the whole repository
+			transferSources := []string{backupFile}
+			if cfg.Output == config.OutputRepository {
+				if _, ok := dest.(destination.DirectoryUploader); !ok {
+					logutil.Warn("[%s] Skipping '%s': repository output can only be transferred with rsync.", projectName, dest.Name())
+					continue
+				}
+				transferSources = []string{cfg.Repository.Path}
+			} else if !cfg.DryRun {
+				transferSources = backup.ArchiveFiles(backupFile) // All parts of a split archive
+			}
+			if cfg.DryRun {
+				logutil.Info("[DRY RUN] Would transfer %s to '%s'.", strings.Join(transferSources, " "), dest.Name())
 			} else {
+				logutil.Info("[%s] Transferring %s to '%s'...", projectName, strings.Join(transferSources, " "), dest.Name())
+				stats, err := destination.UploadAll(ctx, dest, transferSources)
+				transfer := report.Transfer{Destination: dest.Name(), Files: stats.Files, Bytes: stats.Bytes, Duration: stats.Duration, Attempts: stats.Attempts}
+				if err != nil {
+					logutil.Error("ERROR: Transfer to '%s' failed for %s: %v", dest.Name(), projectName, err)
+					transfer.Error = err.Error()
+					projectReport.Transfers = append(projectReport.Transfers, transfer)
+					projectReport.Fail("transfer to '%s': %v", dest.Name(), err)
+					projectFailed = true // Mark project as failed if a transfer fails
+					continue
+				}
+				projectReport.Transfers = append(projectReport.Transfers, transfer)
+				uploaded = append(uploaded, dest)
+				logutil.Success("[%s] Transferred %d file(s), %s to '%s' in %s (%s/s).", projectName, stats.Files, util.FormatBytes(stats.Bytes), dest.Name(), stats.Duration.Round(time.Second), util.FormatBytes(int64(transfer.Speed())))
+			}
+
+			// Retention only runs after a successful upload so a failing destination never loses its last copy
+			if cfg.Output == config.OutputRepository {
+				continue
+			}
+			removed, err := destination.ApplyRetention(ctx, dest, projectName, target.Retention, cfg.DryRun)
+			if err != nil {
+				logutil.Warn("[%s] Retention on '%s' failed: %v", projectName, dest.Name(), err)
+			}
+			for _, name := range removed {
 				if cfg.DryRun {
-					logutil.Info("[DRY RUN] Would transfer %s to %s using rsync.", backupFile, cfg.Rsync.Destination)
-					// Simulate success for dry run by not setting projectFailed=true
+					logutil.Info("[DRY RUN] Would delete %s from '%s' (retention).", name, dest.Name())
 				} else {
-					// --- Execute real rsync only if not in dry run ---
-					logutil.Info("[%s] Rsync enabled. Transferring %s to %s...", projectName, backupFile, cfg.Rsync.Destination)
-					if err := rsync.TransferBackup(cfg, backupFile); err != nil {
-						logutil.Error("ERROR: Rsync transfer failed for %s: %v", projectName, err)
-						projectFailed = true // Mark project as failed if rsync fails
-					} else {
-						logutil.Success("[%s] Rsync transfer successful.", projectName)
-					}
+					logutil.Info("[%s] Deleted %s from '%s' (retention).", projectName, name, dest.Name())
 				}
 			}
 		}
 
 		// 5. Optional: Restart Stack
+		logutil.SetPhase("restart")
 		if cfg.RestartAfterBackup {
 			logutil.Info("[%s] Restart requested.", projectName)
 			if projectFailed {
@@ -247,7 +634,7 @@ func main() {
 					// Restart logic
 					if cfg.PullBeforeRestart {
 						logutil.Info("[%s] Pulling latest images...", projectName)
-						if err := docker.Pull(project.Path, dockerComposeCmd); err != nil {
+						if err := docker.Pull(project.Compose(), dockerComposeCmd); err != nil {
 							logutil.Error("ERROR: Failed to pull images for %s: %v", projectName, err)
 							// Continue to attempt restart even if pull fails
 						} else {
@@ -255,10 +642,12 @@ func main() {
 						}
 					}
 					logutil.Info("[%s] Starting stack...", projectName)
-					if err := docker.UpDetached(project.Path, dockerComposeCmd); err != nil {
+					if err := docker.UpDetached(project.Compose(), dockerComposeCmd); err != nil {
 						logutil.Error("ERROR: Failed to start stack %s after backup: %v", projectName, err)
+						projectReport.Fail("starting stack: %v", err)
 						projectFailed = true // Mark project as failed if restart fails
 					} else {
+						projectReport.Downtime = time.Since(stoppedAt)
 						logutil.Success("[%s] Stack started successfully.", projectName)
 					}
 				}
@@ -266,6 +655,10 @@ func main() {
 		} // End RestartAfterBackup
 
 		// --- Final project status log ---
+		if !stoppedAt.IsZero() && projectReport.Downtime == 0 {
+			projectReport.Downtime = time.Since(stoppedAt) // Not restarted, down at least until now
+		}
+		projectReport.Finish()
 		if projectFailed {
 			backupSuccess = false // Mark overall process as failed
 			failedProjects++
@@ -274,17 +667,74 @@ func main() {
 			successfulProjects++
 			logutil.Success("--- Finished project %s successfully ---", projectName)
 		}
-		fmt.Println()
+		logArchive := ""
+		if cfg.Output == config.OutputZip && projectReport.Archive != "" {
+			logArchive = backupFile
+		}
+		projectReport.Log = finishProjectLog(ctx, cfg, projectName, projectLog, logArchive, uploaded)
+		if !cfg.Quiet {
+			fmt.Println()
+		}
+	}
+
+	logutil.SetProject("")
+
+	// --- Sync the Backup Directory (rsync sync_backup_dir) ---
+	logutil.SetPhase("sync")
+	// Retention is applied to the local backup directory first; --delete then removes
+	// the expired archives at the destination as well.
+	for _, target := range targets {
+		r, ok := target.Destination.(*destination.Rsync)
+		if !ok || !r.SyncsBackupDir() {
+			continue
+		}
+		local, err := destination.NewLocal("backup_dir", config.LocalDestinationConfig{Path: cfg.BackupDir})
+		if err != nil {
+			logutil.Error("ERROR: %v", err)
+			backupSuccess = false
+			continue
+		}
+		for _, project := range projects {
+			removed, err := destination.ApplyRetention(ctx, local, project.Name, target.Retention, cfg.DryRun)
+			if err != nil {
+				logutil.Warn("[%s] Retention in %s failed: %v", project.Name, cfg.BackupDir, err)
+			}
+			for _, name := range removed {
+				if cfg.DryRun {
+					logutil.Info("[DRY RUN] Would delete %s from %s (retention of '%s').", name, cfg.BackupDir, r.Name())
+				} else {
+					logutil.Info("[%s] Deleted %s from %s (retention of '%s').", project.Name, name, cfg.BackupDir, r.Name())
+				}
+			}
+		}
+		if cfg.DryRun {
+			logutil.Info("[DRY RUN] Would sync %s to '%s'.", cfg.BackupDir, r.Name())
+			continue
+		}
+		logutil.Info("Syncing %s to '%s'...", cfg.BackupDir, r.Name())
+		stats, err := r.SyncDir(ctx, cfg.BackupDir)
+		transfer := report.Transfer{Destination: r.Name(), Files: stats.Files, Bytes: stats.Bytes, Duration: stats.Duration, Attempts: stats.Attempts}
+		if err != nil {
+			logutil.Error("ERROR: Sync of %s to '%s' failed: %v", cfg.BackupDir, r.Name(), err)
+			transfer.Error = err.Error()
+			backupSuccess = false
+		} else {
+			logutil.Success("Synced %s to '%s': %d file(s), %s in %s.", cfg.BackupDir, r.Name(), stats.Files, util.FormatBytes(stats.Bytes), stats.Duration.Round(time.Second))
+		}
+		run.Transfers = append(run.Transfers, transfer)
 	}
 
 	// --- Final Summary ---
+	logutil.SetPhase(logutil.PhaseSummary)
+	run.Finish()
 	logutil.Info("=============================")
 	logutil.Info("Backup process finished. Successful: %d, Failed: %d", successfulProjects, failedProjects)
+	report.Log(run)
 	logutil.Info("=============================")
 	if !backupSuccess {
 		logutil.Warn("One or more projects failed to back up correctly. Check logs above.")
-		os.Exit(1)
 	} else {
 		logutil.Success("All projects processed successfully.")
 	}
+	return run, backupSuccess
 }
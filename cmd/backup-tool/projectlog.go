@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"docker-backup-tool/internal/backup"
+	"docker-backup-tool/internal/config"
+	"docker-backup-tool/internal/destination"
+	"docker-backup-tool/internal/logutil"
+)
+
+// startProjectLog opens the log of a project in the project log directory, named
+// after the run so logs of failed runs do not overwrite each other. It returns the
+// path, or "" if project logs are disabled or the log could not be created.
+func startProjectLog(cfg config.Config, project, runID string) string {
+	if !cfg.ProjectLogs.Enabled || cfg.DryRun {
+		return ""
+	}
+	path := filepath.Join(cfg.ProjectLogs.Dir, project+"_"+runID+".log")
+	if err := logutil.StartProjectLog(path); err != nil {
+		logutil.Warn("[%s] Project log disabled for this run: %v", project, err)
+		return ""
+	}
+	return path
+}
+
+// finishProjectLog closes the project log opened by startProjectLog. The log of a
+// created archive is moved next to it and uploaded to the destinations that received
+// the archive, so it expires together with the archive. Other logs stay in the project
+// log directory, which keeps the newest project_logs.keep_last per project.
+// It returns the final location of the log.
+func finishProjectLog(ctx context.Context, cfg config.Config, project, path, archive string, uploaded []destination.Destination) string {
+	if path == "" {
+		return ""
+	}
+	if err := logutil.StopProjectLog(); err != nil {
+		logutil.Warn("[%s] Failed to write project log %s: %v", project, path, err)
+	}
+
+	if archive == "" {
+		if err := pruneProjectLogs(cfg.ProjectLogs.Dir, project, cfg.ProjectLogs.KeepLast); err != nil {
+			logutil.Warn("[%s] Failed to prune project logs in %s: %v", project, cfg.ProjectLogs.Dir, err)
+		}
+		logutil.Info("[%s] Project log: %s", project, path)
+		return path
+	}
+
+	target := backup.LogPath(archive)
+	if err := moveFile(path, target); err != nil {
+		logutil.Warn("[%s] Failed to move project log next to the archive, keeping %s: %v", project, path, err)
+		return path
+	}
+	logutil.Info("[%s] Project log: %s", project, target)
+	for _, dest := range uploaded {
+		if _, err := dest.Upload(ctx, target); err != nil {
+			logutil.Warn("[%s] Failed to upload project log to '%s': %v", project, dest.Name(), err)
+		}
+	}
+	return target
+}
+
+// projectLogPattern matches the part of a log name in the project log directory after "<project>_".
+var projectLogPattern = regexp.MustCompile(`^\d{8}T\d{6}\.\d{9}Z\.log$`)
+
+// pruneProjectLogs deletes all but the newest keep logs of a project. Run IDs sort
+// chronologically, so the names are sorted instead of relying on modification times.
+func pruneProjectLogs(dir, project string, keep int) error {
+	if keep <= 0 {
+		return nil
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	var logs []string
+	for _, e := range entries {
+		rest, ok := strings.CutPrefix(e.Name(), project+"_")
+		if ok && !e.IsDir() && projectLogPattern.MatchString(rest) {
+			logs = append(logs, e.Name())
+		}
+	}
+	sort.Strings(logs)
+	for i := 0; i < len(logs)-keep; i++ {
+		if err := os.Remove(filepath.Join(dir, logs[i])); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// moveFile renames src to dst, copying it if they are on different filesystems.
+func moveFile(src, dst string) error {
+	if err := os.Rename(src, dst); err == nil {
+		return nil
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(dst)
+		return err
+	}
+	return os.Remove(src)
+}
@@ -3,15 +3,22 @@ module docker-backup-tool
 go 1.23.2
 
 require (
+	filippo.io/age v1.2.1
 	github.com/fatih/color v1.17.0
 	github.com/google/shlex v0.0.0-20191202100458-e7afc7fbc510
 	github.com/joho/godotenv v1.5.1
+	github.com/klauspost/compress v1.18.0
 	github.com/mattn/go-isatty v0.0.20
+	github.com/pkg/sftp v1.13.7
+	github.com/robfig/cron/v3 v3.0.1
+	go.etcd.io/bbolt v1.3.11
+	golang.org/x/crypto v0.24.0
 	gopkg.in/natefinch/lumberjack.v2 v2.2.1
 	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
+	github.com/kr/fs v0.1.0 // indirect
 	github.com/kr/pretty v0.3.1 // indirect
 	github.com/mattn/go-colorable v0.1.13 // indirect
 	golang.org/x/sys v0.29.0 // indirect
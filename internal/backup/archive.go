@@ -0,0 +1,182 @@
+package backup
+
+import (
+	"archive/zip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+
+	"docker-backup-tool/internal/config"
+	"docker-backup-tool/internal/encryption"
+	"docker-backup-tool/internal/logutil"
+)
+
+// archiveFile is the byte stream a zip archive is written to: a single file or a
+// set of split parts on disk, optionally wrapped in encryption. Close flushes and
+// closes every layer in order.
+type archiveFile struct {
+	w       io.Writer
+	closers []io.Closer // Innermost (closest to the zip writer) first
+	remove  func()      // Deletes everything written so far
+	hash    hash.Hash   // SHA-256 of the bytes stored on disk (all parts, after encryption)
+}
+
+// Checksum returns the hex SHA-256 of everything written to disk so far.
+func (a *archiveFile) Checksum() string {
+	return hex.EncodeToString(a.hash.Sum(nil))
+}
+
+func (a *archiveFile) Write(p []byte) (int, error) {
+	return a.w.Write(p)
+}
+
+func (a *archiveFile) Close() error {
+	var firstErr error
+	for _, c := range a.closers {
+		if err := c.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// Remove closes the stream and deletes the files it produced. It is used when writing fails.
+func (a *archiveFile) Remove() {
+	a.Close()
+	a.remove()
+}
+
+// createArchiveFile creates the archive at path and returns the stream the zip archive is written to.
+// With split_size set, the data goes to path.001, path.002, ... and a path.sha256 checksum list.
+func createArchiveFile(path string, cfg config.Config) (*archiveFile, error) {
+	// Remove leftovers of an earlier archive with the same name, e.g. surplus parts of a larger split set
+	for _, old := range ArchiveFiles(path) {
+		os.Remove(old)
+	}
+
+	a := &archiveFile{hash: sha256.New()}
+	if cfg.SplitSize > 0 {
+		sw := newSplitWriter(path, cfg.SplitSize)
+		a.w, a.closers, a.remove = sw, []io.Closer{sw}, sw.remove
+	} else {
+		f, err := os.Create(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create archive file '%s': %w", path, err)
+		}
+		a.w, a.closers, a.remove = f, []io.Closer{f}, func() { os.Remove(path) }
+	}
+	a.w = io.MultiWriter(a.w, a.hash)
+
+	if cfg.Encryption.Enabled {
+		enc, err := encryption.Encrypt(a.w, cfg.Encryption)
+		if err != nil {
+			a.Remove()
+			return nil, err
+		}
+		a.w = enc
+		a.closers = append([]io.Closer{enc}, a.closers...)
+	}
+	return a, nil
+}
+
+// archiveName returns the file name for an archive, adding the extensions of
+// the configured stream layers. Split parts add their number on top of this name.
+func archiveName(base string, cfg config.Config) string {
+	if cfg.Encryption.Enabled {
+		base += encryption.Extension
+	}
+	return base
+}
+
+// openedArchive is a readable archive. Close releases open parts and temporary files.
+type openedArchive struct {
+	*zip.Reader
+	close func() error
+}
+
+func (o *openedArchive) Close() error {
+	return o.close()
+}
+
+// openArchive opens an archive or a split set for reading. Split parts are checked
+// against their checksum list. Encrypted archives are decrypted first, since zip needs
+// random access, into <archive>.decrypt-*.partial next to the archive, or into the
+// temp directory if the archive's directory is not writable.
+func openArchive(path string, cfg config.Config) (*openedArchive, error) {
+	path = partPattern.ReplaceAllString(path, "")
+
+	var src io.ReaderAt
+	var size int64
+	var closeSrc func() error
+	if fileExists(path) {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open archive '%s': %w", path, err)
+		}
+		info, err := f.Stat()
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		src, size, closeSrc = f, info.Size(), f.Close
+	} else {
+		m, err := openSplitArchive(path)
+		if err != nil {
+			return nil, err
+		}
+		src, size, closeSrc = m, m.size, m.Close
+	}
+
+	encrypted, err := encryption.IsEncrypted(src)
+	if err != nil {
+		closeSrc()
+		return nil, fmt.Errorf("failed to read archive '%s': %w", path, err)
+	}
+	if !encrypted {
+		r, err := zip.NewReader(src, size)
+		if err != nil {
+			closeSrc()
+			return nil, fmt.Errorf("failed to open archive '%s': %w", path, err)
+		}
+		registerDecompressors(r)
+		return &openedArchive{Reader: r, close: closeSrc}, nil
+	}
+	defer closeSrc()
+
+	plain, err := encryption.Decrypt(io.NewSectionReader(src, 0, size), cfg.Encryption)
+	if err != nil {
+		return nil, fmt.Errorf("cannot decrypt archive '%s': %w", path, err)
+	}
+
+	// The plaintext goes next to the archive, where the archive itself needs room,
+	// rather than into the shared temp directory; the .partial suffix keeps a file left
+	// by a killed process out of sync_backup_dir uploads.
+	pattern := filepath.Base(path) + ".decrypt-*.partial"
+	tmp, err := os.CreateTemp(filepath.Dir(path), pattern)
+	if err != nil {
+		logutil.Warn("Cannot write the decrypted archive next to '%s' (%v), using %s instead.", path, err, os.TempDir())
+		if tmp, err = os.CreateTemp("", pattern); err != nil {
+			return nil, fmt.Errorf("failed to create file for the decrypted archive: %w", err)
+		}
+	}
+	cleanup := func() error {
+		tmp.Close()
+		return os.Remove(tmp.Name())
+	}
+	plainSize, err := io.Copy(tmp, plain)
+	if err != nil {
+		cleanup()
+		return nil, fmt.Errorf("failed to decrypt archive '%s' (corrupted or tampered?): %w", path, err)
+	}
+	r, err := zip.NewReader(tmp, plainSize)
+	if err != nil {
+		cleanup()
+		return nil, fmt.Errorf("failed to read decrypted archive '%s': %w", path, err)
+	}
+	registerDecompressors(r)
+	return &openedArchive{Reader: r, close: cleanup}, nil
+}
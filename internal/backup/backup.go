@@ -2,12 +2,10 @@ package backup
 
 import (
 	"archive/zip"
-	"bytes"
 	"fmt"
 	"io"
 	"io/fs"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"strings"
 	"time"
@@ -15,7 +13,9 @@ import (
 	// Import the util package
 
 	"docker-backup-tool/internal/config"
+	"docker-backup-tool/internal/docker"
 	"docker-backup-tool/internal/logutil"
+	"docker-backup-tool/internal/repository"
 	"docker-backup-tool/internal/util"
 
 	"gopkg.in/yaml.v3"
@@ -35,41 +35,21 @@ type Service struct {
 
 // ParseVolumes runs `docker compose config` and extracts unique, existing host paths
 // from volume mounts that are prefixed with the specified appdataDir.
-func ParseVolumes(composeFilePath string, appdataDir string, dockerComposeCmd string) ([]string, error) {
-	composeFileDir := filepath.Dir(composeFilePath)
-
+func ParseVolumes(project docker.Project, appdataDir string, dockerComposeCmd string) ([]string, error) {
 	// --- Use docker compose config ---
-	// Determine base command (docker or docker-compose)
-	var baseCmd string
-	var composeArgs []string
-	if dockerComposeCmd == "docker-compose" {
-		baseCmd = dockerComposeCmd
-		composeArgs = []string{"config"}
-	} else {
-		baseCmd = dockerComposeCmd // Should be "docker"
-		composeArgs = []string{"compose", "config"}
-	}
-	cmd := exec.Command(baseCmd, composeArgs...)
-	cmd.Dir = composeFileDir // Set working directory
-
-	var stdout, stderr bytes.Buffer
-	cmd.Stdout = &stdout
-	cmd.Stderr = &stderr
-
-	logutil.Debug("Running compose config cmd in %s: %s", composeFileDir, strings.Join(cmd.Args, " "))
-	err := cmd.Run()
+	output, err := docker.Config(project, dockerComposeCmd)
 	if err != nil {
 		// Return specific error if docker compose config fails
-		return nil, fmt.Errorf("'docker compose config' failed in %s: %w\nStderr: %s", composeFileDir, err, stderr.String())
+		return nil, fmt.Errorf("'docker compose config' failed: %w", err)
 	}
 
 	// --- Unmarshal the resolved config ---
-	data := stdout.Bytes()
+	data := []byte(output)
 	var config ComposeConfig
 	err = yaml.Unmarshal(data, &config)
 	if err != nil {
 		// If unmarshal fails on resolved config, it's a more serious issue
-		return nil, fmt.Errorf("failed to unmarshal resolved YAML from 'docker compose config' output for %s: %w", composeFilePath, err)
+		return nil, fmt.Errorf("failed to unmarshal resolved YAML from 'docker compose config' output for %s: %w", project.Dir, err)
 	}
 
 	appdataPaths := make(map[string]struct{}) // Use map for uniqueness
@@ -154,16 +134,26 @@ func ParseVolumes(composeFilePath string, appdataDir string, dockerComposeCmd st
 
 // --- Backup Creation ---
 
+// Result describes a created backup.
+type Result struct {
+	Path  string // Archive, or snapshot file with repository output
+	Files int    // Regular files in the archive (only changed files for incrementals)
+	Bytes int64  // Uncompressed size of those files
+
+	Checksum string // Hex SHA-256 of the archive as stored (all parts in order), empty for repository output
+}
+
 // CreateBackup orchestrates the creation of a backup zip file for a project.
 // It now accepts the full config struct.
-func CreateBackup(projectName, projectPath, backupDir string, appdataPaths []string, cfg config.Config) (string, error) {
+func CreateBackup(projectName, projectPath, backupDir string, appdataPaths []string, cfg config.Config) (Result, error) {
+	var result Result
 	// 1. Create Temporary Directory
 	// Use os.MkdirTemp in the *parent* of the backupDir or a system temp location?
 	// Using backupDir might pollute it if cleanup fails, using system temp is safer.
 	// Let's use system temp dir for now.
 	tempBackupRoot, err := os.MkdirTemp("", "docker-backup-"+projectName+"-*")
 	if err != nil {
-		return "", fmt.Errorf("failed to create temporary backup directory: %w", err)
+		return result, fmt.Errorf("failed to create temporary backup directory: %w", err)
 	}
 	// Ensure cleanup happens even on errors
 	defer func() {
@@ -189,18 +179,18 @@ func CreateBackup(projectName, projectPath, backupDir string, appdataPaths []str
 	tempAppdataParent := filepath.Join(tempBackupRoot, "appdata")
 
 	if err := os.MkdirAll(tempComposeTarget, 0755); err != nil {
-		return "", fmt.Errorf("failed to create temp compose structure '%s': %w", tempComposeTarget, err)
+		return result, fmt.Errorf("failed to create temp compose structure '%s': %w", tempComposeTarget, err)
 	}
 	if len(appdataPaths) > 0 {
 		if err := os.MkdirAll(tempAppdataParent, 0755); err != nil {
-			return "", fmt.Errorf("failed to create temp appdata structure '%s': %w", tempAppdataParent, err)
+			return result, fmt.Errorf("failed to create temp appdata structure '%s': %w", tempAppdataParent, err)
 		}
 	}
 
 	// 3. Copy Compose Directory Contents (Respecting Excludes)
 	logutil.Info("Copying compose directory '%s'...", projectPath)
 	if err := copyDirectoryContents(projectPath, tempComposeTarget, cfg); err != nil {
-		return "", fmt.Errorf("failed to copy compose directory contents: %w", err)
+		return result, fmt.Errorf("failed to copy compose directory contents: %w", err)
 	}
 
 	// 4. Copy Appdata Directory Contents (Respecting Excludes)
@@ -213,31 +203,177 @@ func CreateBackup(projectName, projectPath, backupDir string, appdataPaths []str
 			if err := copyPath(srcPath, targetPath, cfg); err != nil {
 				// Log error but potentially continue? Or fail backup?
 				// For now, let's fail the backup if any appdata copy fails.
-				return "", fmt.Errorf("failed to copy appdata path '%s': %w", srcPath, err)
+				return result, fmt.Errorf("failed to copy appdata path '%s': %w", srcPath, err)
 			}
 		}
 	}
 
-	// 5. Create Zip Archive
-	backupFileName := fmt.Sprintf("%s_%s.zip", projectName, time.Now().Format("20060102"))
+	// 5. Store in the deduplicating repository instead of a zip when configured
+	if cfg.Output == config.OutputRepository {
+		result.Files, result.Bytes = countFiles(tempBackupRoot)
+		result.Path, err = storeInRepository(projectName, tempBackupRoot, cfg)
+		return result, err
+	}
+
+	// 6. Create Zip Archive
+	backupFileName := archiveName(fmt.Sprintf("%s_%s.zip", projectName, time.Now().Format("20060102")), cfg)
+	var newIndex *Index
+	if cfg.Incremental.Enabled {
+		backupFileName, newIndex, err = prepareIncremental(projectName, tempBackupRoot, cfg)
+		if err != nil {
+			return result, err
+		}
+	}
 	backupFilePath := filepath.Join(cfg.BackupDir, backupFileName)
+	result.Files, result.Bytes = countFiles(tempBackupRoot)
 
 	// Ensure the target backup directory exists
 	if err := os.MkdirAll(cfg.BackupDir, 0755); err != nil {
-		return "", fmt.Errorf("failed to create backup directory '%s': %w", cfg.BackupDir, err)
+		return result, fmt.Errorf("failed to create backup directory '%s': %w", cfg.BackupDir, err)
 	}
 
-	logutil.Info("Creating zip archive: %s", backupFilePath)
-	if err := zipDirectory(tempBackupRoot, backupFilePath, cfg); err != nil {
-		return "", fmt.Errorf("failed to create zip archive: %w", err)
+	if cfg.SplitSize > 0 {
+		logutil.Info("Creating zip archive: %s (split into %s parts)", backupFilePath, util.FormatBytes(cfg.SplitSize))
+	} else {
+		logutil.Info("Creating zip archive: %s", backupFilePath)
+	}
+	if result.Checksum, err = zipDirectory(tempBackupRoot, backupFilePath, cfg); err != nil {
+		return result, fmt.Errorf("failed to create zip archive: %w", err)
+	}
+
+	// The index is only advanced once the archive exists, so a failed run is retried against the old state
+	if newIndex != nil {
+		if err := saveIndex(cfg.BackupDir, newIndex); err != nil {
+			logutil.Warn("Failed to save backup index for %s, next run will compare against the previous index: %v", projectName, err)
+		}
 	}
 
 	// If we reach here, backup succeeded (but cleanup is deferred)
-	return backupFilePath, nil
+	result.Path = backupFilePath
+	return result, nil
+}
+
+// storeInRepository adds the staged tree as a snapshot to the configured repository
+// and returns the path of the snapshot file.
+func storeInRepository(projectName, stagingDir string, cfg config.Config) (string, error) {
+	repo, err := repository.Init(cfg.Repository.Path)
+	if err != nil {
+		return "", err
+	}
+	defer repo.Close()
+
+	logutil.Info("Storing snapshot in repository: %s", cfg.Repository.Path)
+	snapshotPath, stats, err := repo.Backup(projectName, stagingDir)
+	if err != nil {
+		return "", fmt.Errorf("failed to store snapshot: %w", err)
+	}
+	logutil.Info("[%s] Snapshot stored: %d files, %s total, %d new chunks (%s added to repository).",
+		projectName, stats.Files, util.FormatBytes(stats.TotalBytes), stats.NewChunks, util.FormatBytes(stats.NewBytes))
+	return snapshotPath, nil
+}
+
+// prepareIncremental indexes the staged files and decides whether this run is a full or an
+// incremental backup. For incrementals, unchanged files are removed from the staging directory
+// so only new and changed files are archived. It returns the archive name and the index to
+// save once the archive has been written.
+func prepareIncremental(projectName, stagingDir string, cfg config.Config) (string, *Index, error) {
+	prev, err := LoadIndex(cfg.BackupDir, projectName)
+	if err != nil {
+		logutil.Warn("Ignoring unreadable backup index for %s, taking a full backup: %v", projectName, err)
+		prev = nil
+	}
+
+	files, err := buildIndex(stagingDir, prev)
+	if err != nil {
+		return "", nil, err
+	}
+
+	now := time.Now()
+	stamp := now.Format("20060102-150405")
+	idx := &Index{Project: projectName, Created: now.UTC(), Files: files}
+
+	reason := ""
+	switch {
+	case prev == nil:
+		reason = "no previous index"
+	case cfg.Incremental.FullEvery > 0 && prev.Incrementals >= cfg.Incremental.FullEvery:
+		reason = fmt.Sprintf("%d incrementals since last full backup", prev.Incrementals)
+	case !archiveExists(filepath.Join(cfg.BackupDir, prev.Archive)) || !archiveExists(filepath.Join(cfg.BackupDir, prev.FullArchive)):
+		reason = "previous archive chain is missing"
+	}
+
+	if reason != "" {
+		logutil.Info("[%s] Taking full backup (%s).", projectName, reason)
+		idx.Archive = archiveName(fmt.Sprintf("%s_%s_full.zip", projectName, stamp), cfg)
+		idx.FullArchive = idx.Archive
+		manifest := Manifest{Project: projectName, Type: TypeFull, Created: idx.Created}
+		if err := writeManifest(stagingDir, manifest); err != nil {
+			return "", nil, fmt.Errorf("failed to write backup manifest: %w", err)
+		}
+		return idx.Archive, idx, nil
+	}
+
+	changed, deleted := diffIndex(prev.Files, files)
+	changedSet := make(map[string]struct{}, len(changed))
+	for _, path := range changed {
+		changedSet[path] = struct{}{}
+	}
+	for path := range files {
+		if _, ok := changedSet[path]; !ok {
+			if err := os.Remove(filepath.Join(stagingDir, filepath.FromSlash(path))); err != nil {
+				return "", nil, fmt.Errorf("failed to drop unchanged file '%s' from staging: %w", path, err)
+			}
+		}
+	}
+	logutil.Info("[%s] Taking incremental backup: %d new/changed, %d deleted, %d unchanged.",
+		projectName, len(changed), len(deleted), len(files)-len(changed))
+
+	idx.Archive = archiveName(fmt.Sprintf("%s_%s_incr.zip", projectName, stamp), cfg)
+	idx.FullArchive = prev.FullArchive
+	idx.Incrementals = prev.Incrementals + 1
+	manifest := Manifest{
+		Project: projectName,
+		Type:    TypeIncremental,
+		Created: idx.Created,
+		Parent:  prev.Archive,
+		Full:    prev.FullArchive,
+		Deleted: deleted,
+	}
+	if err := writeManifest(stagingDir, manifest); err != nil {
+		return "", nil, fmt.Errorf("failed to write backup manifest: %w", err)
+	}
+	return idx.Archive, idx, nil
 }
 
 // --- Helper Functions ---
 
+// countFiles returns the number and total size of the regular files below root,
+// leaving out the backup metadata.
+func countFiles(root string) (int, int64) {
+	var files int
+	var bytes int64
+	filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err == nil && d.IsDir() && path == filepath.Join(root, metaDir) {
+			return filepath.SkipDir
+		}
+		if err != nil || !d.Type().IsRegular() {
+			return nil
+		}
+		if info, err := d.Info(); err == nil {
+			files++
+			bytes += info.Size()
+		}
+		return nil
+	})
+	return files, bytes
+}
+
+// fileExists reports whether path exists.
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
 // copyPath copies a file or directory recursively, respecting exclude patterns.
 // It acts as a dispatcher based on whether the source is a file or directory.
 func copyPath(src, dst string, cfg config.Config) error {
@@ -286,10 +422,7 @@ func copyDirectoryContents(srcDir, dstDir string, cfg config.Config) error {
 			return fmt.Errorf("exclude pattern error: %w", patternErr)
 		}
 		if excluded {
-			// Only log if verbose is enabled
-			if cfg.Verbose {
-				logutil.Debug("Excluding (copy): %s (matches pattern)", relPath)
-			}
+			logutil.Debug("Excluding (copy): %s (matches pattern)", relPath)
 			if d.IsDir() {
 				return filepath.SkipDir // Skip the entire directory
 			}
@@ -353,20 +486,29 @@ func copyFile(src, dst string) error {
 		logutil.Warn("Failed to set permissions on '%s': %v", dst, err)
 	}
 
+	// Keep the source modification time so the archive and the incremental index see the original value
+	if err := os.Chtimes(dst, info.ModTime(), info.ModTime()); err != nil {
+		logutil.Warn("Failed to set modification time on '%s': %v", dst, err)
+	}
+
 	return nil
 }
 
-// zipDirectory creates a zip archive of the source directory's contents.
+// zipDirectory creates a zip archive of the source directory's contents and returns
+// the SHA-256 of the archive as stored on disk.
 // Needs to respect exclude patterns too!
-func zipDirectory(sourceDir, targetZipFile string, cfg config.Config) error {
-	zipFile, err := os.Create(targetZipFile)
+func zipDirectory(sourceDir, targetZipFile string, cfg config.Config) (string, error) {
+	zipFile, err := createArchiveFile(targetZipFile, cfg)
 	if err != nil {
-		return fmt.Errorf("failed to create zip file '%s': %w", targetZipFile, err)
+		return "", err
 	}
-	defer zipFile.Close()
 
 	archive := zip.NewWriter(zipFile)
-	defer archive.Close()
+	method, err := compressionMethod(archive, cfg.Compression)
+	if err != nil {
+		zipFile.Remove()
+		return "", err
+	}
 
 	// Walk through the source *temporary* directory
 	err = filepath.WalkDir(sourceDir, func(path string, d fs.DirEntry, err error) error {
@@ -393,10 +535,7 @@ func zipDirectory(sourceDir, targetZipFile string, cfg config.Config) error {
 			return fmt.Errorf("exclude pattern error during zip: %w", patternErr)
 		}
 		if excluded {
-			// Only log if verbose is enabled
-			if cfg.Verbose {
-				logutil.Debug("Excluding (zip): %s (matches pattern)", relPath)
-			}
+			logutil.Debug("Excluding (zip): %s (matches pattern)", relPath)
 			if d.IsDir() {
 				return filepath.SkipDir // Skip excluded directories
 			}
@@ -418,7 +557,15 @@ func zipDirectory(sourceDir, targetZipFile string, cfg config.Config) error {
 
 		header.Name = filepath.ToSlash(relPath)
 
-		header.Method = zip.Deflate
+		switch {
+		case d.IsDir():
+			header.Method = zip.Store
+		case cfg.Compression.SkipCompressed && method != zip.Store && isAlreadyCompressed(path):
+			logutil.Debug("Storing already compressed file without recompression: %s", relPath)
+			header.Method = zip.Store
+		default:
+			header.Method = method
+		}
 
 		writer, err := archive.CreateHeader(header)
 		if err != nil {
@@ -426,9 +573,7 @@ func zipDirectory(sourceDir, targetZipFile string, cfg config.Config) error {
 		}
 
 		if !d.IsDir() {
-			if cfg.Verbose {
-				logutil.Debug("Adding to zip: %s", relPath)
-			}
+			logutil.Debug("Adding to zip: %s", relPath)
 			file, err := os.Open(path)
 			if err != nil {
 				return err
@@ -443,9 +588,21 @@ func zipDirectory(sourceDir, targetZipFile string, cfg config.Config) error {
 	})
 
 	if err != nil {
-		os.Remove(targetZipFile)
-		return fmt.Errorf("failed during zip creation walk: %w", err)
+		archive.Close()
+		zipFile.Remove()
+		return "", fmt.Errorf("failed during zip creation walk: %w", err)
 	}
 
-	return nil
+	// Close explicitly: the central directory, the final encrypted chunk and the
+	// checksum list of split parts are only written here
+	if err := archive.Close(); err != nil {
+		zipFile.Remove()
+		return "", fmt.Errorf("failed to finalize zip archive: %w", err)
+	}
+	if err := zipFile.Close(); err != nil {
+		zipFile.Remove()
+		return "", fmt.Errorf("failed to finalize archive file: %w", err)
+	}
+
+	return zipFile.Checksum(), nil
 }
@@ -0,0 +1,114 @@
+package backup
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"docker-backup-tool/internal/config"
+
+	"github.com/klauspost/compress/flate"
+	"github.com/klauspost/compress/zstd"
+)
+
+// compressedExtensions lists file types that are already compressed and do not shrink further.
+var compressedExtensions = map[string]bool{
+	".7z": true, ".avif": true, ".br": true, ".bz2": true, ".flac": true, ".gif": true,
+	".gz": true, ".heic": true, ".jpeg": true, ".jpg": true, ".lz4": true, ".lzma": true,
+	".m4a": true, ".m4v": true, ".mkv": true, ".mov": true, ".mp3": true, ".mp4": true,
+	".ogg": true, ".opus": true, ".png": true, ".rar": true, ".tbz2": true, ".tgz": true,
+	".txz": true, ".webm": true, ".webp": true, ".xz": true, ".zip": true, ".zst": true,
+	".age": true, ".jar": true, ".apk": true, ".docx": true, ".xlsx": true, ".pptx": true,
+}
+
+// compressedMagic lists signatures of compressed formats found at the start of a file.
+var compressedMagic = [][]byte{
+	{0x1f, 0x8b},                             // gzip
+	{'P', 'K', 0x03, 0x04},                   // zip and zip based formats
+	{0x28, 0xb5, 0x2f, 0xfd},                 // zstd
+	{0xfd, '7', 'z', 'X', 'Z', 0x00},         // xz
+	{'B', 'Z', 'h'},                          // bzip2
+	{'7', 'z', 0xbc, 0xaf, 0x27, 0x1c},       // 7z
+	{'R', 'a', 'r', '!', 0x1a, 0x07},         // rar
+	{0x04, 0x22, 0x4d, 0x18},                 // lz4
+	{0x89, 'P', 'N', 'G'},                    // png
+	{0xff, 0xd8, 0xff},                       // jpeg
+	{'G', 'I', 'F', '8'},                     // gif
+	{0x1a, 0x45, 0xdf, 0xa3},                 // matroska / webm
+	{'O', 'g', 'g', 'S'},                     // ogg
+	{'f', 'L', 'a', 'C'},                     // flac
+	{'I', 'D', '3'},                          // mp3 with ID3 tag
+	{'a', 'g', 'e', '-', 'e', 'n', 'c', 'r'}, // age
+}
+
+// compressionMethod registers the configured compressor on w and returns the zip method to use for entries.
+func compressionMethod(w *zip.Writer, cfg config.CompressionConfig) (uint16, error) {
+	switch cfg.Method {
+	case config.CompressionStore:
+		return zip.Store, nil
+	case config.CompressionDeflate:
+		level := flate.DefaultCompression
+		if cfg.Level != 0 {
+			level = cfg.Level
+		}
+		// Validate the level once instead of failing on the first entry
+		if _, err := flate.NewWriter(io.Discard, level); err != nil {
+			return 0, fmt.Errorf("invalid deflate level %d: %w", cfg.Level, err)
+		}
+		w.RegisterCompressor(zip.Deflate, func(out io.Writer) (io.WriteCloser, error) {
+			return flate.NewWriter(out, level)
+		})
+		return zip.Deflate, nil
+	case config.CompressionZstd:
+		opts := []zstd.EOption{zstd.WithEncoderConcurrency(compressionThreads(cfg))}
+		if cfg.Level != 0 {
+			opts = append(opts, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(cfg.Level)))
+		}
+		w.RegisterCompressor(zstd.ZipMethodWinZip, zstd.ZipCompressor(opts...))
+		return zstd.ZipMethodWinZip, nil
+	default:
+		return 0, fmt.Errorf("unknown compression method '%s'", cfg.Method)
+	}
+}
+
+// compressionThreads returns the number of encoder goroutines used by zstd.
+func compressionThreads(cfg config.CompressionConfig) int {
+	if cfg.Threads > 0 {
+		return cfg.Threads
+	}
+	return runtime.NumCPU()
+}
+
+// registerDecompressors enables reading entries written with zstd.
+func registerDecompressors(r *zip.Reader) {
+	r.RegisterDecompressor(zstd.ZipMethodWinZip, zstd.ZipDecompressor())
+	r.RegisterDecompressor(zstd.ZipMethodPKWare, zstd.ZipDecompressor())
+}
+
+// isAlreadyCompressed reports whether a file is a known compressed format,
+// judged by its extension first and its leading magic bytes second.
+func isAlreadyCompressed(path string) bool {
+	if compressedExtensions[strings.ToLower(filepath.Ext(path))] {
+		return true
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	head := make([]byte, 12)
+	n, _ := io.ReadFull(f, head)
+	head = head[:n]
+	for _, magic := range compressedMagic {
+		if bytes.HasPrefix(head, magic) {
+			return true
+		}
+	}
+	// ISO base media (mp4, mov, heic, avif): 'ftyp' box at offset 4
+	return n >= 8 && bytes.Equal(head[4:8], []byte("ftyp"))
+}
@@ -0,0 +1,177 @@
+package backup
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// metaDir is the directory inside every archive holding backup metadata.
+const metaDir = ".backup-meta"
+
+// manifestName is the archive path of the manifest describing the backup.
+const manifestName = metaDir + "/manifest.json"
+
+// Backup types recorded in the manifest.
+const (
+	TypeFull        = "full"
+	TypeIncremental = "incremental"
+)
+
+// FileEntry describes one file as it was captured by a backup run.
+type FileEntry struct {
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mtime"`
+	Hash    string    `json:"sha256"`
+}
+
+// Index is the per-project record of the files captured by the last backup.
+// Paths are archive-relative and use forward slashes.
+type Index struct {
+	Project      string               `json:"project"`
+	Archive      string               `json:"archive"`      // Archive written by the run that produced this index
+	FullArchive  string               `json:"full_archive"` // Full backup the current chain is based on
+	Incrementals int                  `json:"incrementals"` // Incrementals taken since FullArchive
+	Created      time.Time            `json:"created"`
+	Files        map[string]FileEntry `json:"files"`
+}
+
+// Manifest is stored in every archive written by the incremental mode.
+type Manifest struct {
+	Project string    `json:"project"`
+	Type    string    `json:"type"`
+	Created time.Time `json:"created"`
+	Parent  string    `json:"parent,omitempty"` // Previous archive in the chain (incrementals only)
+	Full    string    `json:"full,omitempty"`   // Full archive at the start of the chain
+	Deleted []string  `json:"deleted,omitempty"`
+}
+
+// indexPath returns the location of a project's index inside the backup directory.
+func indexPath(backupDir, projectName string) string {
+	return filepath.Join(backupDir, ".index", projectName+".json")
+}
+
+// LoadIndex reads the index left by the previous backup of a project.
+// It returns nil without an error when no index exists yet.
+func LoadIndex(backupDir, projectName string) (*Index, error) {
+	data, err := os.ReadFile(indexPath(backupDir, projectName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read backup index: %w", err)
+	}
+	var idx Index
+	if err := json.Unmarshal(data, &idx); err != nil {
+		return nil, fmt.Errorf("failed to parse backup index for %s: %w", projectName, err)
+	}
+	return &idx, nil
+}
+
+// saveIndex writes the index atomically so an interrupted run never leaves a truncated file.
+func saveIndex(backupDir string, idx *Index) error {
+	path := indexPath(backupDir, idx.Project)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create index directory: %w", err)
+	}
+	data, err := json.MarshalIndent(idx, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("failed to write backup index: %w", err)
+	}
+	return os.Rename(tmp, path)
+}
+
+// buildIndex records every regular file below root. Hashes are reused from prev
+// when size and modification time are unchanged, so unchanged files are not re-read.
+func buildIndex(root string, prev *Index) (map[string]FileEntry, error) {
+	files := make(map[string]FileEntry)
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		relPath, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		relPath = filepath.ToSlash(relPath)
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		entry := FileEntry{Size: info.Size(), ModTime: info.ModTime().UTC().Truncate(time.Second)}
+		if prev != nil {
+			if old, ok := prev.Files[relPath]; ok && old.Size == entry.Size && old.ModTime.Equal(entry.ModTime) {
+				entry.Hash = old.Hash
+			}
+		}
+		if entry.Hash == "" {
+			if entry.Hash, err = hashFile(path); err != nil {
+				return err
+			}
+		}
+		files[relPath] = entry
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to index backup contents: %w", err)
+	}
+	return files, nil
+}
+
+// hashFile returns the hex encoded SHA-256 of a file.
+func hashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// diffIndex returns the paths that are new or changed in cur and the paths that disappeared since prev.
+func diffIndex(prev, cur map[string]FileEntry) (changed, deleted []string) {
+	for path, entry := range cur {
+		if old, ok := prev[path]; !ok || old.Hash != entry.Hash {
+			changed = append(changed, path)
+		}
+	}
+	for path := range prev {
+		if _, ok := cur[path]; !ok {
+			deleted = append(deleted, path)
+		}
+	}
+	sort.Strings(changed)
+	sort.Strings(deleted)
+	return changed, deleted
+}
+
+// writeManifest stores the manifest in the staging directory so it ends up in the archive.
+func writeManifest(stagingDir string, m Manifest) error {
+	path := filepath.Join(stagingDir, filepath.FromSlash(manifestName))
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
@@ -0,0 +1,196 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"docker-backup-tool/internal/config"
+	"docker-backup-tool/internal/logutil"
+	"docker-backup-tool/internal/rsync"
+)
+
+// snapshotPattern matches the timestamped snapshot directories written with mirror.link_dest.
+var snapshotPattern = regexp.MustCompile(`^\d{8}-\d{6}$`)
+
+// MirrorStats describes mirroring one project to one destination.
+type MirrorStats struct {
+	Files    int
+	Bytes    int64
+	Duration time.Duration
+	Attempts int
+	Snapshot string // Snapshot directory with link_dest, empty otherwise
+}
+
+// Mirror rsyncs the compose directory and the appdata paths of a project straight to
+// the rsync destination, using the same layout as the archives:
+//
+//	<destination>/<project>/compose/...
+//	<destination>/<project>/appdata/<volume_base_name>/...
+//
+// Appdata paths with the same base name are stored under their path relative to the
+// appdata directory instead, see mirrorNames. With mirror.link_dest every run writes
+// a new <project>/<YYYYMMDD-HHMMSS>/ snapshot instead, hardlinking unchanged files to
+// the previous snapshot, and only the newest mirror.keep_last snapshots are kept.
+// Exclude patterns become rsync --exclude rules.
+func Mirror(ctx context.Context, projectName, projectPath string, appdataPaths []string, rc config.RsyncConfig, cfg config.Config) (MirrorStats, error) {
+	start := time.Now()
+	var stats MirrorStats
+
+	root := projectName
+	previous := ""
+	if cfg.Mirror.LinkDest {
+		stats.Snapshot = start.Format("20060102-150405")
+		root = path.Join(projectName, stats.Snapshot)
+	}
+	if err := rsync.MkdirAll(ctx, rc, rc.Destination, path.Join(root, "appdata")); err != nil {
+		return stats, fmt.Errorf("failed to create remote directories: %w", err)
+	}
+	projectDir := rsync.RemotePath(rc.Destination, projectName)
+	if cfg.Mirror.LinkDest {
+		snapshots, err := remoteSnapshots(ctx, rc, projectDir)
+		if err != nil {
+			return stats, err
+		}
+		for _, s := range snapshots {
+			if s != stats.Snapshot {
+				previous = s
+			}
+		}
+		if previous != "" {
+			logutil.Info("[%s] Hardlinking unchanged files to snapshot %s", projectName, previous)
+		}
+	}
+
+	names, err := mirrorNames(appdataPaths, cfg.AppdataDir)
+	if err != nil {
+		return stats, err
+	}
+
+	excludes := rsync.ExcludeArgs(cfg.Exclude)
+	transfer := func(src, dst, linkDest string) error {
+		extra := append([]string{"--recursive", "--delete"}, excludes...)
+		if linkDest != "" {
+			// Relative paths are resolved against the destination directory
+			extra = append(extra, "--link-dest="+linkDest)
+		}
+		s, err := rsync.TransferWith(ctx, rc, extra, dst, src)
+		stats.Files += s.Files
+		stats.Bytes += s.Bytes
+		stats.Attempts += s.Attempts
+		return err
+	}
+
+	logutil.Info("[%s] Mirroring compose directory '%s'...", projectName, projectPath)
+	linkDest := ""
+	if previous != "" {
+		linkDest = path.Join("..", "..", previous, "compose")
+	}
+	if err := transfer(projectPath+"/", rsync.RemotePath(rc.Destination, path.Join(root, "compose"))+"/", linkDest); err != nil {
+		return stats, fmt.Errorf("failed to mirror compose directory: %w", err)
+	}
+
+	for _, src := range appdataPaths {
+		info, err := os.Stat(src)
+		if err != nil {
+			return stats, fmt.Errorf("failed to access appdata path '%s': %w", src, err)
+		}
+		name := names[src]
+		logutil.Info("[%s] Mirroring appdata '%s'...", projectName, src)
+		var dst string
+		if info.IsDir() {
+			src += "/"
+			dst = rsync.RemotePath(rc.Destination, path.Join(root, "appdata", name)) + "/"
+			if previous != "" {
+				linkDest = path.Join("..", "..", "..", previous, "appdata", name)
+			}
+		} else {
+			dst = rsync.RemotePath(rc.Destination, path.Join(root, "appdata")) + "/"
+			if name != filepath.Base(src) {
+				dst += name
+			}
+			if previous != "" {
+				linkDest = path.Join("..", "..", previous, "appdata")
+			}
+		}
+		if err := transfer(src, dst, linkDest); err != nil {
+			return stats, fmt.Errorf("failed to mirror appdata path '%s': %w", src, err)
+		}
+	}
+
+	if cfg.Mirror.LinkDest && cfg.Mirror.KeepLast > 0 {
+		if err := pruneSnapshots(ctx, rc, projectDir, cfg.Mirror.KeepLast); err != nil {
+			logutil.Warn("[%s] Failed to remove old mirror snapshots: %v", projectName, err)
+		}
+	}
+	stats.Duration = time.Since(start)
+	return stats, nil
+}
+
+// mirrorNames returns the name under appdata/ for each appdata path: its base name,
+// or, when several paths share a base name, the path relative to appdataDir with "/"
+// replaced by "-" (e.g. app1-data and app2-data for app1/data and app2/data), since
+// each directory is synced with --delete and would otherwise replace the other.
+func mirrorNames(paths []string, appdataDir string) (map[string]string, error) {
+	count := make(map[string]int)
+	for _, p := range paths {
+		count[filepath.Base(p)]++
+	}
+	if abs, err := filepath.Abs(appdataDir); err == nil {
+		appdataDir = abs
+	}
+	names := make(map[string]string, len(paths))
+	used := make(map[string]string)
+	for _, p := range paths {
+		name := filepath.Base(p)
+		if count[name] > 1 {
+			rel, err := filepath.Rel(appdataDir, p)
+			if err != nil || strings.HasPrefix(rel, "..") {
+				rel = strings.TrimPrefix(p, string(filepath.Separator))
+			}
+			name = strings.ReplaceAll(filepath.ToSlash(rel), "/", "-")
+		}
+		if other, ok := used[name]; ok {
+			return nil, fmt.Errorf("appdata paths '%s' and '%s' would both be mirrored to appdata/%s", other, p, name)
+		}
+		used[name] = p
+		names[p] = name
+	}
+	return names, nil
+}
+
+// remoteSnapshots lists the snapshot directories of a project, oldest first.
+func remoteSnapshots(ctx context.Context, rc config.RsyncConfig, projectDir string) ([]string, error) {
+	entries, err := rsync.List(ctx, rc, projectDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list mirror snapshots: %w", err)
+	}
+	var snapshots []string
+	for _, e := range entries {
+		if e.Dir && snapshotPattern.MatchString(e.Name) {
+			snapshots = append(snapshots, e.Name)
+		}
+	}
+	sort.Strings(snapshots)
+	return snapshots, nil
+}
+
+// pruneSnapshots deletes all but the newest keep snapshots of a project.
+func pruneSnapshots(ctx context.Context, rc config.RsyncConfig, projectDir string, keep int) error {
+	snapshots, err := remoteSnapshots(ctx, rc, projectDir)
+	if err != nil || len(snapshots) <= keep {
+		return err
+	}
+	var expired []string
+	for _, s := range snapshots[:len(snapshots)-keep] {
+		expired = append(expired, s+"/")
+	}
+	logutil.Info("Removing %d old mirror snapshot(s) from %s", len(expired), projectDir)
+	return rsync.Delete(ctx, rc, projectDir, expired...)
+}
@@ -0,0 +1,239 @@
+package backup
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"docker-backup-tool/internal/config"
+	"docker-backup-tool/internal/logutil"
+)
+
+// maxChainLength guards against manifests that reference each other in a loop.
+const maxChainLength = 1000
+
+// ReadManifest returns the manifest stored in an archive, or nil for archives
+// written without one (regular full backups).
+func ReadManifest(archivePath string, cfg config.Config) (*Manifest, error) {
+	r, err := openArchive(archivePath, cfg)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return readManifest(r.Reader)
+}
+
+func readManifest(r *zip.Reader) (*Manifest, error) {
+	for _, f := range r.File {
+		if f.Name != manifestName {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		defer rc.Close()
+		var m Manifest
+		if err := json.NewDecoder(rc).Decode(&m); err != nil {
+			return nil, fmt.Errorf("failed to parse backup manifest: %w", err)
+		}
+		return &m, nil
+	}
+	return nil, nil
+}
+
+// ResolveChain returns the archives needed to restore archivePath, starting with
+// the full backup and ending with archivePath itself. Parents are looked up in
+// the same directory as archivePath.
+func ResolveChain(archivePath string, cfg config.Config) ([]string, error) {
+	chain := []string{archivePath}
+	current := archivePath
+	for {
+		m, err := ReadManifest(current, cfg)
+		if err != nil {
+			return nil, err
+		}
+		if m == nil || m.Type != TypeIncremental {
+			break
+		}
+		if m.Parent == "" {
+			return nil, fmt.Errorf("incremental archive '%s' does not name its parent", current)
+		}
+		current = filepath.Join(filepath.Dir(archivePath), m.Parent)
+		if !archiveExists(current) {
+			return nil, fmt.Errorf("archive '%s' needed to restore '%s' is missing", m.Parent, filepath.Base(archivePath))
+		}
+		chain = append([]string{current}, chain...)
+		if len(chain) > maxChainLength {
+			return nil, fmt.Errorf("archive chain for '%s' is longer than %d entries", archivePath, maxChainLength)
+		}
+	}
+	return chain, nil
+}
+
+// Restore extracts archivePath into targetDir. Incremental archives are restored by
+// extracting their full backup first and then applying each incremental in order,
+// removing the files recorded as deleted.
+func Restore(archivePath, targetDir string, cfg config.Config) error {
+	chain, err := ResolveChain(archivePath, cfg)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(targetDir, 0755); err != nil {
+		return fmt.Errorf("failed to create restore directory '%s': %w", targetDir, err)
+	}
+	for _, archive := range chain {
+		logutil.Info("Restoring %s...", filepath.Base(archive))
+		if err := extractArchive(archive, targetDir, cfg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// extractArchive applies a single archive on top of targetDir.
+func extractArchive(archivePath, targetDir string, cfg config.Config) error {
+	r, err := openArchive(archivePath, cfg)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	m, err := readManifest(r.Reader)
+	if err != nil {
+		return err
+	}
+	if m != nil {
+		for _, deleted := range m.Deleted {
+			dst, err := safeJoin(targetDir, deleted)
+			if err != nil {
+				return err
+			}
+			if err := os.Remove(dst); err != nil && !os.IsNotExist(err) {
+				return fmt.Errorf("failed to remove deleted file '%s': %w", deleted, err)
+			}
+			logutil.Debug("Removed %s (deleted in %s)", deleted, filepath.Base(archivePath))
+			removeEmptyParents(filepath.Dir(dst), targetDir)
+		}
+	}
+
+	for _, f := range r.File {
+		if strings.TrimSuffix(f.Name, "/") == metaDir || strings.HasPrefix(f.Name, metaDir+"/") {
+			continue
+		}
+		if err := extractFile(f, targetDir); err != nil {
+			return fmt.Errorf("failed to extract '%s' from '%s': %w", f.Name, archivePath, err)
+		}
+	}
+	return nil
+}
+
+// removeEmptyParents removes dir and its parents below targetDir as long as they are
+// empty, after the files in them were deleted. Directories that still exist in the
+// backup are created again from their entries in the archive.
+func removeEmptyParents(dir, targetDir string) {
+	targetDir = filepath.Clean(targetDir)
+	for dir != targetDir && strings.HasPrefix(dir, targetDir+string(filepath.Separator)) {
+		if err := os.Remove(dir); err != nil {
+			return
+		}
+		logutil.Debug("Removed empty directory %s", dir)
+		dir = filepath.Dir(dir)
+	}
+}
+
+// extractFile writes a single zip entry below targetDir.
+func extractFile(f *zip.File, targetDir string) error {
+	dst, err := safeJoin(targetDir, f.Name)
+	if err != nil {
+		return err
+	}
+	if f.FileInfo().IsDir() {
+		return os.MkdirAll(dst, 0755)
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+
+	rc, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, f.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, rc); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(dst, f.Mode().Perm()); err != nil {
+		logutil.Warn("Failed to set permissions on '%s': %v", dst, err)
+	}
+	return os.Chtimes(dst, f.Modified, f.Modified)
+}
+
+// safeJoin joins an archive path onto targetDir, rejecting paths that escape it.
+func safeJoin(targetDir, name string) (string, error) {
+	dst := filepath.Join(targetDir, filepath.FromSlash(name))
+	rel, err := filepath.Rel(targetDir, dst)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("archive entry '%s' points outside the restore directory", name)
+	}
+	return dst, nil
+}
+
+// VerifyResult summarizes a verified archive.
+type VerifyResult struct {
+	Files    int
+	Bytes    uint64
+	Manifest *Manifest
+}
+
+// Verify reads every entry of an archive, checking its CRC, and for incrementals
+// makes sure the rest of the chain is present and readable too.
+func Verify(archivePath string, cfg config.Config) (VerifyResult, error) {
+	var res VerifyResult
+	r, err := openArchive(archivePath, cfg)
+	if err != nil {
+		return res, err
+	}
+	defer r.Close()
+
+	if res.Manifest, err = readManifest(r.Reader); err != nil {
+		return res, err
+	}
+	for _, f := range r.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return res, fmt.Errorf("entry '%s': %w", f.Name, err)
+		}
+		// The zip reader checks the CRC-32 once the entry has been read completely
+		n, err := io.Copy(io.Discard, rc)
+		rc.Close()
+		if err != nil {
+			return res, fmt.Errorf("entry '%s' is corrupt: %w", f.Name, err)
+		}
+		res.Files++
+		res.Bytes += uint64(n)
+	}
+
+	if res.Manifest != nil && res.Manifest.Type == TypeIncremental {
+		if _, err := ResolveChain(archivePath, cfg); err != nil {
+			return res, err
+		}
+	}
+	return res, nil
+}
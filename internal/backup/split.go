@@ -0,0 +1,272 @@
+package backup
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// checksumExtension is appended to the archive name for the checksum list of a split set.
+const checksumExtension = ".sha256"
+
+// partPattern matches the numeric suffix of a split part, e.g. ".007".
+var partPattern = regexp.MustCompile(`\.\d{3,}$`)
+
+// partName returns the file name of part n (starting at 1) of a split archive.
+func partName(path string, n int) string {
+	return fmt.Sprintf("%s.%03d", path, n)
+}
+
+// splitWriter writes a stream into numbered parts of at most size bytes and
+// records a SHA-256 checksum per part.
+type splitWriter struct {
+	path    string
+	size    int64
+	n       int
+	current *os.File
+	written int64
+	hash    hash.Hash
+	sums    []string // "<hash>  <file name>" lines in part order
+	parts   []string
+}
+
+func newSplitWriter(path string, size int64) *splitWriter {
+	return &splitWriter{path: path, size: size}
+}
+
+func (s *splitWriter) Write(p []byte) (int, error) {
+	total := 0
+	for len(p) > 0 {
+		if s.current == nil || s.written == s.size {
+			if err := s.nextPart(); err != nil {
+				return total, err
+			}
+		}
+		chunk := p
+		if remaining := s.size - s.written; int64(len(chunk)) > remaining {
+			chunk = chunk[:remaining]
+		}
+		n, err := s.current.Write(chunk)
+		s.hash.Write(chunk[:n])
+		s.written += int64(n)
+		total += n
+		if err != nil {
+			return total, err
+		}
+		p = p[n:]
+	}
+	return total, nil
+}
+
+func (s *splitWriter) nextPart() error {
+	if err := s.finishPart(); err != nil {
+		return err
+	}
+	s.n++
+	name := partName(s.path, s.n)
+	f, err := os.Create(name)
+	if err != nil {
+		return fmt.Errorf("failed to create archive part '%s': %w", name, err)
+	}
+	s.current = f
+	s.written = 0
+	s.hash = sha256.New()
+	s.parts = append(s.parts, name)
+	return nil
+}
+
+func (s *splitWriter) finishPart() error {
+	if s.current == nil {
+		return nil
+	}
+	err := s.current.Close()
+	s.sums = append(s.sums, fmt.Sprintf("%s  %s", hex.EncodeToString(s.hash.Sum(nil)), filepath.Base(s.current.Name())))
+	s.current = nil
+	return err
+}
+
+// Close finishes the last part and writes the checksum list in sha256sum format.
+func (s *splitWriter) Close() error {
+	if s.current == nil && s.n == 0 {
+		// Empty stream: still produce one (empty) part so the set is complete
+		if err := s.nextPart(); err != nil {
+			return err
+		}
+	}
+	if err := s.finishPart(); err != nil {
+		return err
+	}
+	data := strings.Join(s.sums, "\n") + "\n"
+	return os.WriteFile(s.path+checksumExtension, []byte(data), 0644)
+}
+
+// remove deletes every part written so far and the checksum list.
+func (s *splitWriter) remove() {
+	if s.current != nil {
+		s.current.Close()
+	}
+	for _, p := range s.parts {
+		os.Remove(p)
+	}
+	os.Remove(s.path + checksumExtension)
+}
+
+// LogPath returns the location of the project log stored next to an archive: the
+// archive name up to ".zip" plus ".log", so retention treats it as part of the archive.
+func LogPath(archivePath string) string {
+	path := partPattern.ReplaceAllString(archivePath, "")
+	if i := strings.LastIndex(path, ".zip"); i >= 0 {
+		path = path[:i+len(".zip")]
+	}
+	return path + ".log"
+}
+
+// ArchiveFiles returns the files on disk that make up an archive: the archive
+// itself, or the parts and checksum list of a split set.
+func ArchiveFiles(path string) []string {
+	path = partPattern.ReplaceAllString(path, "")
+	if fileExists(path) {
+		return []string{path}
+	}
+	parts := findParts(path)
+	if len(parts) == 0 {
+		return nil
+	}
+	if fileExists(path + checksumExtension) {
+		parts = append(parts, path+checksumExtension)
+	}
+	return parts
+}
+
+// archiveExists reports whether an archive or its split set is present.
+func archiveExists(path string) bool {
+	return len(ArchiveFiles(path)) > 0
+}
+
+// findParts returns the consecutive parts of a split archive in order.
+func findParts(path string) []string {
+	var parts []string
+	for n := 1; ; n++ {
+		name := partName(path, n)
+		if !fileExists(name) {
+			return parts
+		}
+		parts = append(parts, name)
+	}
+}
+
+// readChecksums parses a sha256sum style checksum list into file name -> hash.
+func readChecksums(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	sums := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 {
+			sums[strings.TrimPrefix(fields[1], "*")] = fields[0]
+		}
+	}
+	return sums, scanner.Err()
+}
+
+// multiPartReader presents the parts of a split archive as one io.ReaderAt.
+type multiPartReader struct {
+	files   []*os.File
+	offsets []int64 // Start offset of each part
+	size    int64
+}
+
+// openSplitArchive opens every part of a split set, verifying each part
+// against the checksum list when it is present.
+func openSplitArchive(path string) (*multiPartReader, error) {
+	parts := findParts(path)
+	if len(parts) == 0 {
+		return nil, fmt.Errorf("archive '%s' not found (neither the file nor split parts exist)", path)
+	}
+
+	sums, err := readChecksums(path + checksumExtension)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read checksums of '%s': %w", path, err)
+	}
+	if len(sums) > 0 && len(sums) != len(parts) {
+		return nil, fmt.Errorf("split archive '%s' is incomplete: %d of %d parts present", path, len(parts), len(sums))
+	}
+
+	m := &multiPartReader{}
+	for _, part := range parts {
+		if want, ok := sums[filepath.Base(part)]; ok {
+			got, err := hashFile(part)
+			if err != nil {
+				m.Close()
+				return nil, err
+			}
+			if got != want {
+				m.Close()
+				return nil, fmt.Errorf("checksum mismatch for part '%s'", filepath.Base(part))
+			}
+		} else if len(sums) > 0 {
+			m.Close()
+			return nil, fmt.Errorf("part '%s' is not listed in the checksum file", filepath.Base(part))
+		}
+		f, err := os.Open(part)
+		if err != nil {
+			m.Close()
+			return nil, err
+		}
+		info, err := f.Stat()
+		if err != nil {
+			f.Close()
+			m.Close()
+			return nil, err
+		}
+		m.files = append(m.files, f)
+		m.offsets = append(m.offsets, m.size)
+		m.size += info.Size()
+	}
+	return m, nil
+}
+
+func (m *multiPartReader) ReadAt(p []byte, off int64) (int, error) {
+	if off >= m.size {
+		return 0, io.EOF
+	}
+	// Find the last part starting at or before off
+	i := sort.Search(len(m.offsets), func(i int) bool { return m.offsets[i] > off }) - 1
+	total := 0
+	for len(p) > 0 && i < len(m.files) {
+		n, err := m.files[i].ReadAt(p, off-m.offsets[i])
+		total += n
+		off += int64(n)
+		p = p[n:]
+		if err == io.EOF {
+			i++
+			continue
+		}
+		if err != nil {
+			return total, err
+		}
+	}
+	if len(p) > 0 {
+		return total, io.EOF
+	}
+	return total, nil
+}
+
+func (m *multiPartReader) Close() error {
+	for _, f := range m.files {
+		f.Close()
+	}
+	return nil
+}
@@ -0,0 +1,260 @@
+package catalog
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"docker-backup-tool/internal/report"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Bucket names. Keys sort chronologically: runs are keyed by run ID, backups by
+// run ID followed by the project name.
+var (
+	runsBucket    = []byte("runs")
+	backupsBucket = []byte("backups")
+)
+
+// openTimeout limits how long Open waits for another process holding the database,
+// e.g. a running backup while 'history' is called.
+const openTimeout = 5 * time.Second
+
+// Run is the record of one backup run.
+type Run struct {
+	ID        string            `json:"id"`
+	Start     time.Time         `json:"start"`
+	End       time.Time         `json:"end"`
+	Success   bool              `json:"success"`
+	Projects  int               `json:"projects"`
+	Failed    int               `json:"failed"`
+	Transfers []report.Transfer `json:"transfers,omitempty"` // Run-level transfers, e.g. sync_backup_dir
+}
+
+// Backup is the record of one project in one run, with the archive it produced.
+type Backup struct {
+	RunID        string            `json:"run_id"`
+	Project      string            `json:"project"`
+	Time         time.Time         `json:"time"`
+	Success      bool              `json:"success"`
+	Error        string            `json:"error,omitempty"`
+	Duration     time.Duration     `json:"duration"`
+	Downtime     time.Duration     `json:"downtime,omitempty"`
+	Archive      string            `json:"archive,omitempty"`
+	Path         string            `json:"path,omitempty"`
+	Size         int64             `json:"size,omitempty"`
+	Files        int               `json:"files,omitempty"`
+	Checksum     string            `json:"checksum,omitempty"` // SHA-256 of the archive as stored
+	Log          string            `json:"log,omitempty"`      // Project log of the run
+	Destinations []report.Transfer `json:"destinations,omitempty"`
+}
+
+// Filter selects backups. Zero values match everything.
+type Filter struct {
+	Project string
+	Since   time.Time
+	Until   time.Time
+	Failed  bool // Only failed backups
+	Success bool // Only successful backups
+	Limit   int  // Newest N matches
+}
+
+func (f Filter) match(b *Backup) bool {
+	switch {
+	case f.Project != "" && b.Project != f.Project:
+		return false
+	case !f.Since.IsZero() && b.Time.Before(f.Since):
+		return false
+	case !f.Until.IsZero() && !b.Time.Before(f.Until):
+		return false
+	case f.Failed && b.Success:
+		return false
+	case f.Success && !b.Success:
+		return false
+	}
+	return true
+}
+
+// Catalog is the local history of runs and archives, stored in a bbolt database.
+type Catalog struct {
+	db *bolt.DB
+}
+
+// Open opens or creates the catalog database for recording runs.
+func Open(path string) (*Catalog, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	db, err := bolt.Open(path, 0644, &bolt.Options{Timeout: openTimeout})
+	if errors.Is(err, bolt.ErrTimeout) {
+		return nil, fmt.Errorf("catalog '%s' is locked by another process", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open catalog '%s': %w", path, err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{runsBucket, backupsBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to initialize catalog '%s': %w", path, err)
+	}
+	return &Catalog{db: db}, nil
+}
+
+// OpenReadOnly opens the existing catalog database for queries. It takes a shared
+// lock, so several queries can run at once, and fails if the file does not exist
+// instead of creating an empty catalog.
+func OpenReadOnly(path string) (*Catalog, error) {
+	if _, err := os.Stat(path); err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("no catalog at '%s'; it is created by the first backup run", path)
+		}
+		return nil, fmt.Errorf("failed to open catalog '%s': %w", path, err)
+	}
+	db, err := bolt.Open(path, 0644, &bolt.Options{Timeout: openTimeout, ReadOnly: true})
+	if errors.Is(err, bolt.ErrTimeout) {
+		return nil, fmt.Errorf("catalog '%s' is locked by another process", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open catalog '%s': %w", path, err)
+	}
+	return &Catalog{db: db}, nil
+}
+
+// Close closes the database.
+func (c *Catalog) Close() error {
+	return c.db.Close()
+}
+
+// Add records a finished run and every project in it.
+func (c *Catalog) Add(r *report.Report) error {
+	id := r.ID
+	run := Run{ID: id, Start: r.Start, End: r.End, Success: r.Success(), Projects: len(r.Projects), Failed: r.Failed(), Transfers: r.Transfers}
+	return c.db.Update(func(tx *bolt.Tx) error {
+		if err := put(tx.Bucket(runsBucket), id, run); err != nil {
+			return err
+		}
+		for _, p := range r.Projects {
+			b := Backup{
+				RunID:        id,
+				Project:      p.Name,
+				Time:         p.Start,
+				Success:      p.Success,
+				Error:        p.Error,
+				Duration:     p.Duration,
+				Downtime:     p.Downtime,
+				Archive:      p.Archive,
+				Path:         p.Path,
+				Size:         p.ArchiveSize,
+				Files:        p.Files,
+				Checksum:     p.Checksum,
+				Log:          p.Log,
+				Destinations: p.Transfers,
+			}
+			if err := put(tx.Bucket(backupsBucket), id+"/"+p.Name, b); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func put(bucket *bolt.Bucket, key string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return bucket.Put([]byte(key), data)
+}
+
+// Backups returns the matching backups, newest first.
+func (c *Catalog) Backups(f Filter) ([]Backup, error) {
+	var backups []Backup
+	err := c.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(backupsBucket)
+		if bucket == nil {
+			return nil
+		}
+		cur := bucket.Cursor()
+		for k, v := cur.Last(); k != nil; k, v = cur.Prev() {
+			var b Backup
+			if err := json.Unmarshal(v, &b); err != nil {
+				return fmt.Errorf("corrupt catalog entry '%s': %w", k, err)
+			}
+			if !f.match(&b) {
+				continue
+			}
+			backups = append(backups, b)
+			if f.Limit > 0 && len(backups) == f.Limit {
+				break
+			}
+		}
+		return nil
+	})
+	return backups, err
+}
+
+// Latest returns the newest successful backup of every project, or of one project.
+func (c *Catalog) Latest(project string) ([]Backup, error) {
+	all, err := c.Backups(Filter{Project: project, Success: true})
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool)
+	var latest []Backup
+	for _, b := range all {
+		if !seen[b.Project] {
+			seen[b.Project] = true
+			latest = append(latest, b)
+		}
+	}
+	return latest, nil
+}
+
+// Runs returns the matching runs, newest first. Since and Until apply to the start of
+// a run, Failed and Success to its overall status, and Project selects the runs that
+// included the project.
+func (c *Catalog) Runs(f Filter) ([]Run, error) {
+	var runs []Run
+	err := c.db.View(func(tx *bolt.Tx) error {
+		bucket, backups := tx.Bucket(runsBucket), tx.Bucket(backupsBucket)
+		if bucket == nil {
+			return nil
+		}
+		cur := bucket.Cursor()
+		for k, v := cur.Last(); k != nil; k, v = cur.Prev() {
+			var r Run
+			if err := json.Unmarshal(v, &r); err != nil {
+				return fmt.Errorf("corrupt catalog entry '%s': %w", k, err)
+			}
+			switch {
+			case f.Project != "" && (backups == nil || backups.Get([]byte(r.ID+"/"+f.Project)) == nil):
+				continue
+			case !f.Since.IsZero() && r.Start.Before(f.Since):
+				continue
+			case !f.Until.IsZero() && !r.Start.Before(f.Until):
+				continue
+			case f.Failed && r.Success:
+				continue
+			case f.Success && !r.Success:
+				continue
+			}
+			runs = append(runs, r)
+			if f.Limit > 0 && len(runs) == f.Limit {
+				break
+			}
+		}
+		return nil
+	})
+	return runs, err
+}
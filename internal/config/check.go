@@ -0,0 +1,194 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Problem is an invalid setting found by LoadConfig or a finding of CheckPaths.
+type Problem struct {
+	Key     string // YAML key of the setting, empty if the message names it
+	Message string
+	Warning bool // The run can still go ahead, e.g. a directory that is created when needed
+}
+
+func (p Problem) String() string {
+	if p.Key == "" {
+		return p.Message
+	}
+	return p.Key + ": " + p.Message
+}
+
+// CheckPaths checks the directories and files of the configuration on this host:
+// compose and appdata directories must exist, and the directories written to must be
+// writable or creatable. It is run before a backup and by 'config check'; LoadConfig
+// does not touch the filesystem so commands like 'history' work on any host.
+func (c Config) CheckPaths() []Problem {
+	var problems []Problem
+	add := func(key string, warning bool, format string, args ...interface{}) {
+		problems = append(problems, Problem{Key: key, Message: fmt.Sprintf(format, args...), Warning: warning})
+	}
+
+	for i, root := range c.ComposeRoots() {
+		key := "compose_dir"
+		if i > 0 {
+			key = "compose_dirs"
+		}
+		if err := checkDir(root); err != nil {
+			add(key, false, "%v", err)
+		}
+	}
+	if err := checkDir(c.AppdataDir); err != nil {
+		add("appdata_dir", false, "%v", err)
+	}
+
+	writable := []struct {
+		key, dir string
+	}{
+		{"backup_dir", c.BackupDir},
+		{"log_file", filepath.Dir(c.LogFile)},
+	}
+	if c.ProjectLogs.Enabled {
+		writable = append(writable, struct{ key, dir string }{"project_logs.dir", c.ProjectLogs.Dir})
+	}
+	if c.Catalog.Enabled {
+		writable = append(writable, struct{ key, dir string }{"catalog.path", filepath.Dir(c.Catalog.Path)})
+	}
+	if c.Output == OutputRepository {
+		writable = append(writable, struct{ key, dir string }{"repository.path", c.Repository.Path})
+	}
+	for _, w := range writable {
+		created, err := checkWritable(w.dir)
+		if err != nil {
+			add(w.key, false, "%v", err)
+		} else if created != "" {
+			add(w.key, true, "%s does not exist yet and will be created", created)
+		}
+	}
+
+	files := []struct{ key, path string }{
+		{"encryption.recipients_file", c.Encryption.RecipientsFile},
+		{"encryption.passphrase_file", c.Encryption.PassphraseFile},
+	}
+	for _, f := range files {
+		if f.path == "" || !c.Encryption.Enabled {
+			continue
+		}
+		if _, err := os.Stat(f.path); err != nil {
+			add(f.key, false, "cannot read %s: %v", f.path, errors.Unwrap(err))
+		}
+	}
+	if c.Encryption.IdentityFile != "" {
+		if _, err := os.Stat(c.Encryption.IdentityFile); err != nil {
+			add("encryption.identity_file", true, "cannot read %s: %v; restore and verify of encrypted archives will fail", c.Encryption.IdentityFile, errors.Unwrap(err))
+		}
+	}
+	return problems
+}
+
+// checkDir checks that dir exists and is a directory.
+func checkDir(dir string) error {
+	info, err := os.Stat(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("directory %s does not exist", dir)
+		}
+		return fmt.Errorf("cannot access %s: %v", dir, errors.Unwrap(err))
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", dir)
+	}
+	return nil
+}
+
+// checkWritable checks that a file can be created in dir. If dir does not exist, the
+// nearest existing parent is checked instead and dir is returned as created.
+func checkWritable(dir string) (created string, err error) {
+	existing := dir
+	for {
+		info, err := os.Stat(existing)
+		if err == nil {
+			if !info.IsDir() {
+				return "", fmt.Errorf("%s is not a directory", existing)
+			}
+			break
+		}
+		if !os.IsNotExist(err) {
+			return "", fmt.Errorf("cannot access %s: %v", existing, errors.Unwrap(err))
+		}
+		parent := filepath.Dir(existing)
+		if parent == existing {
+			return "", fmt.Errorf("cannot create %s", dir)
+		}
+		existing = parent
+	}
+	f, err := os.CreateTemp(existing, ".docker-backup-check-*")
+	if err != nil {
+		if existing != dir {
+			return "", fmt.Errorf("cannot create %s: %s is not writable", dir, existing)
+		}
+		return "", fmt.Errorf("%s is not writable", dir)
+	}
+	f.Close()
+	os.Remove(f.Name())
+	if existing != dir {
+		return dir, nil
+	}
+	return "", nil
+}
+
+// validateNesting rejects directories that contain each other in a way that makes a
+// backup include its own archives, or the retention and sync of the backup directory
+// touch application data.
+func validateNesting(cfg Config) error {
+	backupDir := absPath(cfg.BackupDir)
+	sources := []struct{ key, dir string }{{"appdata_dir", cfg.AppdataDir}}
+	for i, root := range cfg.ComposeRoots() {
+		key := "compose_dir"
+		if i > 0 {
+			key = "compose_dirs"
+		}
+		sources = append(sources, struct{ key, dir string }{key, root})
+	}
+	for _, s := range sources {
+		dir := absPath(s.dir)
+		// A backup_dir in a compose root is fine as long as it holds no compose file
+		if s.key == "appdata_dir" && isWithin(backupDir, dir) {
+			return fmt.Errorf("backup_dir '%s' is inside %s '%s': backups would contain earlier archives; move backup_dir out of it", cfg.BackupDir, s.key, s.dir)
+		}
+		if isWithin(dir, backupDir) {
+			return fmt.Errorf("%s '%s' is inside backup_dir '%s': retention and sync_backup_dir would touch it; move backup_dir elsewhere", s.key, s.dir, cfg.BackupDir)
+		}
+	}
+	return nil
+}
+
+// absPath returns the cleaned absolute form of path, or path itself if it cannot be made absolute.
+func absPath(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+	return filepath.Clean(path)
+}
+
+// isWithin reports whether path is dir or below it.
+func isWithin(path, dir string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// validateExcludePatterns checks the syntax of the exclude_patterns globs.
+func validateExcludePatterns(patterns []string) error {
+	for _, pattern := range patterns {
+		if strings.TrimSpace(pattern) == "" {
+			return fmt.Errorf("invalid exclude_patterns: empty pattern")
+		}
+		if _, err := filepath.Match(filepath.ToSlash(pattern), ""); err != nil {
+			return fmt.Errorf("invalid exclude pattern '%s': %w (patterns use * ? [...] and ** for any directories)", pattern, err)
+		}
+	}
+	return nil
+}
@@ -3,9 +3,12 @@ package config
 import (
 	"flag"
 	"fmt"
-	"log"
 	"os"
-	"strconv"
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	"docker-backup-tool/internal/util"
 
 	"github.com/joho/godotenv"
 	"gopkg.in/yaml.v3"
@@ -23,52 +26,238 @@ type Config struct {
 	Verbose            bool
 	DryRun             bool
 
+	// --- Project Discovery ---
+	ComposeDirs    []string // Additional compose roots, e.g. a Dockge stacks directory
+	DiscoveryDepth int      // Directory levels below a root searched for projects
+	DiscoveryMode  string   // DiscoveryDirectories, DiscoveryContainers or DiscoveryBoth
+	// Compose settings per project, keyed by project name or path relative to the compose root
+	Projects map[string]ProjectConfig
+	// Resolve volumes with all compose profiles enabled, so services behind
+	// profiles are backed up even if they are not in the project's profiles
+	VolumesAllProfiles bool
+
+	// Project selection: names or glob patterns of projects to back up or skip
+	IncludeProjects []string
+	ExcludeProjects []string
+
 	// --- Logging Configuration ---
 	LogFile               string
 	LogRotationMaxSizeMB  int
 	LogRotationMaxBackups int
 	LogRotationMaxAgeDays int
 	LogRotationCompress   bool
+	LogFormat             string // text or json, only affects the log file
+	LogLevel              string // debug, info, warn or error; debug with Verbose
+	ConsoleLogLevel       string // Overrides LogLevel for the console
+	FileLogLevel          string // Overrides LogLevel for the log file
+	Quiet                 bool   // Only print the summary of a run to the console
 
-	Rsync struct {
-		Enabled     bool
-		Destination string
-		Options     string
-		Command     string
-	}
+	ConfigFile string // Config file that was read, empty if none was found
+
+	// Profile of the config file selected with --profile or DOCKER_BACKUP_PROFILE,
+	// empty for the base configuration
+	Profile string
+	// Profiles defined in the config file, and those that set their own
+	// daemon.schedule, which the daemon runs when it is started without a profile
+	Profiles          []string
+	ScheduledProfiles []string
+
+	// Where each setting came from, keyed by YAML path ("rsync.retries",
+	// "destinations[0].name"): "<file>:<line>", "<file>:<line> (profile <name>)",
+	// "env <NAME>", "flag --<name>" or "from <key>" for values derived from another
+	// setting. Unset keys are defaults.
+	Sources map[string]string
+
+	Rsync RsyncConfig
+	S3    S3Config
+
+	// Additional destinations, each with its own retention
+	Destinations []DestinationConfig
+
+	Incremental IncrementalConfig
+
+	Mode       string // ModeArchive or ModeMirror
+	Mirror     MirrorConfig
+	Output     string // OutputZip or OutputRepository
+	Repository RepositoryConfig
+
+	Encryption  EncryptionConfig
+	Compression CompressionConfig
+	SplitSize   int64 // Maximum size of one archive part in bytes, 0 disables splitting
+
+	Notify  NotifyConfig
+	Metrics MetricsConfig
+	Daemon  DaemonConfig
+	Catalog CatalogConfig
+
+	ProjectLogs ProjectLogsConfig
+}
+
+// ProjectLogsConfig configures the log written for each project of a run. It holds
+// every record of the project, including debug messages, and the output of the
+// commands run for it. The log of an archive is stored next to it as <archive>.zip.log
+// and expires with it; logs of projects without an archive (failures, mirror mode,
+// repository output) are kept in Dir.
+type ProjectLogsConfig struct {
+	Enabled  bool   `yaml:"enabled"`
+	Dir      string `yaml:"dir"`       // Defaults to <backup_dir>/logs
+	KeepLast int    `yaml:"keep_last"` // Logs per project kept in Dir, 0 keeps all
+}
+
+// CatalogConfig configures the local history of runs and archives.
+type CatalogConfig struct {
+	Enabled bool   `yaml:"enabled"`
+	Path    string `yaml:"path"` // Defaults to <backup_dir>/catalog.db
+}
+
+// MetricsConfig configures the Prometheus metrics written after each run.
+type MetricsConfig struct {
+	Textfile  string `yaml:"textfile"`   // File for the node_exporter textfile collector, e.g. /var/lib/node_exporter/textfile_collector/docker_backup.prom
+	Listen    string `yaml:"listen"`     // Address serving /metrics in daemon mode, e.g. ":9367"
+	StateFile string `yaml:"state_file"` // Values kept between runs, defaults to <backup_dir>/.metrics-state.json
+}
+
+// DaemonConfig configures the daemon command.
+type DaemonConfig struct {
+	Schedule   string `yaml:"schedule"`     // Cron expression, e.g. "0 3 * * *" or "@daily"
+	RunOnStart bool   `yaml:"run_on_start"` // Run a backup immediately when the daemon starts
+}
+
+// Compression methods for zip archives.
+const (
+	CompressionStore   = "store"
+	CompressionDeflate = "deflate"
+	CompressionZstd    = "zstd"
+)
+
+// CompressionConfig selects how zip entries are compressed.
+type CompressionConfig struct {
+	Method         string `yaml:"method"`          // store, deflate or zstd
+	Level          int    `yaml:"level"`           // 0 uses the method's default level
+	SkipCompressed bool   `yaml:"skip_compressed"` // Store files that are already compressed (jpg, mp4, gz, ...)
+	Threads        int    `yaml:"threads"`         // Encoder goroutines for zstd, 0 uses all CPUs
+}
+
+// EncryptionConfig configures client-side encryption of archives.
+// Archives are encrypted either to age recipients (public keys) or with a passphrase.
+type EncryptionConfig struct {
+	Enabled        bool     `yaml:"enabled"`
+	Recipients     []string `yaml:"recipients"`      // age public keys (age1...)
+	RecipientsFile string   `yaml:"recipients_file"` // File with one age public key per line
+	IdentityFile   string   `yaml:"identity_file"`   // age secret keys used to decrypt on restore/verify
+	PassphraseFile string   `yaml:"passphrase_file"`
+	PassphraseEnv  string   `yaml:"passphrase_env"` // Environment variable holding the passphrase
+}
+
+// Backup output formats.
+const (
+	OutputZip        = "zip"
+	OutputRepository = "repository"
+)
+
+// Backup modes.
+const (
+	ModeArchive = "archive" // Create an archive (or repository snapshot) per project
+	ModeMirror  = "mirror"  // Rsync compose and appdata directories straight to the rsync destinations
+)
+
+// Discovery modes.
+const (
+	DiscoveryDirectories = "directories" // Scan the compose roots
+	DiscoveryContainers  = "containers"  // Use the compose labels of existing containers
+	DiscoveryBoth        = "both"        // Scan the roots and add stacks only known from containers
+)
+
+// MirrorConfig configures mode: mirror.
+type MirrorConfig struct {
+	LinkDest bool `yaml:"link_dest"` // Keep timestamped snapshots, hardlinking unchanged files to the previous one
+	KeepLast int  `yaml:"keep_last"` // Snapshots per project to keep with link_dest, 0 keeps all
+}
+
+// RepositoryConfig configures the content-addressed deduplicating repository output.
+type RepositoryConfig struct {
+	Path     string `yaml:"path"`      // Defaults to <backup_dir>/repository
+	KeepLast int    `yaml:"keep_last"` // Snapshots per project kept by prune
+	KeepDays int    `yaml:"keep_days"` // Snapshots younger than this are kept by prune
+}
+
+// IncrementalConfig controls index-based incremental backups.
+type IncrementalConfig struct {
+	Enabled   bool `yaml:"enabled"`
+	FullEvery int  `yaml:"full_every"` // Number of incrementals taken before the next full backup
 }
 
 // Intermediate structure for unmarshalling YAML, matching YAML keys
 type yamlConfig struct {
-	ComposeDir            string   `yaml:"compose_dir"`
-	AppdataDir            string   `yaml:"appdata_dir"`
-	BackupDir             string   `yaml:"backup_dir"`
-	RestartAfterBackup    bool     `yaml:"restart_after_backup"`
-	PullBeforeRestart     bool     `yaml:"pull_before_restart"`
-	Exclude               []string `yaml:"exclude_patterns"` // Match YAML key
-	Verbose               bool     `yaml:"verbose"`
-	DryRun                bool     `yaml:"dry_run"`
-	LogFile               string   `yaml:"log_file"`
-	LogRotationMaxSizeMB  int      `yaml:"log_rotation_max_size_mb"`
-	LogRotationMaxBackups int      `yaml:"log_rotation_max_backups"`
-	LogRotationMaxAgeDays int      `yaml:"log_rotation_max_age_days"`
-	LogRotationCompress   bool     `yaml:"log_rotation_compress"`
-	Rsync                 struct {
-		Enabled     bool   `yaml:"enabled"`
-		Destination string `yaml:"destination"`
-		Options     string `yaml:"options"`
-		Command     string `yaml:"command"`
-	} `yaml:"rsync"`
+	ComposeDir            string              `yaml:"compose_dir"`
+	ComposeDirs           []string            `yaml:"compose_dirs"`
+	DiscoveryDepth        int                 `yaml:"discovery_depth"`
+	DiscoveryMode         string              `yaml:"discovery_mode"`
+	ComposeFiles          map[string][]string `yaml:"compose_files,omitempty"` // Older form of projects.<name>.compose_files
+	AppdataDir            string              `yaml:"appdata_dir"`
+	BackupDir             string              `yaml:"backup_dir"`
+	RestartAfterBackup    bool                `yaml:"restart_after_backup"`
+	PullBeforeRestart     bool                `yaml:"pull_before_restart"`
+	Exclude               []string            `yaml:"exclude_patterns"` // Match YAML key
+	IncludeProjects       []string            `yaml:"include_projects"`
+	ExcludeProjects       []string            `yaml:"exclude_projects"`
+	Verbose               bool                `yaml:"verbose"`
+	DryRun                bool                `yaml:"dry_run"`
+	LogFile               string              `yaml:"log_file"`
+	LogRotationMaxSizeMB  int                 `yaml:"log_rotation_max_size_mb"`
+	LogRotationMaxBackups int                 `yaml:"log_rotation_max_backups"`
+	LogRotationMaxAgeDays int                 `yaml:"log_rotation_max_age_days"`
+	LogRotationCompress   bool                `yaml:"log_rotation_compress"`
+	LogFormat             string              `yaml:"log_format"`
+	LogLevel              string              `yaml:"log_level"`
+	ConsoleLogLevel       string              `yaml:"console_log_level"`
+	FileLogLevel          string              `yaml:"file_log_level"`
+	Quiet                 bool                `yaml:"quiet"`
+	Rsync                 RsyncConfig         `yaml:"rsync"`
+	S3                    S3Config            `yaml:"s3"`
+	Destinations          []DestinationConfig `yaml:"destinations"`
+	Incremental           IncrementalConfig   `yaml:"incremental"`
+	Mode                  string              `yaml:"mode"`
+	Mirror                MirrorConfig        `yaml:"mirror"`
+	Output                string              `yaml:"output"`
+	Repository            RepositoryConfig    `yaml:"repository"`
+	Encryption            EncryptionConfig    `yaml:"encryption"`
+	Compression           CompressionConfig   `yaml:"compression"`
+	SplitSize             string              `yaml:"split_size"` // e.g. "4GiB", parsed into Config.SplitSize
+	Notify                NotifyConfig        `yaml:"notify"`
+	Metrics               MetricsConfig       `yaml:"metrics"`
+	Daemon                DaemonConfig        `yaml:"daemon"`
+	Catalog               CatalogConfig       `yaml:"catalog"`
+	ProjectLogs           ProjectLogsConfig   `yaml:"project_logs"`
+
+	Projects           map[string]ProjectConfig `yaml:"projects"`
+	VolumesAllProfiles bool                     `yaml:"volumes_all_profiles"`
 }
 
-// LoadConfig reads configuration using standard libraries and godotenv.
-// Precedence: Flags > Environment Variables > Config File > Defaults
-func LoadConfig() (Config, error) {
-	var cfg Config
+// cliFlags are the global command line flags. They are parsed once by LoadConfig
+// and applied again to every profile loaded with LoadProfile.
+type cliFlags struct {
+	configFile, profile                                 *string
+	composeDir, discoveryMode, appdataDir, backupDir    *string
+	logFile, logFormat, logLevel, consoleLogLevel       *string
+	fileLogLevel, rsyncDest, rsyncOpts, rsyncCmd        *string
+	splitSize, compression, identityFile, mode, output  *string
+	discoveryDepth                                      *int
+	restart, pull, verbose, dryRun, quiet, rsyncEnabled *bool
+	incremental                                         *bool
+	project, skip                                       stringList
+	set                                                 keyValueList
+}
+
+// parsedFlags are the flags parsed by LoadConfig.
+var parsedFlags *cliFlags
 
-	// --- 1. Defaults ---
-	defaults := Config{
+// defaultConfig returns the configuration used for keys that are not set anywhere.
+func defaultConfig() Config {
+	return Config{
 		ComposeDir:            "/home/server/compose",
+		DiscoveryDepth:        1,
+		DiscoveryMode:         DiscoveryDirectories,
 		AppdataDir:            "/home/server/appdata",
 		BackupDir:             "./docker_backups",
 		RestartAfterBackup:    false,
@@ -81,44 +270,123 @@ func LoadConfig() (Config, error) {
 		LogRotationMaxBackups: 3,
 		LogRotationMaxAgeDays: 28,
 		LogRotationCompress:   false,
-		Rsync: struct {
-			Enabled     bool
-			Destination string
-			Options     string
-			Command     string
-		}{
-			Enabled:     false,
-			Destination: "",
-			Options:     "--archive --partial --compress --delete",
-			Command:     "rsync",
+		LogFormat:             "text",
+		LogLevel:              "info",
+		Rsync:                 DefaultRsyncConfig(),
+		Incremental: IncrementalConfig{
+			Enabled:   false,
+			FullEvery: 6,
+		},
+		Mode:   ModeArchive,
+		Output: OutputZip,
+		Encryption: EncryptionConfig{
+			PassphraseEnv: "DOCKER_BACKUP_PASSPHRASE",
+		},
+		Compression: CompressionConfig{
+			Method:         CompressionDeflate,
+			Level:          0,
+			SkipCompressed: true,
+			Threads:        0,
+		},
+		Notify: NotifyConfig{
+			Trigger: NotifyFailure,
+		},
+		Catalog: CatalogConfig{
+			Enabled: true,
+		},
+		ProjectLogs: ProjectLogsConfig{
+			Enabled:  true,
+			KeepLast: 14,
 		},
 	}
-	cfg = defaults // Start with defaults
+}
 
-	// --- 2. Config File --- (Requires flag parsing first to find potential custom path)
-	// Define flags here but parse later
-	configFilePath := flag.String("config", "", "Path to configuration file (e.g., config.yaml)")
+// parseFlags defines and parses the global command line flags.
+func parseFlags(defaults Config) *cliFlags {
+	f := &cliFlags{}
+	f.configFile = flag.String("config", "", "Path to configuration file (e.g., config.yaml)")
+	f.profile = flag.String("profile", "", "Profile of the config file to use (see 'profiles' in config.yaml)")
 
-	// Define other flags, using default values from the 'defaults' struct
-	composeDirFlag := flag.String("compose-dir", defaults.ComposeDir, "Directory containing docker compose project subfolders")
-	appdataDirFlag := flag.String("appdata-dir", defaults.AppdataDir, "Base directory containing application data volumes")
-	backupDirFlag := flag.String("backup-dir", defaults.BackupDir, "Directory to store backup zip files")
-	restartFlag := flag.Bool("restart", defaults.RestartAfterBackup, "Restart stacks after successful backup")
-	pullFlag := flag.Bool("pull", defaults.PullBeforeRestart, "Pull latest images before restarting stacks (only if --restart is true)")
+	// Other flags use the default values of the configuration
+	f.composeDir = flag.String("compose-dir", defaults.ComposeDir, "Directory containing docker compose project subfolders")
+	f.discoveryMode = flag.String("discovery", defaults.DiscoveryMode, "Project discovery: directories, containers or both")
+	f.discoveryDepth = flag.Int("discovery-depth", defaults.DiscoveryDepth, "Directory levels below each compose dir searched for projects")
+	f.appdataDir = flag.String("appdata-dir", defaults.AppdataDir, "Base directory containing application data volumes")
+	f.backupDir = flag.String("backup-dir", defaults.BackupDir, "Directory to store backup zip files")
+	f.restart = flag.Bool("restart", defaults.RestartAfterBackup, "Restart stacks after successful backup")
+	f.pull = flag.Bool("pull", defaults.PullBeforeRestart, "Pull latest images before restarting stacks (only if --restart is true)")
 	// Note: StringSlice isn't standard; handle exclude flag manually if needed, or rely on env/config file.
-	verboseFlag := flag.Bool("verbose", defaults.Verbose, "Enable verbose logging (shorthand -v)")
-	flag.BoolVar(verboseFlag, "v", defaults.Verbose, "Enable verbose logging (shorthand for --verbose)") // Shorthand
-	dryRunFlag := flag.Bool("dry-run", defaults.DryRun, "Perform a dry run, showing actions without executing them")
-	logFileFlag := flag.String("log-file", defaults.LogFile, "Path to log file")
-	rsyncEnabledFlag := flag.Bool("rsync-enabled", defaults.Rsync.Enabled, "Enable rsync transfer")
-	rsyncDestFlag := flag.String("rsync-dest", defaults.Rsync.Destination, "Rsync destination (e.g., user@host:/path/)")
-	rsyncOptsFlag := flag.String("rsync-opts", defaults.Rsync.Options, "Additional options for the rsync command")
-	rsyncCmdFlag := flag.String("rsync-cmd", defaults.Rsync.Command, "Path to the rsync command executable")
+	f.verbose = flag.Bool("verbose", defaults.Verbose, "Enable verbose logging (shorthand -v)")
+	flag.BoolVar(f.verbose, "v", defaults.Verbose, "Enable verbose logging (shorthand for --verbose)") // Shorthand
+	flag.Var(&f.project, "project", "Only back up projects matching this name or glob pattern (repeatable or comma-separated)")
+	flag.Var(&f.skip, "skip", "Skip projects matching this name or glob pattern (repeatable or comma-separated)")
+	f.dryRun = flag.Bool("dry-run", defaults.DryRun, "Perform a dry run, showing actions without executing them")
+	f.logFile = flag.String("log-file", defaults.LogFile, "Path to log file")
+	f.logFormat = flag.String("log-format", defaults.LogFormat, "Log file format: text or json")
+	f.logLevel = flag.String("log-level", defaults.LogLevel, "Minimum level logged: debug, info, warn or error")
+	f.consoleLogLevel = flag.String("console-log-level", "", "Minimum level on the console (defaults to --log-level)")
+	f.fileLogLevel = flag.String("file-log-level", "", "Minimum level in the log file (defaults to --log-level)")
+	f.quiet = flag.Bool("quiet", defaults.Quiet, "Only print the summary of a run to the console (shorthand -q)")
+	flag.BoolVar(f.quiet, "q", defaults.Quiet, "Only print the summary of a run to the console (shorthand for --quiet)")
+	f.rsyncEnabled = flag.Bool("rsync-enabled", defaults.Rsync.Enabled, "Enable rsync transfer")
+	f.rsyncDest = flag.String("rsync-dest", defaults.Rsync.Destination, "Rsync destination (e.g., user@host:/path/)")
+	f.rsyncOpts = flag.String("rsync-opts", defaults.Rsync.Options, "Additional options for the rsync command")
+	f.rsyncCmd = flag.String("rsync-cmd", defaults.Rsync.Command, "Path to the rsync command executable")
+	f.splitSize = flag.String("split-size", "", "Split archives into parts of this size (e.g. 4GiB, 700MB)")
+	f.compression = flag.String("compression", defaults.Compression.Method, "Zip compression method: store, deflate or zstd")
+	f.identityFile = flag.String("identity-file", defaults.Encryption.IdentityFile, "age identity file used to decrypt archives on restore/verify")
+	f.mode = flag.String("mode", defaults.Mode, "Backup mode: archive or mirror")
+	f.output = flag.String("output", defaults.Output, "Backup output format: zip or repository")
+	f.incremental = flag.Bool("incremental", defaults.Incremental.Enabled, "Only archive files changed since the previous backup (periodic full backups still run)")
+	flag.Var(&f.set, "set", "Set any config key, e.g. --set rsync.retries=5 or --set notify.trigger=always (repeatable)")
 
 	flag.Parse()
+	return f
+}
+
+// LoadConfig reads configuration using standard libraries and godotenv.
+// Precedence: Flags > Environment Variables > Config File (profile > base) > Defaults
+// Invalid settings are returned as problems next to the configuration, which holds
+// the valid part; the error is only set if the config file cannot be read.
+func LoadConfig() (Config, []Problem, error) {
+	parsedFlags = parseFlags(defaultConfig())
+
+	// .env is loaded first so it can also name the config file and the profile
+	// Ignore "file not found" error for .env
+	_ = godotenv.Load() // Loads .env file into environment variables
+
+	profile := *parsedFlags.profile
+	if profile == "" {
+		profile = os.Getenv(EnvPrefix + "PROFILE")
+	}
+	return load(parsedFlags, profile)
+}
+
+// LoadProfile loads the configuration of another profile from the same config file,
+// environment and flags as LoadConfig, which must have been called before. An empty
+// name loads the base configuration.
+func LoadProfile(name string) (Config, []Problem, error) {
+	if parsedFlags == nil {
+		return Config{}, nil, fmt.Errorf("LoadProfile called before LoadConfig")
+	}
+	return load(parsedFlags, name)
+}
+
+// load builds the configuration from the defaults, the config file with the named
+// profile, the environment and the flags f.
+func load(f *cliFlags, profile string) (Config, []Problem, error) {
+	defaults := defaultConfig()
+	// Invalid settings are collected so 'config check' can show all of them with the
+	// effective configuration; only a config file that cannot be read stops here
+	var problems []Problem
+	invalid := func(err error) {
+		problems = append(problems, Problem{Message: err.Error()})
+	}
+	cfg := defaults // Start with defaults
+	cfg.Sources = make(map[string]string)
 
 	// Determine effective config file path
-	cfgFile := *configFilePath
+	cfgFile := *f.configFile
 	if cfgFile == "" { // If flag not set, check env
 		cfgFile = os.Getenv("DOCKER_BACKUP_CONFIG_FILE")
 	}
@@ -126,174 +394,378 @@ func LoadConfig() (Config, error) {
 		cfgFile = "config.yaml" // Default config file name
 	}
 
+	// The file and the environment are applied to the config file structure, starting
+	// from the defaults: every key that is set replaces the default, including false,
+	// 0 and empty values, and keys that are not set keep it.
+	yamlCfg := defaults.toYAML()
+
 	// Attempt to read config file
 	yamlData, err := os.ReadFile(cfgFile)
 	if err != nil {
 		if os.IsNotExist(err) {
-			log.Printf("Configuration file '%s' not found. Using defaults/env/flags.", cfgFile)
+			// Logged by the caller once logging is set up, see ConfigFile
 		} else {
-			return cfg, fmt.Errorf("error reading config file '%s': %w", cfgFile, err)
+			return cfg, nil, fmt.Errorf("error reading config file '%s': %w", cfgFile, err)
 		}
 	} else {
-		log.Printf("Using configuration file: %s", cfgFile)
-		var yamlCfg yamlConfig
-		err = yaml.Unmarshal(yamlData, &yamlCfg)
-		if err != nil {
-			return cfg, fmt.Errorf("error unmarshalling config file '%s': %w", cfgFile, err)
-		}
-
-		// Override defaults with YAML values where they exist
-		if yamlCfg.ComposeDir != "" {
-			cfg.ComposeDir = yamlCfg.ComposeDir
-		}
-		if yamlCfg.AppdataDir != "" {
-			cfg.AppdataDir = yamlCfg.AppdataDir
-		}
-		if yamlCfg.BackupDir != "" {
-			cfg.BackupDir = yamlCfg.BackupDir
-		}
-		if yamlCfg.RestartAfterBackup {
-			cfg.RestartAfterBackup = yamlCfg.RestartAfterBackup
+		cfg.ConfigFile = cfgFile
+		var doc yaml.Node
+		if err := yaml.Unmarshal(yamlData, &doc); err != nil {
+			return cfg, nil, fmt.Errorf("error unmarshalling config file '%s': %w", cfgFile, err)
 		}
-		if yamlCfg.PullBeforeRestart {
-			cfg.PullBeforeRestart = yamlCfg.PullBeforeRestart
-		}
-		if len(yamlCfg.Exclude) > 0 {
-			cfg.Exclude = yamlCfg.Exclude
-		}
-		if yamlCfg.Verbose {
-			cfg.Verbose = yamlCfg.Verbose
-		}
-		if yamlCfg.DryRun {
-			cfg.DryRun = yamlCfg.DryRun
-		}
-		if yamlCfg.LogFile != "" {
-			cfg.LogFile = yamlCfg.LogFile
-		}
-		if yamlCfg.LogRotationMaxSizeMB != 0 {
-			cfg.LogRotationMaxSizeMB = yamlCfg.LogRotationMaxSizeMB
+		profiles, err := takeProfiles(&doc)
+		if err != nil {
+			return cfg, nil, fmt.Errorf("config file '%s': %w", cfgFile, err)
 		}
-		if yamlCfg.LogRotationMaxBackups != 0 {
-			cfg.LogRotationMaxBackups = yamlCfg.LogRotationMaxBackups
+		cfg.Profiles = profileNames(profiles)
+		// Unknown keys are ignored by the decoder, so the rest of the file still applies
+		unknown := unknownKeys(&doc, reflect.TypeOf(yamlCfg))
+		for _, name := range cfg.Profiles {
+			walkKeys(profiles[name].node, reflect.TypeOf(yamlCfg), profilesKey+"."+name, &unknown)
 		}
-		if yamlCfg.LogRotationMaxAgeDays != 0 {
-			cfg.LogRotationMaxAgeDays = yamlCfg.LogRotationMaxAgeDays
+		for _, msg := range unknown {
+			invalid(fmt.Errorf("%s:%s", cfgFile, strings.TrimPrefix(msg, "line ")))
 		}
-		if yamlCfg.LogRotationCompress {
-			cfg.LogRotationCompress = yamlCfg.LogRotationCompress
+		// Every profile is checked, not just the selected one, so 'config check' finds mistakes
+		for _, name := range cfg.Profiles {
+			if _, err := profileChain(profiles, name); err != nil {
+				invalid(fmt.Errorf("config file '%s': %w", cfgFile, err))
+				continue
+			}
+			if _, ok := fileKeys(profiles[name].node)["daemon.schedule"]; ok {
+				cfg.ScheduledProfiles = append(cfg.ScheduledProfiles, name)
+			}
 		}
 
-		if yamlCfg.Rsync.Enabled {
-			cfg.Rsync.Enabled = yamlCfg.Rsync.Enabled
-		}
-		if yamlCfg.Rsync.Destination != "" {
-			cfg.Rsync.Destination = yamlCfg.Rsync.Destination
-		}
-		if yamlCfg.Rsync.Options != "" {
-			cfg.Rsync.Options = yamlCfg.Rsync.Options
+		if doc.Kind != 0 { // Empty file
+			if err := doc.Decode(&yamlCfg); err != nil {
+				if !decodeProblems(err, cfgFile, "", invalid) {
+					return cfg, nil, fmt.Errorf("error unmarshalling config file '%s': %w", cfgFile, err)
+				}
+			}
 		}
-		if yamlCfg.Rsync.Command != "" {
-			cfg.Rsync.Command = yamlCfg.Rsync.Command
+		for key, line := range fileKeys(&doc) {
+			source := fmt.Sprintf("%s:%d", cfgFile, line)
+			cfg.Sources[key] = source
+			if project, ok := strings.CutPrefix(key, "compose_files."); ok {
+				cfg.Sources["projects."+project+".compose_files"] = source
+			}
 		}
-	}
-
-	// --- 3. Environment Variables --- (Load .env first)
-	// Ignore "file not found" error for .env
-	_ = godotenv.Load() // Loads .env file into environment variables
 
-	// Override config/defaults with ENV vars
-	if envVal := os.Getenv("DOCKER_BACKUP_COMPOSE_DIR"); envVal != "" {
-		cfg.ComposeDir = envVal
-	}
-	if envVal := os.Getenv("DOCKER_BACKUP_APPDATA_DIR"); envVal != "" {
-		cfg.AppdataDir = envVal
-	}
-	if envVal := os.Getenv("DOCKER_BACKUP_BACKUP_DIR"); envVal != "" {
-		cfg.BackupDir = envVal
-	}
-	if envVal := os.Getenv("DOCKER_BACKUP_RESTART_AFTER_BACKUP"); envVal != "" {
-		if b, err := strconv.ParseBool(envVal); err == nil {
-			cfg.RestartAfterBackup = b
-		}
-	}
-	if envVal := os.Getenv("DOCKER_BACKUP_PULL_BEFORE_RESTART"); envVal != "" {
-		if b, err := strconv.ParseBool(envVal); err == nil {
-			cfg.PullBeforeRestart = b
-		}
-	}
-	if envVal := os.Getenv("DOCKER_BACKUP_VERBOSE"); envVal != "" {
-		if b, err := strconv.ParseBool(envVal); err == nil {
-			cfg.Verbose = b
-		}
-	}
-	if envVal := os.Getenv("DOCKER_BACKUP_DRY_RUN"); envVal != "" {
-		if b, err := strconv.ParseBool(envVal); err == nil {
-			cfg.DryRun = b
+		// A profile is decoded over the base configuration, after the profiles it inherits
+		// from: blocks are merged key by key, lists and map entries are replaced.
+		if profile != "" {
+			chain, err := profileChain(profiles, profile)
+			if err != nil {
+				return cfg, nil, fmt.Errorf("config file '%s': %w", cfgFile, err)
+			}
+			for _, p := range chain {
+				if err := p.node.Decode(&yamlCfg); err != nil {
+					if !decodeProblems(err, cfgFile, p.name, invalid) {
+						return cfg, nil, fmt.Errorf("error unmarshalling profile '%s' in config file '%s': %w", p.name, cfgFile, err)
+					}
+				}
+				for _, path := range replacedPaths(p.node, reflect.TypeOf(yamlCfg), "") {
+					for key := range cfg.Sources {
+						if key == path || strings.HasPrefix(key, path+".") || strings.HasPrefix(key, path+"[") {
+							delete(cfg.Sources, key)
+						}
+					}
+				}
+				for key, line := range fileKeys(p.node) {
+					cfg.Sources[key] = fmt.Sprintf("%s:%d (profile %s)", cfgFile, line, p.name)
+				}
+			}
 		}
 	}
-	if envVal := os.Getenv("DOCKER_BACKUP_LOG_FILE"); envVal != "" {
-		cfg.LogFile = envVal
+	if profile != "" && cfg.ConfigFile == "" {
+		return cfg, nil, fmt.Errorf("profile '%s' selected, but config file '%s' was not found", profile, cfgFile)
 	}
-	// Add parsing for other log rotation env vars if needed (e.g., using strconv.Atoi)
+	cfg.Profile = profile
 
-	if envVal := os.Getenv("DOCKER_BACKUP_RSYNC_ENABLED"); envVal != "" {
-		if b, err := strconv.ParseBool(envVal); err == nil {
-			cfg.Rsync.Enabled = b
-		}
+	// --- 3. Environment Variables ---
+	// Every key can be set with DOCKER_BACKUP_<KEY>, see applyEnv
+	envUsed, errs := applyEnv(&yamlCfg)
+	for _, err := range errs {
+		invalid(err)
 	}
-	if envVal := os.Getenv("DOCKER_BACKUP_RSYNC_DESTINATION"); envVal != "" {
-		cfg.Rsync.Destination = envVal
+	for key, name := range envUsed {
+		cfg.Sources[key] = "env " + name
 	}
-	if envVal := os.Getenv("DOCKER_BACKUP_RSYNC_OPTIONS"); envVal != "" {
-		cfg.Rsync.Options = envVal
+	// --set applies to any key like the environment, the dedicated flags below override it
+	for _, kv := range f.set {
+		key, value, ok := strings.Cut(kv, "=")
+		if !ok {
+			invalid(fmt.Errorf("invalid --set '%s': must be key=value", kv))
+			continue
+		}
+		key = strings.TrimSpace(key)
+		if err := setKey(reflect.ValueOf(&yamlCfg).Elem(), key, value); err != nil {
+			invalid(fmt.Errorf("invalid --set %s: %w", key, err))
+			continue
+		}
+		cfg.Sources[key] = "flag --set"
 	}
-	if envVal := os.Getenv("DOCKER_BACKUP_RSYNC_COMMAND"); envVal != "" {
-		cfg.Rsync.Command = envVal
+	if err := yamlCfg.apply(&cfg); err != nil {
+		invalid(fmt.Errorf("%w (%s)", err, cfg.source("split_size")))
 	}
-	// Note: Handling exclude list via ENV is complex; recommend using config file.
 
 	// --- 4. Flags --- (Override all previous values if flag was set)
 	// Check if a flag was actually set on the command line
 	flagSet := make(map[string]bool)
-	flag.Visit(func(f *flag.Flag) { flagSet[f.Name] = true })
+	flag.Visit(func(fl *flag.Flag) {
+		flagSet[fl.Name] = true
+		if key, ok := flagKeys[fl.Name]; ok {
+			if len(fl.Name) == 1 {
+				cfg.Sources[key] = "flag -" + fl.Name
+			} else {
+				cfg.Sources[key] = "flag --" + fl.Name
+			}
+		}
+	})
 
 	if flagSet["compose-dir"] {
-		cfg.ComposeDir = *composeDirFlag
+		cfg.ComposeDir = *f.composeDir
+	}
+	if flagSet["discovery"] {
+		cfg.DiscoveryMode = *f.discoveryMode
+	}
+	if flagSet["discovery-depth"] {
+		cfg.DiscoveryDepth = *f.discoveryDepth
 	}
 	if flagSet["appdata-dir"] {
-		cfg.AppdataDir = *appdataDirFlag
+		cfg.AppdataDir = *f.appdataDir
 	}
 	if flagSet["backup-dir"] {
-		cfg.BackupDir = *backupDirFlag
+		cfg.BackupDir = *f.backupDir
 	}
 	if flagSet["restart"] {
-		cfg.RestartAfterBackup = *restartFlag
+		cfg.RestartAfterBackup = *f.restart
 	}
 	if flagSet["pull"] {
-		cfg.PullBeforeRestart = *pullFlag
+		cfg.PullBeforeRestart = *f.pull
 	}
 	if flagSet["verbose"] || flagSet["v"] {
-		cfg.Verbose = *verboseFlag
+		cfg.Verbose = *f.verbose
 	}
 	if flagSet["dry-run"] {
-		cfg.DryRun = *dryRunFlag
+		cfg.DryRun = *f.dryRun
 	}
 	if flagSet["log-file"] {
-		cfg.LogFile = *logFileFlag
+		cfg.LogFile = *f.logFile
+	}
+	if flagSet["log-format"] {
+		cfg.LogFormat = *f.logFormat
+	}
+	if flagSet["project"] {
+		cfg.IncludeProjects = f.project
+	}
+	if flagSet["skip"] {
+		cfg.ExcludeProjects = f.skip
+	}
+	if flagSet["log-level"] {
+		cfg.LogLevel = *f.logLevel
+	}
+	if flagSet["console-log-level"] {
+		cfg.ConsoleLogLevel = *f.consoleLogLevel
+	}
+	if flagSet["file-log-level"] {
+		cfg.FileLogLevel = *f.fileLogLevel
+	}
+	if flagSet["quiet"] || flagSet["q"] {
+		cfg.Quiet = *f.quiet
 	}
 	if flagSet["rsync-enabled"] {
-		cfg.Rsync.Enabled = *rsyncEnabledFlag
+		cfg.Rsync.Enabled = *f.rsyncEnabled
 	}
 	if flagSet["rsync-dest"] {
-		cfg.Rsync.Destination = *rsyncDestFlag
+		cfg.Rsync.Destination = *f.rsyncDest
 	}
 	if flagSet["rsync-opts"] {
-		cfg.Rsync.Options = *rsyncOptsFlag
+		cfg.Rsync.Options = *f.rsyncOpts
 	}
 	if flagSet["rsync-cmd"] {
-		cfg.Rsync.Command = *rsyncCmdFlag
+		cfg.Rsync.Command = *f.rsyncCmd
+	}
+	if flagSet["split-size"] {
+		if splitSize, err := util.ParseSize(*f.splitSize); err != nil {
+			invalid(fmt.Errorf("invalid --split-size: %w", err))
+		} else {
+			cfg.SplitSize = splitSize
+		}
+	}
+	if flagSet["compression"] {
+		cfg.Compression.Method = *f.compression
+	}
+	if flagSet["identity-file"] {
+		cfg.Encryption.IdentityFile = *f.identityFile
+	}
+	if flagSet["mode"] {
+		cfg.Mode = *f.mode
+	}
+	if flagSet["output"] {
+		cfg.Output = *f.output
+	}
+	if flagSet["incremental"] {
+		cfg.Incremental.Enabled = *f.incremental
 	}
 	// Handle exclude flag if implemented (would require custom parsing)
 
-	return cfg, nil
+	if cfg.LogFormat != "text" && cfg.LogFormat != "json" {
+		invalid(fmt.Errorf("invalid log_format '%s': must be 'text' or 'json'", cfg.LogFormat))
+	}
+	switch cfg.DiscoveryMode {
+	case DiscoveryDirectories, DiscoveryContainers, DiscoveryBoth:
+	default:
+		invalid(fmt.Errorf("invalid discovery_mode '%s': must be '%s', '%s' or '%s'", cfg.DiscoveryMode,
+			DiscoveryDirectories, DiscoveryContainers, DiscoveryBoth))
+	}
+	if cfg.DiscoveryDepth < 1 {
+		invalid(fmt.Errorf("invalid discovery_depth %d: must be at least 1", cfg.DiscoveryDepth))
+	}
+	for _, pattern := range append(append([]string{}, cfg.IncludeProjects...), cfg.ExcludeProjects...) {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			invalid(fmt.Errorf("invalid project pattern '%s': %w", pattern, err))
+		}
+	}
+	// Verbose is a shorthand for log_level debug; the console and file levels still override it
+	if cfg.Verbose {
+		cfg.LogLevel = "debug"
+		cfg.Sources["log_level"] = "from verbose"
+	}
+	if cfg.ConsoleLogLevel == "" {
+		cfg.ConsoleLogLevel = cfg.LogLevel
+		cfg.Sources["console_log_level"] = "from log_level"
+	}
+	if cfg.FileLogLevel == "" {
+		cfg.FileLogLevel = cfg.LogLevel
+		cfg.Sources["file_log_level"] = "from log_level"
+	}
+	for _, l := range []struct{ key, level string }{
+		{"log_level", cfg.LogLevel}, {"console_log_level", cfg.ConsoleLogLevel}, {"file_log_level", cfg.FileLogLevel},
+	} {
+		switch strings.ToLower(l.level) {
+		case "debug", "info", "warn", "warning", "error":
+		default:
+			invalid(fmt.Errorf("invalid %s '%s': must be debug, info, warn or error", l.key, l.level))
+		}
+	}
+	for _, d := range []struct{ key, dir string }{
+		{"compose_dir", cfg.ComposeDir}, {"appdata_dir", cfg.AppdataDir}, {"backup_dir", cfg.BackupDir},
+	} {
+		if strings.TrimSpace(d.dir) == "" {
+			invalid(fmt.Errorf("%s must not be empty", d.key))
+		}
+	}
+	if err := validateProjects(cfg.Projects); err != nil {
+		invalid(err)
+	}
+	if err := validateExcludePatterns(cfg.Exclude); err != nil {
+		invalid(err)
+	}
+	if err := validateNesting(cfg); err != nil {
+		invalid(err)
+	}
+	if cfg.Rsync.Enabled {
+		if cfg.Rsync.Destination == "" {
+			invalid(fmt.Errorf("rsync.destination is required when rsync is enabled"))
+		} else if err := validateRsyncDestination(cfg.Rsync.Destination); err != nil {
+			invalid(err)
+		}
+	}
+	if cfg.Mode != ModeArchive && cfg.Mode != ModeMirror {
+		invalid(fmt.Errorf("invalid mode '%s': must be '%s' or '%s'", cfg.Mode, ModeArchive, ModeMirror))
+	}
+	if cfg.Output != OutputZip && cfg.Output != OutputRepository {
+		invalid(fmt.Errorf("invalid output '%s': must be '%s' or '%s'", cfg.Output, OutputZip, OutputRepository))
+	}
+	switch cfg.Compression.Method {
+	case CompressionStore, CompressionDeflate, CompressionZstd:
+	default:
+		invalid(fmt.Errorf("invalid compression.method '%s': must be '%s', '%s' or '%s'",
+			cfg.Compression.Method, CompressionStore, CompressionDeflate, CompressionZstd))
+	}
+	if cfg.Encryption.Enabled && cfg.Output == OutputRepository {
+		invalid(fmt.Errorf("encryption is only supported for '%s' output", OutputZip))
+	}
+	if cfg.Repository.Path == "" {
+		cfg.Repository.Path = filepath.Join(cfg.BackupDir, "repository")
+		cfg.Sources["repository.path"] = "from backup_dir"
+	}
+	if cfg.Notify.StateFile == "" {
+		cfg.Notify.StateFile = filepath.Join(cfg.BackupDir, ".notify-state.json")
+		cfg.Sources["notify.state_file"] = "from backup_dir"
+	}
+	if cfg.Metrics.StateFile == "" {
+		cfg.Metrics.StateFile = filepath.Join(cfg.BackupDir, ".metrics-state.json")
+		cfg.Sources["metrics.state_file"] = "from backup_dir"
+	}
+	if cfg.Catalog.Path == "" {
+		cfg.Catalog.Path = filepath.Join(cfg.BackupDir, "catalog.db")
+		cfg.Sources["catalog.path"] = "from backup_dir"
+	}
+	if cfg.ProjectLogs.Dir == "" {
+		cfg.ProjectLogs.Dir = filepath.Join(cfg.BackupDir, "logs")
+		cfg.Sources["project_logs.dir"] = "from backup_dir"
+	}
+	if cfg.ProjectLogs.KeepLast < 0 {
+		invalid(fmt.Errorf("invalid project_logs.keep_last %d: must not be negative", cfg.ProjectLogs.KeepLast))
+	}
+	if err := validateNotify(&cfg.Notify); err != nil {
+		invalid(err)
+	}
+	if err := validateDestinations(cfg.Destinations); err != nil {
+		invalid(err)
+	}
+	if cfg.S3.Enabled {
+		if err := validateS3(cfg.S3.S3DestinationConfig); err != nil {
+			invalid(err)
+		}
+	}
+
+	return cfg, problems, nil
+}
+
+// ComposeRoots returns compose_dir followed by the additional compose_dirs, without duplicates.
+func (c Config) ComposeRoots() []string {
+	roots := []string{c.ComposeDir}
+	seen := map[string]bool{filepath.Clean(c.ComposeDir): true}
+	for _, dir := range c.ComposeDirs {
+		if !seen[filepath.Clean(dir)] {
+			seen[filepath.Clean(dir)] = true
+			roots = append(roots, dir)
+		}
+	}
+	return roots
+}
+
+// stringList is a flag that can be repeated; each value may also hold a comma-separated list.
+type stringList []string
+
+func (l *stringList) String() string { return strings.Join(*l, ",") }
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, splitList(value)...)
+	return nil
+}
+
+// keyValueList is a repeatable flag of key=value pairs; values may contain commas.
+type keyValueList []string
+
+func (l *keyValueList) String() string { return strings.Join(*l, " ") }
+
+func (l *keyValueList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// splitList splits a comma- or newline-separated list, dropping empty entries.
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.FieldsFunc(s, func(r rune) bool { return r == ',' || r == '\n' }) {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
 }
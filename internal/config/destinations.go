@@ -0,0 +1,260 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Destination types.
+const (
+	DestinationRsync  = "rsync"
+	DestinationLocal  = "local"
+	DestinationSFTP   = "sftp"
+	DestinationWebDAV = "webdav"
+	DestinationS3     = "s3"
+)
+
+// RsyncConfig configures transfers with the external rsync command.
+type RsyncConfig struct {
+	Enabled     bool   `yaml:"enabled"`
+	Destination string `yaml:"destination"` // e.g. user@host:/path/
+	Options     string `yaml:"options"`
+	Command     string `yaml:"command"`
+
+	Retries          int           `yaml:"retries"`           // Additional attempts after a failed transfer
+	RetryDelay       time.Duration `yaml:"retry_delay"`       // Wait before the first retry, doubled for each further one
+	Timeout          time.Duration `yaml:"timeout"`           // Limit for the whole transfer including retries, 0 disables it
+	ProgressInterval time.Duration `yaml:"progress_interval"` // How often progress is logged, 0 disables progress output
+
+	// SyncBackupDir syncs the whole backup directory in one rsync call at the end of the
+	// run (with --delete) instead of uploading each archive as it is created.
+	SyncBackupDir bool `yaml:"sync_backup_dir"`
+
+	// Retention of the top-level rsync block. Entries of the destinations list use their own retention key.
+	Retention RetentionConfig `yaml:"retention"`
+}
+
+// DefaultRsyncConfig returns the rsync settings used when a config file leaves them out.
+func DefaultRsyncConfig() RsyncConfig {
+	return RsyncConfig{
+		Options:          "--archive --partial --compress --delete",
+		Command:          "rsync",
+		Retries:          3,
+		RetryDelay:       10 * time.Second,
+		ProgressInterval: 30 * time.Second,
+	}
+}
+
+// RetentionConfig decides which archives are kept at a destination.
+// An archive is kept if it matches any rule; with no rules set nothing is deleted.
+type RetentionConfig struct {
+	KeepLast int `yaml:"keep_last"` // Keep the newest N archives per project
+	KeepDays int `yaml:"keep_days"` // Keep archives younger than N days
+}
+
+// DestinationConfig is one entry of the 'destinations' list. Only the block
+// matching Type is used.
+type DestinationConfig struct {
+	Name      string          `yaml:"name"`
+	Type      string          `yaml:"type"`
+	Retention RetentionConfig `yaml:"retention"`
+
+	Rsync  RsyncConfig             `yaml:"rsync"`
+	Local  LocalDestinationConfig  `yaml:"local"`
+	SFTP   SFTPDestinationConfig   `yaml:"sftp"`
+	WebDAV WebDAVDestinationConfig `yaml:"webdav"`
+	S3     S3DestinationConfig     `yaml:"s3"`
+}
+
+// UnmarshalYAML fills in the rsync defaults before decoding, so keys left out of
+// a destination entry keep their default value.
+func (d *DestinationConfig) UnmarshalYAML(value *yaml.Node) error {
+	type plain DestinationConfig
+	p := plain{Rsync: DefaultRsyncConfig()}
+	if err := value.Decode(&p); err != nil {
+		return err
+	}
+	*d = DestinationConfig(p)
+	return nil
+}
+
+// LocalDestinationConfig copies archives to a local or mounted directory.
+type LocalDestinationConfig struct {
+	Path string `yaml:"path"`
+}
+
+// SFTPDestinationConfig uploads archives over SFTP.
+type SFTPDestinationConfig struct {
+	Host           string `yaml:"host"`
+	Port           int    `yaml:"port"` // Defaults to 22
+	User           string `yaml:"user"`
+	Password       string `yaml:"password"`
+	PrivateKeyFile string `yaml:"private_key_file"`
+	PassphraseFile string `yaml:"passphrase_file"`  // Passphrase of an encrypted private key
+	KnownHostsFile string `yaml:"known_hosts_file"` // Defaults to ~/.ssh/known_hosts
+	Path           string `yaml:"path"`             // Remote directory, created if missing
+	Verify         string `yaml:"verify"`           // sha256 (default) or size
+}
+
+// SFTP verification modes.
+const (
+	VerifySHA256 = "sha256"
+	VerifySize   = "size"
+)
+
+// WebDAVDestinationConfig uploads archives to a WebDAV collection.
+type WebDAVDestinationConfig struct {
+	URL      string `yaml:"url"` // Collection URL, e.g. https://cloud.example.com/remote.php/dav/files/me/backups/
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+}
+
+// S3DestinationConfig uploads archives to S3-compatible object storage.
+type S3DestinationConfig struct {
+	Endpoint  string `yaml:"endpoint"` // e.g. https://s3.eu-central-003.backblazeb2.com
+	Region    string `yaml:"region"`
+	Bucket    string `yaml:"bucket"`
+	Prefix    string `yaml:"prefix"` // Key prefix, e.g. "docker/"
+	AccessKey string `yaml:"access_key"`
+	SecretKey string `yaml:"secret_key"`
+	PathStyle bool   `yaml:"path_style"` // Use https://endpoint/bucket/key instead of https://bucket.endpoint/key
+
+	StorageClass string           `yaml:"storage_class"` // e.g. STANDARD_IA, GLACIER_IR; empty uses the bucket default
+	PartSize     string           `yaml:"part_size"`     // Multipart part size, e.g. "64MiB"; larger files are uploaded in parts
+	Checksum     string           `yaml:"checksum"`      // sha256 (default) or md5 for servers without additional checksums
+	ObjectLock   ObjectLockConfig `yaml:"object_lock"`
+}
+
+// S3 checksum algorithms.
+const (
+	ChecksumSHA256 = "sha256"
+	ChecksumMD5    = "md5"
+)
+
+// ObjectLockConfig sets an object lock retention on uploaded archives.
+// The bucket must have object lock enabled.
+type ObjectLockConfig struct {
+	Mode string `yaml:"mode"` // GOVERNANCE or COMPLIANCE; empty disables object lock
+	Days int    `yaml:"days"` // Archives cannot be deleted or overwritten for this many days
+}
+
+// S3Config is the top-level s3 block: a single S3 destination configured
+// alongside the rsync block.
+type S3Config struct {
+	Enabled             bool `yaml:"enabled"`
+	S3DestinationConfig `yaml:",inline"`
+	Retention           RetentionConfig `yaml:"retention"`
+}
+
+// validateDestinations checks destination types and fills in default names.
+// Names must be unique since they identify destinations in logs.
+func validateDestinations(destinations []DestinationConfig) error {
+	seen := make(map[string]bool)
+	for i := range destinations {
+		d := &destinations[i]
+		switch d.Type {
+		case DestinationRsync, DestinationLocal, DestinationSFTP, DestinationWebDAV, DestinationS3:
+		case "":
+			return fmt.Errorf("destinations[%d]: type is required", i)
+		default:
+			return fmt.Errorf("destinations[%d]: unknown type '%s' (must be %s, %s, %s, %s or %s)", i, d.Type,
+				DestinationRsync, DestinationLocal, DestinationSFTP, DestinationWebDAV, DestinationS3)
+		}
+		if d.Type == DestinationSFTP {
+			switch d.SFTP.Verify {
+			case "", VerifySHA256, VerifySize:
+			default:
+				return fmt.Errorf("destinations[%d]: invalid sftp.verify '%s': must be '%s' or '%s'", i, d.SFTP.Verify, VerifySHA256, VerifySize)
+			}
+		}
+		if d.Type == DestinationRsync {
+			if d.Rsync.Destination == "" {
+				return fmt.Errorf("destinations[%d]: rsync.destination is required", i)
+			}
+			if err := validateRsyncDestination(d.Rsync.Destination); err != nil {
+				return fmt.Errorf("destinations[%d]: %w", i, err)
+			}
+		}
+		if d.Type == DestinationS3 {
+			if err := validateS3(d.S3); err != nil {
+				return fmt.Errorf("destinations[%d]: %w", i, err)
+			}
+		}
+		if d.Name == "" {
+			d.Name = fmt.Sprintf("%s-%d", d.Type, i+1)
+		}
+		if seen[d.Name] {
+			return fmt.Errorf("destinations[%d]: duplicate name '%s'", i, d.Name)
+		}
+		seen[d.Name] = true
+	}
+	return nil
+}
+
+// validateRsyncDestination checks that dest is in one of the forms rsync understands:
+// a local path, [user@]host:path over SSH, [user@]host::module or rsync://[user@]host[:port]/module.
+func validateRsyncDestination(dest string) error {
+	invalid := func(reason string) error {
+		return fmt.Errorf("invalid rsync destination '%s': %s (expected user@host:/path, host::module, rsync://host/module or a local path)", dest, reason)
+	}
+	if strings.ContainsAny(dest, "\n\r") {
+		return invalid("contains a line break")
+	}
+	if scheme, rest, ok := strings.Cut(dest, "://"); ok {
+		if scheme != "rsync" {
+			return invalid(fmt.Sprintf("unsupported scheme '%s://'", scheme))
+		}
+		host, module, _ := strings.Cut(rest, "/")
+		if i := strings.LastIndex(host, "@"); i >= 0 {
+			host = host[i+1:]
+		}
+		if host == "" || module == "" {
+			return invalid("rsync:// needs a host and a module")
+		}
+		return nil
+	}
+	// A colon before the first slash separates the host; otherwise dest is a local path
+	colon := strings.Index(dest, ":")
+	if colon < 0 || (strings.Contains(dest[:colon], "/")) {
+		return nil
+	}
+	host := dest[:colon]
+	if i := strings.LastIndex(host, "@"); i >= 0 {
+		if i == 0 {
+			return invalid("empty user name before '@'")
+		}
+		host = host[i+1:]
+	}
+	if host == "" {
+		return invalid("empty host name")
+	}
+	if strings.ContainsAny(host, " \t") {
+		return invalid("host name contains spaces")
+	}
+	if strings.HasPrefix(dest[colon:], "::") && len(dest) == colon+2 {
+		return invalid("empty module name after '::'")
+	}
+	return nil
+}
+
+// validateS3 checks the options of an S3 destination that have a fixed set of values.
+func validateS3(c S3DestinationConfig) error {
+	switch c.Checksum {
+	case "", ChecksumSHA256, ChecksumMD5:
+	default:
+		return fmt.Errorf("invalid s3.checksum '%s': must be '%s' or '%s'", c.Checksum, ChecksumSHA256, ChecksumMD5)
+	}
+	switch c.ObjectLock.Mode {
+	case "":
+	case "GOVERNANCE", "COMPLIANCE":
+		if c.ObjectLock.Days <= 0 {
+			return fmt.Errorf("s3.object_lock.days must be greater than 0 when object_lock.mode is set")
+		}
+	default:
+		return fmt.Errorf("invalid s3.object_lock.mode '%s': must be GOVERNANCE or COMPLIANCE", c.ObjectLock.Mode)
+	}
+	return nil
+}
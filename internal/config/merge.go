@@ -0,0 +1,307 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"docker-backup-tool/internal/util"
+
+	"gopkg.in/yaml.v3"
+)
+
+// EnvPrefix is the prefix of the environment variables. Every key of the config file
+// has one, named after its path: rsync.retries is DOCKER_BACKUP_RSYNC_RETRIES.
+const EnvPrefix = "DOCKER_BACKUP_"
+
+// envAliases are environment variables that do not follow the naming scheme, kept
+// for existing setups.
+var envAliases = map[string]string{
+	"DOCKER_BACKUP_SCHEDULE": "daemon.schedule",
+}
+
+// envName returns the environment variable of a key path.
+func envName(key string) string {
+	return EnvPrefix + strings.ToUpper(strings.ReplaceAll(key, ".", "_"))
+}
+
+// settingKeys returns the paths of all keys that hold a value rather than a block,
+// in the order of the config file structure. Lists and maps are single values.
+func settingKeys(t reflect.Type, path string) []string {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct || t == reflect.TypeOf(time.Time{}) {
+		return []string{path}
+	}
+	var keys []string
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		name, opts, _ := strings.Cut(f.Tag.Get("yaml"), ",")
+		if name == "-" {
+			continue
+		}
+		if strings.Contains(opts, "inline") {
+			keys = append(keys, settingKeys(f.Type, path)...)
+			continue
+		}
+		if name == "" {
+			name = strings.ToLower(f.Name)
+		}
+		keys = append(keys, settingKeys(f.Type, joinKey(path, name))...)
+	}
+	return keys
+}
+
+// applyEnv sets every key whose environment variable is set and not empty. The
+// names of the variables that were used are returned by key, with an error for
+// each variable whose value is invalid.
+func applyEnv(y *yamlConfig) (map[string]string, []error) {
+	used := make(map[string]string)
+	var errs []error
+	apply := func(name, key string) {
+		value := os.Getenv(name)
+		if value == "" {
+			return
+		}
+		if err := setKey(reflect.ValueOf(y).Elem(), key, value); err != nil {
+			errs = append(errs, fmt.Errorf("invalid %s: %w", name, err))
+			return
+		}
+		used[key] = name
+	}
+	for name, key := range envAliases {
+		apply(name, key)
+	}
+	for _, key := range settingKeys(reflect.TypeOf(*y), "") {
+		apply(envName(key), key)
+	}
+	return used, errs
+}
+
+// setKey sets the value at a key path such as "rsync.retries" or
+// "projects.nextcloud.profiles" from its string form, see parseValue.
+func setKey(v reflect.Value, key, value string) error {
+	name, rest, nested := strings.Cut(key, ".")
+	for v.Kind() == reflect.Pointer {
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		v = v.Elem()
+	}
+	switch v.Kind() {
+	case reflect.Struct:
+		field, ok := fieldByKey(v, name)
+		if !ok {
+			if suggestion := closestKey(name, yamlFields(v.Type())); suggestion != "" {
+				return fmt.Errorf("unknown key '%s' (did you mean '%s'?)", name, suggestion)
+			}
+			return fmt.Errorf("unknown key '%s'", name)
+		}
+		if !nested {
+			return parseValue(field, value)
+		}
+		return setKey(field, rest, value)
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String {
+			return fmt.Errorf("cannot set '%s'", key)
+		}
+		if v.IsNil() {
+			v.Set(reflect.MakeMap(v.Type()))
+		}
+		// Map values are not addressable, so the entry is changed on a copy
+		mapKey := reflect.ValueOf(name).Convert(v.Type().Key())
+		entry := reflect.New(v.Type().Elem()).Elem()
+		if existing := v.MapIndex(mapKey); existing.IsValid() {
+			entry.Set(existing)
+		}
+		var err error
+		if nested {
+			err = setKey(entry, rest, value)
+		} else {
+			err = parseValue(entry, value)
+		}
+		if err != nil {
+			return err
+		}
+		v.SetMapIndex(mapKey, entry)
+		return nil
+	default:
+		return fmt.Errorf("'%s' has no key '%s'", strings.TrimSuffix(key, "."+rest), name)
+	}
+}
+
+// fieldByKey returns the struct field with the YAML key name, looking into inlined structs.
+func fieldByKey(v reflect.Value, name string) (reflect.Value, bool) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		tag, opts, _ := strings.Cut(f.Tag.Get("yaml"), ",")
+		if strings.Contains(opts, "inline") {
+			if field, ok := fieldByKey(v.Field(i), name); ok {
+				return field, true
+			}
+			continue
+		}
+		if tag == "" {
+			tag = strings.ToLower(f.Name)
+		}
+		if tag == name {
+			return v.Field(i), true
+		}
+	}
+	return reflect.Value{}, false
+}
+
+// parseValue sets v from a string given in the environment or with --set. Booleans,
+// numbers and durations use the Go syntax, strings are taken as they are and lists of
+// strings are comma- or newline-separated. Other values (lists of blocks, maps) are
+// YAML, e.g. DOCKER_BACKUP_DESTINATIONS='[{type: local, local: {path: /mnt/nas}}]'.
+func parseValue(v reflect.Value, s string) error {
+	switch {
+	case v.Type() == reflect.TypeOf(time.Duration(0)):
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return fmt.Errorf("'%s' is not a duration (e.g. 30s, 10m, 2h)", s)
+		}
+		v.SetInt(int64(d))
+	case v.Kind() == reflect.String:
+		v.SetString(s)
+	case v.Kind() == reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return fmt.Errorf("'%s' is not a boolean (true or false)", s)
+		}
+		v.SetBool(b)
+	case v.Kind() == reflect.Int || v.Kind() == reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return fmt.Errorf("'%s' is not a number", s)
+		}
+		v.SetInt(n)
+	case v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.String && !strings.HasPrefix(strings.TrimSpace(s), "["):
+		items := splitList(s)
+		list := reflect.MakeSlice(v.Type(), len(items), len(items))
+		for i, item := range items {
+			list.Index(i).SetString(item)
+		}
+		v.Set(list)
+	default:
+		target := reflect.New(v.Type())
+		if err := yaml.Unmarshal([]byte(s), target.Interface()); err != nil {
+			return fmt.Errorf("'%s' is not valid YAML for this setting: %w", s, err)
+		}
+		v.Set(target.Elem())
+	}
+	return nil
+}
+
+// toYAML converts the configuration back to the config file structure.
+func (c Config) toYAML() yamlConfig {
+	y := yamlConfig{
+		ComposeDir:            c.ComposeDir,
+		ComposeDirs:           c.ComposeDirs,
+		DiscoveryDepth:        c.DiscoveryDepth,
+		DiscoveryMode:         c.DiscoveryMode,
+		AppdataDir:            c.AppdataDir,
+		BackupDir:             c.BackupDir,
+		RestartAfterBackup:    c.RestartAfterBackup,
+		PullBeforeRestart:     c.PullBeforeRestart,
+		Exclude:               c.Exclude,
+		IncludeProjects:       c.IncludeProjects,
+		ExcludeProjects:       c.ExcludeProjects,
+		Verbose:               c.Verbose,
+		DryRun:                c.DryRun,
+		LogFile:               c.LogFile,
+		LogRotationMaxSizeMB:  c.LogRotationMaxSizeMB,
+		LogRotationMaxBackups: c.LogRotationMaxBackups,
+		LogRotationMaxAgeDays: c.LogRotationMaxAgeDays,
+		LogRotationCompress:   c.LogRotationCompress,
+		LogFormat:             c.LogFormat,
+		LogLevel:              c.LogLevel,
+		ConsoleLogLevel:       c.ConsoleLogLevel,
+		FileLogLevel:          c.FileLogLevel,
+		Quiet:                 c.Quiet,
+		Rsync:                 c.Rsync,
+		S3:                    c.S3,
+		Destinations:          c.Destinations,
+		Incremental:           c.Incremental,
+		Mode:                  c.Mode,
+		Mirror:                c.Mirror,
+		Output:                c.Output,
+		Repository:            c.Repository,
+		Encryption:            c.Encryption,
+		Compression:           c.Compression,
+		Notify:                c.Notify,
+		Metrics:               c.Metrics,
+		Daemon:                c.Daemon,
+		Catalog:               c.Catalog,
+		ProjectLogs:           c.ProjectLogs,
+		Projects:              c.Projects,
+		VolumesAllProfiles:    c.VolumesAllProfiles,
+	}
+	if c.SplitSize > 0 {
+		y.SplitSize = fmt.Sprint(c.SplitSize)
+	}
+	return y
+}
+
+// apply copies the settings of the config file structure into c. An invalid
+// split_size is returned as an error after everything else has been copied.
+func (y yamlConfig) apply(c *Config) error {
+	splitSize, err := util.ParseSize(y.SplitSize)
+	if err != nil {
+		err = fmt.Errorf("invalid split_size: %w", err)
+	}
+	c.ComposeDir = y.ComposeDir
+	c.ComposeDirs = y.ComposeDirs
+	c.DiscoveryDepth = y.DiscoveryDepth
+	c.DiscoveryMode = y.DiscoveryMode
+	c.Projects = mergeComposeFiles(y.Projects, y.ComposeFiles)
+	c.VolumesAllProfiles = y.VolumesAllProfiles
+	c.AppdataDir = y.AppdataDir
+	c.BackupDir = y.BackupDir
+	c.RestartAfterBackup = y.RestartAfterBackup
+	c.PullBeforeRestart = y.PullBeforeRestart
+	c.Exclude = y.Exclude
+	c.IncludeProjects = y.IncludeProjects
+	c.ExcludeProjects = y.ExcludeProjects
+	c.Verbose = y.Verbose
+	c.DryRun = y.DryRun
+	c.LogFile = y.LogFile
+	c.LogRotationMaxSizeMB = y.LogRotationMaxSizeMB
+	c.LogRotationMaxBackups = y.LogRotationMaxBackups
+	c.LogRotationMaxAgeDays = y.LogRotationMaxAgeDays
+	c.LogRotationCompress = y.LogRotationCompress
+	c.LogFormat = y.LogFormat
+	c.LogLevel = y.LogLevel
+	c.ConsoleLogLevel = y.ConsoleLogLevel
+	c.FileLogLevel = y.FileLogLevel
+	c.Quiet = y.Quiet
+	c.Rsync = y.Rsync
+	c.S3 = y.S3
+	c.Destinations = y.Destinations
+	c.Incremental = y.Incremental
+	c.Mode = y.Mode
+	c.Mirror = y.Mirror
+	c.Output = y.Output
+	c.Repository = y.Repository
+	c.Encryption = y.Encryption
+	c.Compression = y.Compression
+	c.SplitSize = splitSize
+	c.Notify = y.Notify
+	c.Metrics = y.Metrics
+	c.Daemon = y.Daemon
+	c.Catalog = y.Catalog
+	c.ProjectLogs = y.ProjectLogs
+	return err
+}
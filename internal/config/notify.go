@@ -0,0 +1,116 @@
+package config
+
+import "fmt"
+
+// Notification triggers.
+const (
+	NotifyAlways  = "always"  // After every run
+	NotifyFailure = "failure" // When a project or transfer failed
+	NotifyChange  = "change"  // When the overall or a project's status differs from the previous run
+)
+
+// Notification provider types.
+const (
+	NotifierWebhook = "webhook"
+	NotifierNtfy    = "ntfy"
+	NotifierGotify  = "gotify"
+	NotifierDiscord = "discord"
+	NotifierSlack   = "slack"
+	NotifierSMTP    = "smtp"
+)
+
+// NotifyConfig configures the summary sent after each run.
+type NotifyConfig struct {
+	Trigger   string           `yaml:"trigger"`    // always, failure (default) or change
+	StateFile string           `yaml:"state_file"` // Status of the previous run for trigger: change, defaults to <backup_dir>/.notify-state.json
+	Title     string           `yaml:"title"`      // text/template for the title or subject, empty uses the built-in one
+	Body      string           `yaml:"body"`       // text/template for the message, empty uses the built-in one
+	Providers []NotifierConfig `yaml:"providers"`
+}
+
+// NotifierConfig is one entry of notify.providers. Which fields are used depends on Type.
+type NotifierConfig struct {
+	Name    string `yaml:"name"`
+	Type    string `yaml:"type"`
+	Trigger string `yaml:"trigger"` // Overrides notify.trigger for this provider
+
+	URL      string            `yaml:"url"`      // Webhook URL, ntfy topic URL or Gotify server URL
+	Token    string            `yaml:"token"`    // ntfy access token or Gotify application token
+	Username string            `yaml:"username"` // Basic auth for webhook and ntfy
+	Password string            `yaml:"password"`
+	Priority int               `yaml:"priority"` // ntfy (1-5) or Gotify (0-10) priority, 0 picks one based on the run status
+	Headers  map[string]string `yaml:"headers"`  // Extra HTTP headers for webhook
+
+	SMTP SMTPConfig `yaml:"smtp"`
+}
+
+// SMTP connection security.
+const (
+	SMTPStartTLS = "starttls"
+	SMTPTLS      = "tls"
+	SMTPNone     = "none"
+)
+
+// SMTPConfig sends notifications by mail.
+type SMTPConfig struct {
+	Host     string   `yaml:"host"`
+	Port     int      `yaml:"port"` // Defaults to 587, or 465 with security: tls
+	Username string   `yaml:"username"`
+	Password string   `yaml:"password"`
+	From     string   `yaml:"from"`
+	To       []string `yaml:"to"`
+	Security string   `yaml:"security"` // starttls (default), tls or none
+}
+
+// validateNotify checks triggers and provider settings and fills in default names.
+func validateNotify(n *NotifyConfig) error {
+	if err := validateTrigger(n.Trigger); err != nil {
+		return fmt.Errorf("notify: %w", err)
+	}
+	seen := make(map[string]bool)
+	for i := range n.Providers {
+		p := &n.Providers[i]
+		if p.Trigger != "" {
+			if err := validateTrigger(p.Trigger); err != nil {
+				return fmt.Errorf("notify.providers[%d]: %w", i, err)
+			}
+		}
+		switch p.Type {
+		case NotifierWebhook, NotifierNtfy, NotifierGotify, NotifierDiscord, NotifierSlack:
+			if p.URL == "" {
+				return fmt.Errorf("notify.providers[%d]: url is required for type '%s'", i, p.Type)
+			}
+		case NotifierSMTP:
+			if p.SMTP.Host == "" || p.SMTP.From == "" || len(p.SMTP.To) == 0 {
+				return fmt.Errorf("notify.providers[%d]: smtp.host, smtp.from and smtp.to are required", i)
+			}
+			switch p.SMTP.Security {
+			case "", SMTPStartTLS, SMTPTLS, SMTPNone:
+			default:
+				return fmt.Errorf("notify.providers[%d]: invalid smtp.security '%s': must be '%s', '%s' or '%s'", i, p.SMTP.Security, SMTPStartTLS, SMTPTLS, SMTPNone)
+			}
+		case "":
+			return fmt.Errorf("notify.providers[%d]: type is required", i)
+		default:
+			return fmt.Errorf("notify.providers[%d]: unknown type '%s' (must be %s, %s, %s, %s, %s or %s)", i, p.Type,
+				NotifierWebhook, NotifierNtfy, NotifierGotify, NotifierDiscord, NotifierSlack, NotifierSMTP)
+		}
+		if p.Name == "" {
+			p.Name = fmt.Sprintf("%s-%d", p.Type, i+1)
+		}
+		if seen[p.Name] {
+			return fmt.Errorf("notify.providers[%d]: duplicate name '%s'", i, p.Name)
+		}
+		seen[p.Name] = true
+	}
+	return nil
+}
+
+func validateTrigger(trigger string) error {
+	switch trigger {
+	case NotifyAlways, NotifyFailure, NotifyChange:
+		return nil
+	default:
+		return fmt.Errorf("invalid trigger '%s': must be '%s', '%s' or '%s'", trigger, NotifyAlways, NotifyFailure, NotifyChange)
+	}
+}
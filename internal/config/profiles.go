@@ -0,0 +1,152 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Keys of the 'profiles' block: each entry is a named profile that overrides keys of
+// the base configuration (the rest of the file) or of the profile named by inherits.
+const (
+	profilesKey = "profiles"
+	inheritsKey = "inherits"
+)
+
+// profile is one entry of the profiles block.
+type profile struct {
+	name     string
+	inherits string     // Profile this one is based on, empty for the base configuration
+	node     *yaml.Node // Keys of the profile without inherits
+}
+
+// takeProfiles removes the profiles block from the document, so the rest decodes as
+// the base configuration, and returns the profiles by name.
+func takeProfiles(doc *yaml.Node) (map[string]*profile, error) {
+	profiles := make(map[string]*profile)
+	root := doc
+	if root.Kind == yaml.DocumentNode && len(root.Content) > 0 {
+		root = root.Content[0]
+	}
+	if root.Kind != yaml.MappingNode {
+		return profiles, nil
+	}
+	var block *yaml.Node
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		if root.Content[i].Value == profilesKey {
+			block = root.Content[i+1]
+			root.Content = slices.Delete(root.Content, i, i+2)
+			break
+		}
+	}
+	if block == nil || block.Tag == "!!null" {
+		return profiles, nil
+	}
+	if block.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("line %d: profiles must map profile names to settings", block.Line)
+	}
+	for i := 0; i+1 < len(block.Content); i += 2 {
+		name, value := block.Content[i].Value, block.Content[i+1]
+		if value.Kind == yaml.AliasNode {
+			value = value.Alias
+		}
+		p := &profile{name: name, node: &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map", Line: value.Line}}
+		switch {
+		case value.Tag == "!!null": // A profile that only selects the base configuration
+		case value.Kind != yaml.MappingNode:
+			return nil, fmt.Errorf("line %d: profile '%s' must be a block of settings", value.Line, name)
+		default:
+			for j := 0; j+1 < len(value.Content); j += 2 {
+				if value.Content[j].Value == inheritsKey {
+					p.inherits = value.Content[j+1].Value
+					continue
+				}
+				p.node.Content = append(p.node.Content, value.Content[j], value.Content[j+1])
+			}
+		}
+		profiles[name] = p
+	}
+	return profiles, nil
+}
+
+// profileChain returns the profiles applied for name in order, starting with the one
+// based on the base configuration and ending with name itself.
+func profileChain(profiles map[string]*profile, name string) ([]*profile, error) {
+	var chain []*profile
+	seen := make(map[string]bool)
+	for n := name; n != ""; {
+		p, ok := profiles[n]
+		if !ok {
+			if n == name {
+				return nil, fmt.Errorf("unknown profile '%s' (%s)", name, availableProfiles(profiles))
+			}
+			return nil, fmt.Errorf("profile '%s' inherits unknown profile '%s'", chain[0].name, n)
+		}
+		if seen[n] {
+			names := []string{n}
+			for _, c := range chain {
+				names = append(names, c.name)
+			}
+			slices.Reverse(names)
+			return nil, fmt.Errorf("profiles inherit from each other: %s", strings.Join(names, " -> "))
+		}
+		seen[n] = true
+		chain = append([]*profile{p}, chain...)
+		n = p.inherits
+	}
+	return chain, nil
+}
+
+// replacedPaths returns the paths in node whose values replace the ones decoded
+// before as a whole instead of being merged key by key: lists, map entries and plain
+// values. Their earlier sources no longer apply.
+func replacedPaths(node *yaml.Node, t reflect.Type, path string) []string {
+	if node.Kind == yaml.AliasNode {
+		node = node.Alias
+	}
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	var paths []string
+	switch {
+	case t.Kind() == reflect.Struct && node.Kind == yaml.MappingNode:
+		fields := yamlFields(t)
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key := node.Content[i].Value
+			if key == "<<" {
+				paths = append(paths, replacedPaths(node.Content[i+1], t, path)...)
+			} else if field, ok := fields[key]; ok {
+				paths = append(paths, replacedPaths(node.Content[i+1], field, joinKey(path, key))...)
+			}
+		}
+	case t.Kind() == reflect.Map && node.Kind == yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			paths = append(paths, joinKey(path, node.Content[i].Value))
+		}
+	default:
+		paths = append(paths, path)
+	}
+	return paths
+}
+
+// availableProfiles describes the profiles that can be selected, for error messages.
+func availableProfiles(profiles map[string]*profile) string {
+	if len(profiles) == 0 {
+		return "the config file defines no profiles"
+	}
+	return "available: " + strings.Join(profileNames(profiles), ", ")
+}
+
+// profileNames returns the names of the profiles in alphabetical order.
+func profileNames(profiles map[string]*profile) []string {
+	names := make([]string, 0, len(profiles))
+	for name := range profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
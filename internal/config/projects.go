@@ -0,0 +1,76 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ProjectConfig holds the docker compose settings of one project, an entry of the
+// 'projects' map. Keys are project names or paths relative to the compose root.
+// The settings are passed to every docker compose call for the project.
+type ProjectConfig struct {
+	ComposeFiles []string     `yaml:"compose_files"` // Like docker compose -f; relative to the project directory
+	Profiles     []string     `yaml:"profiles"`      // Like --profile; "*" enables all profiles
+	EnvFile      StringOrList `yaml:"env_file"`      // Like --env-file; one file or a list
+	ProjectName  string       `yaml:"project_name"`  // Like --project-name; also names the archives
+}
+
+// StringOrList is a list of strings that may be written as a single string in YAML.
+type StringOrList []string
+
+func (l *StringOrList) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		*l = []string{value.Value}
+		return nil
+	}
+	var list []string
+	if err := value.Decode(&list); err != nil {
+		return fmt.Errorf("expected a string or a list of strings: %w", err)
+	}
+	*l = list
+	return nil
+}
+
+// mergeComposeFiles adds the compose_files shorthand to the project settings; a
+// compose_files list in the projects section takes precedence.
+func mergeComposeFiles(projects map[string]ProjectConfig, composeFiles map[string][]string) map[string]ProjectConfig {
+	if len(composeFiles) == 0 {
+		return projects
+	}
+	merged := make(map[string]ProjectConfig, len(projects)+len(composeFiles))
+	for key, p := range projects {
+		merged[key] = p
+	}
+	for key, files := range composeFiles {
+		p := merged[key]
+		if len(p.ComposeFiles) == 0 {
+			p.ComposeFiles = files
+		}
+		merged[key] = p
+	}
+	return merged
+}
+
+// projectNamePattern is what docker compose accepts as a project name.
+var projectNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// validateProjects checks the project settings.
+func validateProjects(projects map[string]ProjectConfig) error {
+	for key, p := range projects {
+		if strings.TrimSpace(key) == "" {
+			return fmt.Errorf("invalid projects entry: the key must be a project name or path")
+		}
+		if p.ProjectName != "" && !projectNamePattern.MatchString(p.ProjectName) {
+			return fmt.Errorf("invalid projects.%s.project_name '%s': must contain only lowercase letters, digits, '-' and '_', starting with a letter or digit", key, p.ProjectName)
+		}
+		for _, profile := range p.Profiles {
+			if strings.TrimSpace(profile) == "" {
+				return fmt.Errorf("invalid projects.%s.profiles: profile names must not be empty", key)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,165 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// SourceDefault is the source of settings that were not set anywhere, see Config.Sources.
+const SourceDefault = "default"
+
+// flagKeys maps the command line flags to the YAML keys they set.
+var flagKeys = map[string]string{
+	"compose-dir":       "compose_dir",
+	"discovery":         "discovery_mode",
+	"discovery-depth":   "discovery_depth",
+	"appdata-dir":       "appdata_dir",
+	"backup-dir":        "backup_dir",
+	"restart":           "restart_after_backup",
+	"pull":              "pull_before_restart",
+	"verbose":           "verbose",
+	"v":                 "verbose",
+	"dry-run":           "dry_run",
+	"log-file":          "log_file",
+	"log-format":        "log_format",
+	"project":           "include_projects",
+	"skip":              "exclude_projects",
+	"log-level":         "log_level",
+	"console-log-level": "console_log_level",
+	"file-log-level":    "file_log_level",
+	"quiet":             "quiet",
+	"q":                 "quiet",
+	"rsync-enabled":     "rsync.enabled",
+	"rsync-dest":        "rsync.destination",
+	"rsync-opts":        "rsync.options",
+	"rsync-cmd":         "rsync.command",
+	"split-size":        "split_size",
+	"compression":       "compression.method",
+	"identity-file":     "encryption.identity_file",
+	"mode":              "mode",
+	"output":            "output",
+	"incremental":       "incremental.enabled",
+}
+
+// secretKeys are settings whose values are masked when the configuration is printed.
+var secretKeys = map[string]bool{"password": true, "secret_key": true, "token": true, "passphrase": true}
+
+// fileKeys returns the values set in a config file with their line numbers, keyed
+// by the path used in Config.Sources ("rsync.retries", "destinations[0].name").
+// Lists of plain values are a single value; blocks are not recorded themselves so
+// the keys left out of them show up as defaults.
+func fileKeys(doc *yaml.Node) map[string]int {
+	keys := make(map[string]int)
+	var walk func(node *yaml.Node, path string, line int)
+	walk = func(node *yaml.Node, path string, line int) {
+		switch node.Kind {
+		case yaml.DocumentNode:
+			for _, n := range node.Content {
+				walk(n, path, line)
+			}
+		case yaml.AliasNode:
+			walk(node.Alias, path, line)
+		case yaml.MappingNode:
+			if len(node.Content) == 0 {
+				keys[path] = line
+			}
+			for i := 0; i+1 < len(node.Content); i += 2 {
+				key := node.Content[i]
+				if key.Value == "<<" {
+					walk(node.Content[i+1], path, key.Line)
+					continue
+				}
+				walk(node.Content[i+1], joinKey(path, key.Value), key.Line)
+			}
+		case yaml.SequenceNode:
+			if len(node.Content) == 0 || node.Content[0].Kind != yaml.MappingNode {
+				keys[path] = line
+				return
+			}
+			for i, item := range node.Content {
+				walk(item, fmt.Sprintf("%s[%d]", path, i), item.Line)
+			}
+		default:
+			if node.Tag != "!!null" { // An empty block such as "rsync:" with every key commented out
+				keys[path] = line
+			}
+		}
+	}
+	walk(doc, "", 0)
+	return keys
+}
+
+// Setting is one value of the effective configuration.
+type Setting struct {
+	Key    string `json:"key"`    // e.g. "rsync.retries" or "destinations[0].name"
+	Value  string `json:"value"`  // YAML representation; secrets are masked
+	Source string `json:"source"` // See Config.Sources
+}
+
+// Settings returns every value of the effective configuration in the order of the
+// config file structure, with the source it came from.
+func (c Config) Settings() ([]Setting, error) {
+	var doc yaml.Node
+	if err := doc.Encode(c.toYAML()); err != nil {
+		return nil, err
+	}
+	var settings []Setting
+	var walk func(node *yaml.Node, path string)
+	walk = func(node *yaml.Node, path string) {
+		switch {
+		case node.Kind == yaml.MappingNode && len(node.Content) > 0:
+			for i := 0; i+1 < len(node.Content); i += 2 {
+				walk(node.Content[i+1], joinKey(path, node.Content[i].Value))
+			}
+			return
+		case node.Kind == yaml.SequenceNode && len(node.Content) > 0 && node.Content[0].Kind == yaml.MappingNode:
+			for i, item := range node.Content {
+				walk(item, fmt.Sprintf("%s[%d]", path, i))
+			}
+			return
+		}
+		settings = append(settings, Setting{Key: path, Value: c.formatValue(path, node), Source: c.source(path)})
+	}
+	walk(&doc, "")
+	return settings, nil
+}
+
+// formatValue renders a leaf node on a single line. Secrets are masked: secretKeys,
+// HTTP headers of notification providers and the path of their URLs, which holds the
+// token of Slack or Discord webhooks.
+func (c Config) formatValue(path string, node *yaml.Node) string {
+	name := path[strings.LastIndex(path, ".")+1:]
+	if node.Value != "" && (secretKeys[name] || strings.Contains(path, ".headers.")) {
+		return "********"
+	}
+	if name == "url" && strings.HasPrefix(path, "notify.") {
+		if u, err := url.Parse(node.Value); err == nil && u.Host != "" && (u.Path != "" || u.RawQuery != "" || u.User != nil) {
+			return u.Scheme + "://" + u.Host + "/********"
+		}
+	}
+	node.Style = yaml.FlowStyle
+	out, err := yaml.Marshal(node)
+	if err != nil {
+		return node.Value
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// source returns where the value at path came from: the source recorded for the path
+// or the closest enclosing key, or SourceDefault.
+func (c Config) source(path string) string {
+	for p := path; p != ""; {
+		if src, ok := c.Sources[p]; ok {
+			return src
+		}
+		i := strings.LastIndexAny(p, ".[")
+		if i < 0 {
+			break
+		}
+		p = p[:i]
+	}
+	return SourceDefault
+}
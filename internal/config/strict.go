@@ -0,0 +1,194 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// renamedKeys are keys that are easily mistaken for a supported one, mostly from
+// older examples and other backup tools. Paths are relative to the enclosing block.
+var renamedKeys = map[string]string{
+	"restart_stacks": "restart_after_backup",
+	"restart":        "restart_after_backup",
+	"pull_images":    "pull_before_restart",
+	"pull":           "pull_before_restart",
+	"exclude":        "exclude_patterns",
+	"excludes":       "exclude_patterns",
+	"enable":         "enabled",
+	"compose_file":   "compose_files",
+	"env_files":      "env_file",
+}
+
+// unknownKeys returns a message for every mapping key in the document that does not
+// correspond to a field of t, such as typos, misplaced keys or keys of a newer
+// version. yaml.v3's KnownFields does not reach into types with their own
+// UnmarshalYAML (destinations), so the node tree is compared with the types instead.
+func unknownKeys(doc *yaml.Node, t reflect.Type) []string {
+	var problems []string
+	walkKeys(doc, t, "", &problems)
+	return problems
+}
+
+// decodeProblems reports the values of a config file (or of one of its profiles) that
+// have the wrong type, e.g. "retries: often". yaml.v3 decodes everything else before
+// returning them. It reports whether err was such an error.
+func decodeProblems(err error, file, profile string, report func(error)) bool {
+	var typeErr *yaml.TypeError
+	if !errors.As(err, &typeErr) {
+		return false
+	}
+	for _, msg := range typeErr.Errors {
+		msg = file + ":" + strings.TrimPrefix(msg, "line ")
+		if profile != "" {
+			msg += fmt.Sprintf(" (profile %s)", profile)
+		}
+		report(errors.New(msg))
+	}
+	return true
+}
+
+func walkKeys(node *yaml.Node, t reflect.Type, path string, problems *[]string) {
+	if node == nil {
+		return
+	}
+	if node.Kind == yaml.DocumentNode {
+		for _, n := range node.Content {
+			walkKeys(n, t, path, problems)
+		}
+		return
+	}
+	if node.Kind == yaml.AliasNode {
+		walkKeys(node.Alias, t, path, problems)
+		return
+	}
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		if node.Kind != yaml.MappingNode {
+			return // Type errors are reported by the decoder
+		}
+		fields := yamlFields(t)
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			if key.Value == "<<" {
+				walkKeys(value, t, path, problems) // Merge key
+				continue
+			}
+			field, ok := fields[key.Value]
+			if !ok {
+				*problems = append(*problems, unknownKeyMessage(key, path, fields))
+				continue
+			}
+			walkKeys(value, field, joinKey(path, key.Value), problems)
+		}
+	case reflect.Map:
+		if node.Kind != yaml.MappingNode {
+			return
+		}
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			walkKeys(node.Content[i+1], t.Elem(), joinKey(path, node.Content[i].Value), problems)
+		}
+	case reflect.Slice, reflect.Array:
+		if node.Kind != yaml.SequenceNode {
+			return
+		}
+		for i, item := range node.Content {
+			walkKeys(item, t.Elem(), fmt.Sprintf("%s[%d]", path, i), problems)
+		}
+	}
+}
+
+// yamlFields maps the YAML keys of a struct to the field types, including inlined structs.
+func yamlFields(t reflect.Type) map[string]reflect.Type {
+	fields := make(map[string]reflect.Type)
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		tag := f.Tag.Get("yaml")
+		name, opts, _ := strings.Cut(tag, ",")
+		if name == "-" {
+			continue
+		}
+		if strings.Contains(opts, "inline") {
+			for k, v := range yamlFields(f.Type) {
+				fields[k] = v
+			}
+			continue
+		}
+		if name == "" {
+			name = strings.ToLower(f.Name)
+		}
+		fields[name] = f.Type
+	}
+	return fields
+}
+
+// unknownKeyMessage explains an unknown key and suggests the key that was probably meant.
+// path is the key of the enclosing block.
+func unknownKeyMessage(key *yaml.Node, path string, fields map[string]reflect.Type) string {
+	msg := fmt.Sprintf("line %d: unknown key '%s'", key.Line, joinKey(path, key.Value))
+	if renamed, ok := renamedKeys[key.Value]; ok {
+		if _, exists := fields[renamed]; exists {
+			return msg + fmt.Sprintf(" (did you mean '%s'?)", joinKey(path, renamed))
+		}
+	}
+	if suggestion := closestKey(key.Value, fields); suggestion != "" {
+		return msg + fmt.Sprintf(" (did you mean '%s'?)", joinKey(path, suggestion))
+	}
+	return msg
+}
+
+// closestKey returns the known key with the smallest edit distance to key, if it is
+// close enough to be a typo.
+func closestKey(key string, fields map[string]reflect.Type) string {
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names) // Deterministic choice between equally close keys
+	best, bestDist := "", len(key)/3+1
+	for _, name := range names {
+		if d := editDistance(key, name); d < bestDist {
+			best, bestDist = name, d
+		}
+	}
+	return best
+}
+
+// editDistance is the Levenshtein distance between a and b.
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+func joinKey(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
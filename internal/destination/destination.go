@@ -0,0 +1,136 @@
+package destination
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"docker-backup-tool/internal/config"
+)
+
+// ErrNotFound is returned by Stat and Download when the object does not exist.
+var ErrNotFound = errors.New("object not found")
+
+// ErrNotSupported is returned by operations a destination cannot perform.
+var ErrNotSupported = errors.New("operation not supported by this destination")
+
+// Object describes a file stored at a destination. Names are flat; every
+// destination stores archives in a single directory, bucket prefix or collection.
+type Object struct {
+	Name    string
+	Size    int64
+	ModTime time.Time
+}
+
+// TransferStats describes a completed upload.
+type TransferStats struct {
+	Files    int
+	Bytes    int64
+	Duration time.Duration
+	Attempts int // Number of tries needed, 0 for destinations that do not retry
+}
+
+// Destination is a place archives are copied to after they have been created.
+type Destination interface {
+	// Name identifies the destination in logs.
+	Name() string
+	// Upload stores the local file under its base name.
+	Upload(ctx context.Context, localPath string) (TransferStats, error)
+	// List returns every object at the destination.
+	List(ctx context.Context) ([]Object, error)
+	// Stat returns a single object or ErrNotFound.
+	Stat(ctx context.Context, name string) (Object, error)
+	// Delete removes an object.
+	Delete(ctx context.Context, name string) error
+	// Download copies an object to a local file.
+	Download(ctx context.Context, name, localPath string) error
+}
+
+// Target is a configured destination together with its retention policy.
+type Target struct {
+	Destination Destination
+	Retention   config.RetentionConfig
+}
+
+// New creates the destination described by dc.
+func New(dc config.DestinationConfig) (Destination, error) {
+	switch dc.Type {
+	case config.DestinationRsync:
+		return NewRsync(dc.Name, dc.Rsync)
+	case config.DestinationLocal:
+		return NewLocal(dc.Name, dc.Local)
+	case config.DestinationSFTP:
+		return NewSFTP(dc.Name, dc.SFTP)
+	case config.DestinationWebDAV:
+		return NewWebDAV(dc.Name, dc.WebDAV)
+	case config.DestinationS3:
+		return NewS3(dc.Name, dc.S3)
+	default:
+		return nil, fmt.Errorf("destination '%s': unknown type '%s'", dc.Name, dc.Type)
+	}
+}
+
+// FromConfig returns every configured destination: the top-level rsync and s3
+// blocks (when enabled) followed by the entries of the destinations list.
+func FromConfig(cfg config.Config) ([]Target, error) {
+	var targets []Target
+	if cfg.Rsync.Enabled {
+		if cfg.Rsync.Destination == "" {
+			return nil, errors.New("rsync is enabled but rsync.destination is not set")
+		}
+		d, err := NewRsync("rsync", cfg.Rsync)
+		if err != nil {
+			return nil, err
+		}
+		targets = append(targets, Target{Destination: d, Retention: cfg.Rsync.Retention})
+	}
+	if cfg.S3.Enabled {
+		d, err := NewS3("s3", cfg.S3.S3DestinationConfig)
+		if err != nil {
+			return nil, err
+		}
+		targets = append(targets, Target{Destination: d, Retention: cfg.S3.Retention})
+	}
+	for _, dc := range cfg.Destinations {
+		d, err := New(dc)
+		if err != nil {
+			return nil, err
+		}
+		targets = append(targets, Target{Destination: d, Retention: dc.Retention})
+	}
+	return targets, nil
+}
+
+// UploadAll uploads several files, e.g. all parts of a split archive, and sums up the stats.
+// Directories can only be uploaded by destinations that implement DirectoryUploader.
+func UploadAll(ctx context.Context, d Destination, localPaths []string) (TransferStats, error) {
+	var total TransferStats
+	start := time.Now()
+	for _, path := range localPaths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return total, err
+		}
+		if info.IsDir() {
+			if _, ok := d.(DirectoryUploader); !ok {
+				return total, fmt.Errorf("destination '%s' cannot upload directory '%s' (only rsync destinations transfer directories)", d.Name(), path)
+			}
+		}
+		stats, err := d.Upload(ctx, path)
+		if err != nil {
+			return total, fmt.Errorf("upload of '%s' to '%s' failed: %w", path, d.Name(), err)
+		}
+		total.Files += stats.Files
+		total.Bytes += stats.Bytes
+		total.Attempts += stats.Attempts
+	}
+	total.Duration = time.Since(start)
+	return total, nil
+}
+
+// DirectoryUploader is implemented by destinations whose Upload also accepts directories.
+type DirectoryUploader interface {
+	UploadsDirectories()
+}
@@ -0,0 +1,128 @@
+package destination
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"docker-backup-tool/internal/config"
+)
+
+// Local copies archives into a directory on a local or mounted filesystem (NAS share, USB drive).
+type Local struct {
+	name string
+	dir  string
+}
+
+// NewLocal creates a local directory destination.
+func NewLocal(name string, cfg config.LocalDestinationConfig) (*Local, error) {
+	if cfg.Path == "" {
+		return nil, fmt.Errorf("destination '%s': local.path is required", name)
+	}
+	return &Local{name: name, dir: cfg.Path}, nil
+}
+
+func (l *Local) Name() string { return l.name }
+
+func (l *Local) Upload(ctx context.Context, localPath string) (TransferStats, error) {
+	if err := os.MkdirAll(l.dir, 0755); err != nil {
+		return TransferStats{}, err
+	}
+	start := time.Now()
+	target := filepath.Join(l.dir, filepath.Base(localPath))
+	n, err := copyFileAtomic(ctx, localPath, target)
+	if err != nil {
+		return TransferStats{}, err
+	}
+	return TransferStats{Files: 1, Bytes: n, Duration: time.Since(start)}, nil
+}
+
+func (l *Local) List(ctx context.Context) ([]Object, error) {
+	entries, err := os.ReadDir(l.dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var objects []Object
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		objects = append(objects, Object{Name: e.Name(), Size: info.Size(), ModTime: info.ModTime()})
+	}
+	return objects, nil
+}
+
+func (l *Local) Stat(ctx context.Context, name string) (Object, error) {
+	info, err := os.Stat(filepath.Join(l.dir, name))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return Object{}, ErrNotFound
+		}
+		return Object{}, err
+	}
+	return Object{Name: name, Size: info.Size(), ModTime: info.ModTime()}, nil
+}
+
+func (l *Local) Delete(ctx context.Context, name string) error {
+	return os.Remove(filepath.Join(l.dir, name))
+}
+
+func (l *Local) Download(ctx context.Context, name, localPath string) error {
+	_, err := copyFileAtomic(ctx, filepath.Join(l.dir, name), localPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return ErrNotFound
+	}
+	return err
+}
+
+// copyFileAtomic copies src to a temporary file next to dst and renames it into
+// place, so an interrupted copy never leaves a truncated archive behind.
+func copyFileAtomic(ctx context.Context, src, dst string) (int64, error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return 0, err
+	}
+	defer in.Close()
+
+	tmp := dst + ".partial"
+	out, err := os.Create(tmp)
+	if err != nil {
+		return 0, err
+	}
+	n, err := io.Copy(out, &contextReader{ctx: ctx, r: in})
+	if err == nil {
+		err = out.Sync()
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return 0, err
+	}
+	return n, os.Rename(tmp, dst)
+}
+
+// contextReader stops a copy once the context is cancelled.
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (c *contextReader) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.r.Read(p)
+}
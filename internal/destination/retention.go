@@ -0,0 +1,114 @@
+package destination
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"docker-backup-tool/internal/config"
+)
+
+// archiveSet groups the objects that belong to one archive: the archive itself,
+// split parts, checksum lists and other companion files sharing its name.
+type archiveSet struct {
+	Key     string // Archive name up to and including ".zip"
+	Objects []Object
+	ModTime time.Time // Newest modification time in the set
+	Full    bool      // False for incremental archives, which depend on older archives
+}
+
+// archivePattern matches archives of a project: <project>_YYYYMMDD[-HHMMSS_full|_incr].zip...
+func archivePattern(project string) *regexp.Regexp {
+	return regexp.MustCompile(`^` + regexp.QuoteMeta(project) + `_\d{8}(-\d{6}_(full|incr))?\.zip`)
+}
+
+// groupArchives collects the archive sets of a project, oldest first.
+func groupArchives(objects []Object, project string) []*archiveSet {
+	pattern := archivePattern(project)
+	sets := make(map[string]*archiveSet)
+	for _, o := range objects {
+		key := pattern.FindString(o.Name)
+		if key == "" {
+			continue
+		}
+		set, ok := sets[key]
+		if !ok {
+			set = &archiveSet{Key: key, Full: !strings.HasSuffix(key, "_incr.zip")}
+			sets[key] = set
+		}
+		set.Objects = append(set.Objects, o)
+		if o.ModTime.After(set.ModTime) {
+			set.ModTime = o.ModTime
+		}
+	}
+
+	ordered := make([]*archiveSet, 0, len(sets))
+	for _, set := range sets {
+		ordered = append(ordered, set)
+	}
+	// Archive names embed their date, which is more reliable than remote modification times
+	sort.Slice(ordered, func(i, j int) bool { return ordered[i].Key < ordered[j].Key })
+	return ordered
+}
+
+// expiredArchives returns the archive sets the policy does not keep. Incremental
+// archives that are kept also keep every older archive back to their full backup,
+// so a kept chain can always be restored.
+func expiredArchives(sets []*archiveSet, policy config.RetentionConfig, now time.Time) []*archiveSet {
+	if policy.KeepLast <= 0 && policy.KeepDays <= 0 {
+		return nil
+	}
+	cutoff := now.AddDate(0, 0, -policy.KeepDays)
+	keep := make([]bool, len(sets))
+	for i, set := range sets {
+		newer := len(sets) - 1 - i
+		keep[i] = (policy.KeepLast > 0 && newer < policy.KeepLast) || (policy.KeepDays > 0 && set.ModTime.After(cutoff))
+	}
+	for i := len(sets) - 1; i >= 0; i-- {
+		if !keep[i] || sets[i].Full {
+			continue
+		}
+		for j := i - 1; j >= 0; j-- {
+			keep[j] = true
+			if sets[j].Full {
+				break
+			}
+		}
+	}
+
+	var expired []*archiveSet
+	for i, set := range sets {
+		if !keep[i] {
+			expired = append(expired, set)
+		}
+	}
+	return expired
+}
+
+// ApplyRetention deletes the archives of project at d that are outside the policy.
+// It returns the names of the deleted (or, in dry run mode, deletable) archives.
+func ApplyRetention(ctx context.Context, d Destination, project string, policy config.RetentionConfig, dryRun bool) ([]string, error) {
+	if policy.KeepLast <= 0 && policy.KeepDays <= 0 {
+		return nil, nil
+	}
+	objects, err := d.List(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list '%s' for retention: %w", d.Name(), err)
+	}
+
+	var removed []string
+	for _, set := range expiredArchives(groupArchives(objects, project), policy, time.Now()) {
+		if !dryRun {
+			for _, o := range set.Objects {
+				if err := d.Delete(ctx, o.Name); err != nil {
+					return removed, fmt.Errorf("failed to delete '%s' from '%s': %w", o.Name, d.Name(), err)
+				}
+			}
+		}
+		removed = append(removed, set.Key)
+	}
+	return removed, nil
+}
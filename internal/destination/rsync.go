@@ -0,0 +1,113 @@
+package destination
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"docker-backup-tool/internal/config"
+	"docker-backup-tool/internal/rsync"
+)
+
+// Rsync transfers archives with the external rsync command.
+type Rsync struct {
+	name string
+	cfg  config.RsyncConfig
+}
+
+// NewRsync creates an rsync destination.
+func NewRsync(name string, cfg config.RsyncConfig) (*Rsync, error) {
+	if cfg.Destination == "" {
+		return nil, fmt.Errorf("destination '%s': rsync.destination is required", name)
+	}
+	if cfg.Command == "" {
+		cfg.Command = "rsync"
+	}
+	return &Rsync{name: name, cfg: cfg}, nil
+}
+
+func (r *Rsync) Name() string { return r.name }
+
+// UploadsDirectories marks rsync as able to transfer whole directories (repository output).
+func (r *Rsync) UploadsDirectories() {}
+
+// Command returns the rsync executable used by this destination.
+func (r *Rsync) Command() string { return r.cfg.Command }
+
+// Config returns the rsync settings of this destination, used by mirror mode.
+func (r *Rsync) Config() config.RsyncConfig { return r.cfg }
+
+func (r *Rsync) Upload(ctx context.Context, localPath string) (TransferStats, error) {
+	if _, err := exec.LookPath(r.cfg.Command); err != nil {
+		return TransferStats{}, fmt.Errorf("rsync command '%s' not found: %w", r.cfg.Command, err)
+	}
+	if _, err := os.Stat(localPath); err != nil {
+		return TransferStats{}, err
+	}
+	stats, err := rsync.Transfer(ctx, r.cfg, r.cfg.Destination, localPath)
+	if err != nil {
+		return TransferStats{}, err
+	}
+	return TransferStats{Files: stats.Files, Bytes: stats.Bytes, Duration: stats.Duration, Attempts: stats.Attempts}, nil
+}
+
+// List runs rsync --list-only on the destination directory.
+func (r *Rsync) List(ctx context.Context) ([]Object, error) {
+	entries, err := rsync.List(ctx, r.cfg, r.cfg.Destination)
+	if err != nil {
+		return nil, err
+	}
+	var objects []Object
+	for _, e := range entries {
+		if !e.Dir {
+			objects = append(objects, Object{Name: e.Name, Size: e.Size, ModTime: e.ModTime})
+		}
+	}
+	return objects, nil
+}
+
+func (r *Rsync) Stat(ctx context.Context, name string) (Object, error) {
+	objects, err := r.List(ctx)
+	if err != nil {
+		return Object{}, err
+	}
+	for _, o := range objects {
+		if o.Name == name {
+			return o, nil
+		}
+	}
+	return Object{}, ErrNotFound
+}
+
+func (r *Rsync) Delete(ctx context.Context, name string) error {
+	return rsync.Delete(ctx, r.cfg, r.cfg.Destination, name)
+}
+
+// SyncsBackupDir reports whether the whole backup directory is synced in one call
+// at the end of the run instead of uploading each archive.
+func (r *Rsync) SyncsBackupDir() bool { return r.cfg.SyncBackupDir }
+
+// SyncDir makes the destination directory a copy of dir. Local metadata
+// (the incremental index, catalog, notification and metrics state) and unfinished files are left out.
+func (r *Rsync) SyncDir(ctx context.Context, dir string) (TransferStats, error) {
+	if _, err := exec.LookPath(r.cfg.Command); err != nil {
+		return TransferStats{}, fmt.Errorf("rsync command '%s' not found: %w", r.cfg.Command, err)
+	}
+	extra := []string{"--recursive", "--delete", "--exclude=/.index/", "--exclude=/.notify-state.json", "--exclude=/.metrics-state.json", "--exclude=/catalog.db", "--exclude=*.partial"}
+	stats, err := rsync.TransferWith(ctx, r.cfg, extra, r.cfg.Destination, strings.TrimSuffix(dir, "/")+"/")
+	if err != nil {
+		return TransferStats{}, err
+	}
+	return TransferStats{Files: stats.Files, Bytes: stats.Bytes, Duration: stats.Duration, Attempts: stats.Attempts}, nil
+}
+
+func (r *Rsync) Download(ctx context.Context, name, localPath string) error {
+	if err := os.MkdirAll(filepath.Dir(localPath), 0755); err != nil {
+		return err
+	}
+	_, err := rsync.Transfer(ctx, r.cfg, localPath, rsync.RemotePath(r.cfg.Destination, name))
+	return err
+}
@@ -0,0 +1,386 @@
+package destination
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"docker-backup-tool/internal/config"
+	"docker-backup-tool/internal/util"
+)
+
+// emptyPayloadHash is the SHA-256 of an empty request body.
+const emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// S3 uploads archives to S3-compatible object storage (AWS, MinIO, Backblaze B2,
+// Wasabi, ...). Requests are signed with AWS Signature Version 4.
+type S3 struct {
+	name     string
+	cfg      config.S3DestinationConfig
+	endpoint *url.URL
+	partSize int64
+	client   *http.Client
+}
+
+// NewS3 creates an S3 destination.
+func NewS3(name string, cfg config.S3DestinationConfig) (*S3, error) {
+	if cfg.Bucket == "" {
+		return nil, fmt.Errorf("destination '%s': s3.bucket is required", name)
+	}
+	if cfg.AccessKey == "" || cfg.SecretKey == "" {
+		return nil, fmt.Errorf("destination '%s': s3.access_key and s3.secret_key are required", name)
+	}
+	if cfg.Region == "" {
+		cfg.Region = "us-east-1"
+	}
+	if cfg.Endpoint == "" {
+		cfg.Endpoint = "https://s3." + cfg.Region + ".amazonaws.com"
+	}
+	endpoint, err := url.Parse(cfg.Endpoint)
+	if err != nil || endpoint.Host == "" {
+		return nil, fmt.Errorf("destination '%s': invalid s3.endpoint '%s'", name, cfg.Endpoint)
+	}
+	partSize, err := util.ParseSize(cfg.PartSize)
+	if err != nil {
+		return nil, fmt.Errorf("destination '%s': invalid s3.part_size: %w", name, err)
+	}
+	if partSize == 0 {
+		partSize = defaultPartSize
+	}
+	if partSize < minPartSize {
+		return nil, fmt.Errorf("destination '%s': s3.part_size must be at least %s", name, util.FormatBytes(minPartSize))
+	}
+	if cfg.Checksum == "" {
+		cfg.Checksum = config.ChecksumSHA256
+	}
+	return &S3{name: name, cfg: cfg, endpoint: endpoint, partSize: partSize, client: &http.Client{}}, nil
+}
+
+func (s *S3) Name() string { return s.name }
+
+// objectURL returns the URL of a key, or of the bucket when key is empty. Path and
+// query are encoded the way they are signed, see uriEncode.
+func (s *S3) objectURL(key string, query url.Values) *url.URL {
+	u := *s.endpoint
+	if s.cfg.PathStyle {
+		u.Path = "/" + s.cfg.Bucket + "/" + key
+	} else {
+		u.Host = s.cfg.Bucket + "." + u.Host
+		u.Path = "/" + key
+	}
+	u.RawPath = uriEncode(u.Path, false)
+	u.RawQuery = canonicalQuery(query)
+	return &u
+}
+
+// uriEncode percent-encodes s like the UriEncode function of Signature Version 4:
+// everything except unreserved characters (A-Z, a-z, 0-9, '-', '.', '_', '~') is
+// encoded, spaces as %20, and '/' only with encodeSlash. Go's URL escaping keeps
+// characters such as '(' or '!' and the form encoding writes spaces as '+', which
+// S3 would sign differently.
+func uriEncode(s string, encodeSlash bool) string {
+	const hexDigits = "0123456789ABCDEF"
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9', c == '-', c == '.', c == '_', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			b.WriteByte('%')
+			b.WriteByte(hexDigits[c>>4])
+			b.WriteByte(hexDigits[c&15])
+		}
+	}
+	return b.String()
+}
+
+// canonicalQuery encodes the query with uriEncode, sorted by name and value.
+func canonicalQuery(query url.Values) string {
+	var pairs []string
+	for name, values := range query {
+		for _, v := range values {
+			pairs = append(pairs, uriEncode(name, true)+"="+uriEncode(v, true))
+		}
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, "&")
+}
+
+func (s *S3) key(name string) string {
+	return s.cfg.Prefix + name
+}
+
+// do sends a signed request. payloadHash is the hex SHA-256 of the body.
+func (s *S3) do(ctx context.Context, method string, u *url.URL, body io.Reader, size int64, payloadHash string, headers ...http.Header) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.ContentLength = size
+	}
+	for _, h := range headers {
+		for k, v := range h {
+			req.Header[k] = v
+		}
+	}
+	s.sign(req, payloadHash, time.Now().UTC())
+	return s.client.Do(req)
+}
+
+// sign adds the AWS Signature Version 4 authorization header to req.
+func (s *S3) sign(req *http.Request, payloadHash string, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+	req.Header.Set("Host", req.URL.Host)
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	var names []string
+	for name := range req.Header {
+		names = append(names, strings.ToLower(name))
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		fmt.Fprintf(&canonicalHeaders, "%s:%s\n", name, strings.TrimSpace(req.Header.Get(name)))
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		uriEncode(req.URL.Path, false),
+		canonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := day + "/" + s.cfg.Region + "/s3/aws4_request"
+	stringToSign := strings.Join([]string{"AWS4-HMAC-SHA256", amzDate, scope, sha256Hex([]byte(canonicalRequest))}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+s.cfg.SecretKey), day)
+	key = hmacSHA256(key, s.cfg.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.cfg.AccessKey, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// s3Error is the XML error document returned by S3.
+type s3Error struct {
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+// responseError turns an unsuccessful response into an error, including the S3 error code if present.
+func responseError(resp *http.Response, action string) error {
+	if resp.StatusCode == http.StatusNotFound {
+		return ErrNotFound
+	}
+	var e s3Error
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if xml.Unmarshal(data, &e) == nil && e.Code != "" {
+		return fmt.Errorf("%s: %s: %s (%s)", action, resp.Status, e.Message, e.Code)
+	}
+	return fmt.Errorf("%s: %s", action, resp.Status)
+}
+
+// Upload stores a file with a single PUT, or as a multipart upload when it is
+// larger than the part size. Interrupted multipart uploads are resumed on the next
+// attempt. The object size is checked with HEAD afterwards.
+func (s *S3) Upload(ctx context.Context, localPath string) (TransferStats, error) {
+	start := time.Now()
+	f, err := os.Open(localPath)
+	if err != nil {
+		return TransferStats{}, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return TransferStats{}, err
+	}
+
+	name := filepath.Base(localPath)
+	if info.Size() > s.partSize {
+		err = s.uploadMultipart(ctx, f, info.Size(), name)
+	} else {
+		err = s.putObject(ctx, f, info.Size(), name)
+	}
+	if err != nil {
+		return TransferStats{}, err
+	}
+
+	obj, err := s.Stat(ctx, name)
+	if err != nil {
+		return TransferStats{}, fmt.Errorf("failed to verify uploaded object: %w", err)
+	}
+	if obj.Size != info.Size() {
+		return TransferStats{}, fmt.Errorf("uploaded object '%s' has %d bytes, expected %d", name, obj.Size, info.Size())
+	}
+	return TransferStats{Files: 1, Bytes: info.Size(), Duration: time.Since(start)}, nil
+}
+
+// putObject uploads a small file in one request.
+func (s *S3) putObject(ctx context.Context, f *os.File, size int64, name string) error {
+	data := make([]byte, size)
+	if _, err := io.ReadFull(f, data); err != nil {
+		return err
+	}
+	headers := s.objectHeaders()
+	sums := s.payloadHeaders(data)
+	resp, err := s.do(ctx, http.MethodPut, s.objectURL(s.key(name), nil), bytes.NewReader(data), size, sha256Hex(data), headers, sums)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return responseError(resp, "PUT "+name)
+	}
+	return nil
+}
+
+// objectHeaders returns the headers applied when an object is created: storage class and object lock.
+func (s *S3) objectHeaders() http.Header {
+	h := http.Header{"Content-Type": {"application/octet-stream"}}
+	if s.cfg.StorageClass != "" {
+		h.Set("X-Amz-Storage-Class", s.cfg.StorageClass)
+	}
+	if s.cfg.ObjectLock.Mode != "" {
+		until := time.Now().UTC().AddDate(0, 0, s.cfg.ObjectLock.Days)
+		h.Set("X-Amz-Object-Lock-Mode", s.cfg.ObjectLock.Mode)
+		h.Set("X-Amz-Object-Lock-Retain-Until-Date", until.Format(time.RFC3339))
+	}
+	return h
+}
+
+// payloadHeaders returns the checksum headers the server verifies the body against.
+// Content-MD5 is understood by every S3 implementation and required for object lock.
+func (s *S3) payloadHeaders(data []byte) http.Header {
+	md5Sum := md5.Sum(data)
+	h := http.Header{"Content-Md5": {base64.StdEncoding.EncodeToString(md5Sum[:])}}
+	if s.cfg.Checksum == config.ChecksumSHA256 {
+		h.Set("X-Amz-Checksum-Sha256", checksumSHA256(data))
+	}
+	return h
+}
+
+func checksumSHA256(data []byte) string {
+	sum := sha256.Sum256(data)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+type listBucketResult struct {
+	Contents []struct {
+		Key          string    `xml:"Key"`
+		Size         int64     `xml:"Size"`
+		LastModified time.Time `xml:"LastModified"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+func (s *S3) List(ctx context.Context) ([]Object, error) {
+	var objects []Object
+	token := ""
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {s.cfg.Prefix}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		resp, err := s.do(ctx, http.MethodGet, s.objectURL("", query), nil, 0, emptyPayloadHash)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode >= 300 {
+			err := responseError(resp, "list bucket "+s.cfg.Bucket)
+			resp.Body.Close()
+			return nil, err
+		}
+		var result listBucketResult
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse bucket listing: %w", err)
+		}
+		for _, c := range result.Contents {
+			name := strings.TrimPrefix(c.Key, s.cfg.Prefix)
+			// Objects in "subdirectories" below the prefix are not ours
+			if name == "" || strings.Contains(name, "/") {
+				continue
+			}
+			objects = append(objects, Object{Name: name, Size: c.Size, ModTime: c.LastModified})
+		}
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return objects, nil
+		}
+		token = result.NextContinuationToken
+	}
+}
+
+func (s *S3) Stat(ctx context.Context, name string) (Object, error) {
+	resp, err := s.do(ctx, http.MethodHead, s.objectURL(s.key(name), nil), nil, 0, emptyPayloadHash)
+	if err != nil {
+		return Object{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return Object{}, responseError(resp, "HEAD "+name)
+	}
+	modTime, _ := http.ParseTime(resp.Header.Get("Last-Modified"))
+	return Object{Name: name, Size: resp.ContentLength, ModTime: modTime}, nil
+}
+
+func (s *S3) Delete(ctx context.Context, name string) error {
+	resp, err := s.do(ctx, http.MethodDelete, s.objectURL(s.key(name), nil), nil, 0, emptyPayloadHash)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 && resp.StatusCode != http.StatusNotFound {
+		return responseError(resp, "DELETE "+name)
+	}
+	return nil
+}
+
+func (s *S3) Download(ctx context.Context, name, localPath string) error {
+	resp, err := s.do(ctx, http.MethodGet, s.objectURL(s.key(name), nil), nil, 0, emptyPayloadHash)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return responseError(resp, "GET "+name)
+	}
+	return writeLocalFile(ctx, resp.Body, localPath)
+}
@@ -0,0 +1,317 @@
+package destination
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"docker-backup-tool/internal/config"
+	"docker-backup-tool/internal/logutil"
+)
+
+const (
+	defaultPartSize = 64 << 20
+	minPartSize     = 5 << 20 // S3 rejects smaller parts except the last one
+	maxParts        = 10000
+	partAttempts    = 3
+)
+
+// uploadedPart is a part the server already has.
+type uploadedPart struct {
+	Number    int
+	ETag      string
+	Size      int64
+	Checksum  string
+	Encrypted bool // Stored with SSE-KMS or SSE-C, so the ETag is not the MD5 of the data
+}
+
+// uploadMultipart uploads f in parts. An unfinished upload of the same key with
+// the same part size is resumed: parts whose ETag or SHA-256 checksum matches the
+// local data are skipped.
+func (s *S3) uploadMultipart(ctx context.Context, f *os.File, size int64, name string) error {
+	key := s.key(name)
+	partSize := s.partSize
+	if size/partSize >= maxParts {
+		// Grow the part size so the file fits into the part limit
+		partSize = size/(maxParts-1) + 1
+	}
+
+	uploadID, existing, err := s.resumableUpload(ctx, key, partSize)
+	if err != nil {
+		return err
+	}
+	if uploadID == "" {
+		if uploadID, err = s.createMultipartUpload(ctx, key); err != nil {
+			return err
+		}
+	} else {
+		logutil.Info("Resuming multipart upload of %s to '%s' (%d part(s) already uploaded)", name, s.name, len(existing))
+	}
+
+	var parts []uploadedPart
+	buf := make([]byte, partSize)
+	for number, offset := 1, int64(0); offset < size; number, offset = number+1, offset+partSize {
+		n, err := f.ReadAt(buf, offset)
+		if err != nil && err != io.EOF {
+			return err
+		}
+		data := buf[:n]
+		md5Sum := md5.Sum(data)
+		etag := hex.EncodeToString(md5Sum[:])
+
+		if p, ok := existing[number]; ok && p.Size == int64(n) && (p.ETag == etag || p.Checksum != "" && p.Checksum == checksumSHA256(data)) {
+			if p.Checksum == "" && s.cfg.Checksum == config.ChecksumSHA256 {
+				p.Checksum = checksumSHA256(data)
+			}
+			parts = append(parts, p)
+			continue
+		}
+		p, err := s.uploadPart(ctx, key, uploadID, number, data)
+		if err != nil {
+			// The upload is left in place so the next run can resume it
+			return fmt.Errorf("part %d of %s: %w", number, name, err)
+		}
+		if p.ETag != etag && !p.Encrypted {
+			return fmt.Errorf("part %d of %s: server returned ETag %s, expected %s", number, name, p.ETag, etag)
+		}
+		parts = append(parts, p)
+	}
+	return s.completeMultipartUpload(ctx, key, uploadID, parts)
+}
+
+type listMultipartUploadsResult struct {
+	Uploads []struct {
+		Key       string    `xml:"Key"`
+		UploadID  string    `xml:"UploadId"`
+		Initiated time.Time `xml:"Initiated"`
+	} `xml:"Upload"`
+}
+
+// resumableUpload looks for an unfinished multipart upload of key and returns its
+// ID and parts. Uploads started with a different part size are aborted, since their
+// parts cannot be matched to the local file.
+func (s *S3) resumableUpload(ctx context.Context, key string, partSize int64) (string, map[int]uploadedPart, error) {
+	query := url.Values{"uploads": {""}, "prefix": {key}}
+	resp, err := s.do(ctx, http.MethodGet, s.objectURL("", query), nil, 0, emptyPayloadHash)
+	if err != nil {
+		return "", nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return "", nil, responseError(resp, "list multipart uploads")
+	}
+	var result listMultipartUploadsResult
+	if err := xml.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", nil, fmt.Errorf("failed to parse multipart upload listing: %w", err)
+	}
+
+	uploads := result.Uploads[:0]
+	for _, u := range result.Uploads {
+		if u.Key == key {
+			uploads = append(uploads, u)
+		}
+	}
+	if len(uploads) == 0 {
+		return "", nil, nil
+	}
+	// Resume the newest upload, abort the others
+	sort.Slice(uploads, func(i, j int) bool { return uploads[i].Initiated.After(uploads[j].Initiated) })
+	for _, u := range uploads[1:] {
+		s.abortMultipartUpload(ctx, key, u.UploadID)
+	}
+
+	uploadID := uploads[0].UploadID
+	parts, err := s.listParts(ctx, key, uploadID)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return "", nil, nil
+		}
+		return "", nil, err
+	}
+	existing := make(map[int]uploadedPart)
+	for _, p := range parts {
+		if p.Size != partSize && p.Number == 1 {
+			logutil.Warn("Aborting unfinished upload of %s on '%s': it used a different part size", key, s.name)
+			s.abortMultipartUpload(ctx, key, uploadID)
+			return "", nil, nil
+		}
+		existing[p.Number] = p
+	}
+	return uploadID, existing, nil
+}
+
+type listPartsResult struct {
+	Parts []struct {
+		PartNumber     int    `xml:"PartNumber"`
+		ETag           string `xml:"ETag"`
+		Size           int64  `xml:"Size"`
+		ChecksumSHA256 string `xml:"ChecksumSHA256"`
+	} `xml:"Part"`
+	IsTruncated          bool `xml:"IsTruncated"`
+	NextPartNumberMarker int  `xml:"NextPartNumberMarker"`
+}
+
+func (s *S3) listParts(ctx context.Context, key, uploadID string) ([]uploadedPart, error) {
+	var parts []uploadedPart
+	marker := 0
+	for {
+		query := url.Values{"uploadId": {uploadID}}
+		if marker > 0 {
+			query.Set("part-number-marker", strconv.Itoa(marker))
+		}
+		resp, err := s.do(ctx, http.MethodGet, s.objectURL(key, query), nil, 0, emptyPayloadHash)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode >= 300 {
+			err := responseError(resp, "list parts")
+			resp.Body.Close()
+			return nil, err
+		}
+		var result listPartsResult
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse part listing: %w", err)
+		}
+		for _, p := range result.Parts {
+			parts = append(parts, uploadedPart{
+				Number:   p.PartNumber,
+				ETag:     strings.Trim(p.ETag, `"`),
+				Size:     p.Size,
+				Checksum: p.ChecksumSHA256,
+			})
+		}
+		if !result.IsTruncated || result.NextPartNumberMarker <= marker {
+			return parts, nil
+		}
+		marker = result.NextPartNumberMarker
+	}
+}
+
+func (s *S3) createMultipartUpload(ctx context.Context, key string) (string, error) {
+	headers := s.objectHeaders()
+	if s.cfg.Checksum == config.ChecksumSHA256 {
+		headers.Set("X-Amz-Checksum-Algorithm", "SHA256")
+	}
+	resp, err := s.do(ctx, http.MethodPost, s.objectURL(key, url.Values{"uploads": {""}}), nil, 0, emptyPayloadHash, headers)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return "", responseError(resp, "create multipart upload")
+	}
+	var result struct {
+		UploadID string `xml:"UploadId"`
+	}
+	if err := xml.NewDecoder(resp.Body).Decode(&result); err != nil || result.UploadID == "" {
+		return "", fmt.Errorf("invalid response to create multipart upload: %v", err)
+	}
+	return result.UploadID, nil
+}
+
+// uploadPart uploads one part, retrying transient failures.
+func (s *S3) uploadPart(ctx context.Context, key, uploadID string, number int, data []byte) (uploadedPart, error) {
+	query := url.Values{"partNumber": {strconv.Itoa(number)}, "uploadId": {uploadID}}
+	sums := s.payloadHeaders(data)
+	payloadHash := sha256Hex(data)
+
+	var lastErr error
+	for attempt := 1; attempt <= partAttempts; attempt++ {
+		if attempt > 1 {
+			select {
+			case <-ctx.Done():
+				return uploadedPart{}, ctx.Err()
+			case <-time.After(time.Duration(attempt*attempt) * time.Second):
+			}
+		}
+		resp, err := s.do(ctx, http.MethodPut, s.objectURL(key, query), bytes.NewReader(data), int64(len(data)), payloadHash, sums)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if resp.StatusCode >= 300 {
+			lastErr = responseError(resp, "upload part")
+			resp.Body.Close()
+			if resp.StatusCode < 500 {
+				return uploadedPart{}, lastErr
+			}
+			continue
+		}
+		resp.Body.Close()
+		// With SSE-KMS or SSE-C the ETag is not the MD5 of the part, so the checksum
+		// reported by the server is compared as well (Content-MD5 was checked on receipt)
+		checksum := sums.Get("X-Amz-Checksum-Sha256")
+		if got := resp.Header.Get("X-Amz-Checksum-Sha256"); checksum != "" && got != "" && got != checksum {
+			return uploadedPart{}, fmt.Errorf("server returned checksum %s, expected %s", got, checksum)
+		}
+		sse := resp.Header.Get("X-Amz-Server-Side-Encryption")
+		return uploadedPart{
+			Number:    number,
+			ETag:      strings.Trim(resp.Header.Get("ETag"), `"`),
+			Size:      int64(len(data)),
+			Checksum:  checksum,
+			Encrypted: strings.HasPrefix(sse, "aws:kms") || resp.Header.Get("X-Amz-Server-Side-Encryption-Customer-Algorithm") != "",
+		}, nil
+	}
+	return uploadedPart{}, lastErr
+}
+
+type completedPart struct {
+	PartNumber     int    `xml:"PartNumber"`
+	ETag           string `xml:"ETag"`
+	ChecksumSHA256 string `xml:"ChecksumSHA256,omitempty"`
+}
+
+type completeMultipartUpload struct {
+	XMLName xml.Name        `xml:"CompleteMultipartUpload"`
+	Parts   []completedPart `xml:"Part"`
+}
+
+func (s *S3) completeMultipartUpload(ctx context.Context, key, uploadID string, parts []uploadedPart) error {
+	body := completeMultipartUpload{}
+	for _, p := range parts {
+		body.Parts = append(body.Parts, completedPart{PartNumber: p.Number, ETag: `"` + p.ETag + `"`, ChecksumSHA256: p.Checksum})
+	}
+	data, err := xml.Marshal(body)
+	if err != nil {
+		return err
+	}
+	resp, err := s.do(ctx, http.MethodPost, s.objectURL(key, url.Values{"uploadId": {uploadID}}), bytes.NewReader(data), int64(len(data)), sha256Hex(data))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	// Errors can also arrive with status 200 once the server has started responding
+	respData, _ := io.ReadAll(resp.Body)
+	var e s3Error
+	if resp.StatusCode >= 300 || (xml.Unmarshal(respData, &e) == nil && e.Code != "") {
+		if e.Code != "" {
+			return fmt.Errorf("complete multipart upload: %s (%s)", e.Message, e.Code)
+		}
+		return fmt.Errorf("complete multipart upload: %s", resp.Status)
+	}
+	return nil
+}
+
+func (s *S3) abortMultipartUpload(ctx context.Context, key, uploadID string) {
+	resp, err := s.do(ctx, http.MethodDelete, s.objectURL(key, url.Values{"uploadId": {uploadID}}), nil, 0, emptyPayloadHash)
+	if err != nil {
+		logutil.Warn("Failed to abort multipart upload of %s: %v", key, err)
+		return
+	}
+	resp.Body.Close()
+}
@@ -0,0 +1,475 @@
+package destination
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"docker-backup-tool/internal/config"
+	"docker-backup-tool/internal/logutil"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// SFTP uploads archives over SSH without an external binary.
+type SFTP struct {
+	name string
+	cfg  config.SFTPDestinationConfig
+}
+
+// NewSFTP creates an SFTP destination.
+func NewSFTP(name string, cfg config.SFTPDestinationConfig) (*SFTP, error) {
+	if cfg.Host == "" || cfg.User == "" {
+		return nil, fmt.Errorf("destination '%s': sftp.host and sftp.user are required", name)
+	}
+	if cfg.Port == 0 {
+		cfg.Port = 22
+	}
+	if cfg.KnownHostsFile == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, fmt.Errorf("destination '%s': cannot locate known_hosts: %w", name, err)
+		}
+		cfg.KnownHostsFile = filepath.Join(home, ".ssh", "known_hosts")
+	}
+	if cfg.Verify == "" {
+		cfg.Verify = config.VerifySHA256
+	}
+	return &SFTP{name: name, cfg: cfg}, nil
+}
+
+func (s *SFTP) Name() string { return s.name }
+
+// sftpSession is an SFTP client together with the SSH connection it runs on,
+// which is also used to run commands for remote verification.
+type sftpSession struct {
+	*sftp.Client
+	ssh *ssh.Client
+}
+
+func (c *sftpSession) Close() {
+	c.Client.Close()
+	c.ssh.Close()
+}
+
+// authMethods returns the configured SSH authentication methods in order:
+// private key, ssh-agent (when SSH_AUTH_SOCK is set), password.
+func (s *SFTP) authMethods() ([]ssh.AuthMethod, func(), error) {
+	var auth []ssh.AuthMethod
+	cleanup := func() {}
+	if s.cfg.PrivateKeyFile != "" {
+		signer, err := s.privateKey()
+		if err != nil {
+			return nil, nil, err
+		}
+		auth = append(auth, ssh.PublicKeys(signer))
+	}
+	if sock := os.Getenv("SSH_AUTH_SOCK"); sock != "" {
+		conn, err := net.Dial("unix", sock)
+		if err != nil {
+			logutil.Warn("Cannot reach ssh-agent at %s: %v", sock, err)
+		} else {
+			auth = append(auth, ssh.PublicKeysCallback(agent.NewClient(conn).Signers))
+			cleanup = func() { conn.Close() }
+		}
+	}
+	if s.cfg.Password != "" {
+		auth = append(auth, ssh.Password(s.cfg.Password))
+	}
+	if len(auth) == 0 {
+		return nil, nil, errors.New("no SSH authentication configured (set sftp.private_key_file or sftp.password, or run an ssh-agent)")
+	}
+	return auth, cleanup, nil
+}
+
+func (s *SFTP) privateKey() (ssh.Signer, error) {
+	key, err := os.ReadFile(s.cfg.PrivateKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read private key: %w", err)
+	}
+	signer, err := ssh.ParsePrivateKey(key)
+	var missing *ssh.PassphraseMissingError
+	if errors.As(err, &missing) {
+		if s.cfg.PassphraseFile == "" {
+			return nil, fmt.Errorf("private key '%s' is encrypted, set sftp.passphrase_file", s.cfg.PrivateKeyFile)
+		}
+		passphrase, readErr := os.ReadFile(s.cfg.PassphraseFile)
+		if readErr != nil {
+			return nil, fmt.Errorf("failed to read key passphrase: %w", readErr)
+		}
+		signer, err = ssh.ParsePrivateKeyWithPassphrase(key, bytes.TrimRight(passphrase, "\r\n"))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse private key '%s': %w", s.cfg.PrivateKeyFile, err)
+	}
+	return signer, nil
+}
+
+// hostKeyCallback verifies the server against known_hosts. It also returns the key
+// algorithms known for the host, so the server is asked for a key type we can verify.
+func (s *SFTP) hostKeyCallback(addr string) (ssh.HostKeyCallback, []string, error) {
+	callback, err := knownhosts.New(s.cfg.KnownHostsFile)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load known_hosts '%s': %w", s.cfg.KnownHostsFile, err)
+	}
+
+	// Checking a dummy key reports the keys known for the host in KeyError.Want
+	var algorithms []string
+	var keyErr *knownhosts.KeyError
+	if err := callback(addr, &net.TCPAddr{}, dummyHostKey{}); errors.As(err, &keyErr) {
+		for _, k := range keyErr.Want {
+			algorithms = append(algorithms, k.Key.Type())
+		}
+	}
+	if len(algorithms) == 0 {
+		return nil, nil, fmt.Errorf("host %s is not in '%s' (add it with: ssh-keyscan -p %d %s >> %s)",
+			addr, s.cfg.KnownHostsFile, s.cfg.Port, s.cfg.Host, s.cfg.KnownHostsFile)
+	}
+
+	verify := func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		err := callback(hostname, remote, key)
+		if errors.As(err, &keyErr) && len(keyErr.Want) > 0 {
+			return fmt.Errorf("host key of %s does not match known_hosts (possible man-in-the-middle attack): %w", hostname, err)
+		}
+		return err
+	}
+	return verify, algorithms, nil
+}
+
+// dummyHostKey is only used to look up the keys known for a host.
+type dummyHostKey struct{}
+
+func (dummyHostKey) Type() string                        { return "dummy" }
+func (dummyHostKey) Marshal() []byte                     { return []byte("dummy") }
+func (dummyHostKey) Verify([]byte, *ssh.Signature) error { return errors.New("dummy key") }
+
+// connectTimeout limits connecting, the SSH handshake and starting the SFTP session.
+const connectTimeout = 30 * time.Second
+
+// connect opens an SSH connection and an SFTP session on it.
+func (s *SFTP) connect(ctx context.Context) (*sftpSession, error) {
+	addr := net.JoinHostPort(s.cfg.Host, strconv.Itoa(s.cfg.Port))
+	hostKeys, algorithms, err := s.hostKeyCallback(addr)
+	if err != nil {
+		return nil, err
+	}
+	auth, closeAgent, err := s.authMethods()
+	if err != nil {
+		return nil, err
+	}
+	defer closeAgent()
+
+	sshConfig := &ssh.ClientConfig{
+		User:              s.cfg.User,
+		Auth:              auth,
+		HostKeyCallback:   hostKeys,
+		HostKeyAlgorithms: hostKeyAlgorithms(algorithms),
+	}
+	dialer := net.Dialer{Timeout: connectTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %w", addr, err)
+	}
+
+	// ClientConfig.Timeout only applies to ssh.Dial: bound the handshake on our own
+	// connection with a deadline, and close it when ctx is cancelled meanwhile
+	conn.SetDeadline(time.Now().Add(connectTimeout))
+	handshakeDone := make(chan struct{})
+	defer close(handshakeDone)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-handshakeDone:
+		}
+	}()
+
+	sshConn, chans, reqs, err := ssh.NewClientConn(conn, addr, sshConfig)
+	if err != nil {
+		conn.Close()
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		}
+		return nil, fmt.Errorf("SSH handshake with %s failed: %w", addr, err)
+	}
+	client := ssh.NewClient(sshConn, chans, reqs)
+	sc, err := sftp.NewClient(client, sftp.UseConcurrentWrites(true))
+	if err != nil {
+		client.Close()
+		return nil, fmt.Errorf("failed to start SFTP session on %s: %w", addr, err)
+	}
+	if err := conn.SetDeadline(time.Time{}); err != nil {
+		sc.Close()
+		client.Close()
+		return nil, err
+	}
+	return &sftpSession{Client: sc, ssh: client}, nil
+}
+
+// hostKeyAlgorithms expands RSA keys to the signature algorithms servers use for them.
+func hostKeyAlgorithms(keyTypes []string) []string {
+	var algorithms []string
+	for _, t := range keyTypes {
+		if t == ssh.KeyAlgoRSA {
+			algorithms = append(algorithms, ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256)
+		}
+		algorithms = append(algorithms, t)
+	}
+	return algorithms
+}
+
+func (s *SFTP) remotePath(name string) string {
+	return path.Join(s.cfg.Path, name)
+}
+
+// Upload writes the file to <name>.partial and renames it into place once it has
+// been verified. A .partial file left by an interrupted upload is resumed; if the
+// resumed file fails verification or cannot be checksummed, the upload is repeated
+// from the start.
+func (s *SFTP) Upload(ctx context.Context, localPath string) (TransferStats, error) {
+	start := time.Now()
+	sc, err := s.connect(ctx)
+	if err != nil {
+		return TransferStats{}, err
+	}
+	defer sc.Close()
+
+	if s.cfg.Path != "" {
+		if err := sc.MkdirAll(s.cfg.Path); err != nil {
+			return TransferStats{}, fmt.Errorf("failed to create remote directory '%s': %w", s.cfg.Path, err)
+		}
+	}
+
+	in, err := os.Open(localPath)
+	if err != nil {
+		return TransferStats{}, err
+	}
+	defer in.Close()
+	info, err := in.Stat()
+	if err != nil {
+		return TransferStats{}, err
+	}
+
+	target := s.remotePath(filepath.Base(localPath))
+	tmp := target + ".partial"
+	resume := true
+	var sent int64
+	for {
+		n, resumed, err := s.send(ctx, sc, in, info.Size(), tmp, resume)
+		sent += n
+		if err != nil {
+			return TransferStats{}, err
+		}
+		err = s.verify(ctx, sc, in, info.Size(), tmp, resumed)
+		if err == nil {
+			break
+		}
+		if !resumed {
+			sc.Remove(tmp)
+			return TransferStats{}, err
+		}
+		logutil.Warn("Resumed upload of %s to '%s' failed verification (%v), uploading again", filepath.Base(localPath), s.name, err)
+		resume = false
+	}
+
+	sc.Remove(target)
+	if err := sc.Rename(tmp, target); err != nil {
+		return TransferStats{}, fmt.Errorf("failed to move '%s' into place: %w", target, err)
+	}
+	return TransferStats{Files: 1, Bytes: sent, Duration: time.Since(start)}, nil
+}
+
+// send copies in to the remote file tmp, continuing after the data already present
+// when resume is set. It reports the bytes sent and whether an earlier upload was resumed.
+func (s *SFTP) send(ctx context.Context, sc *sftpSession, in *os.File, size int64, tmp string, resume bool) (int64, bool, error) {
+	var offset int64
+	if resume {
+		if info, err := sc.Stat(tmp); err == nil && info.Size() <= size {
+			offset = info.Size()
+		}
+	}
+	flags := os.O_WRONLY | os.O_CREATE
+	if offset == 0 {
+		flags |= os.O_TRUNC
+	}
+	out, err := sc.OpenFile(tmp, flags)
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to create remote file '%s': %w", tmp, err)
+	}
+	if offset > 0 {
+		logutil.Info("Resuming upload of %s at %d of %d bytes", strings.TrimSuffix(path.Base(tmp), ".partial"), offset, size)
+	}
+	if _, err := out.Seek(offset, io.SeekStart); err != nil {
+		out.Close()
+		return 0, false, err
+	}
+	n, err := io.Copy(out, &contextReader{ctx: ctx, r: io.NewSectionReader(in, offset, size-offset)})
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	// The .partial file is kept on errors so the next attempt can resume it
+	return n, offset > 0, err
+}
+
+// verify compares the remote file with the local one: by size, and with verify: sha256
+// also by a checksum computed on the server. Servers that do not allow running
+// commands (SFTP-only accounts) fall back to the size check, except for a resumed
+// file: its size says nothing about the data written before the interruption.
+func (s *SFTP) verify(ctx context.Context, sc *sftpSession, in *os.File, size int64, remote string, resumed bool) error {
+	info, err := sc.Stat(remote)
+	if err != nil {
+		return fmt.Errorf("failed to stat uploaded file: %w", err)
+	}
+	if info.Size() != size {
+		return fmt.Errorf("remote file has %d bytes, expected %d", info.Size(), size)
+	}
+	if s.cfg.Verify != config.VerifySHA256 {
+		return nil
+	}
+
+	remoteSum, err := s.remoteSHA256(sc, remote)
+	if err != nil {
+		if resumed {
+			return fmt.Errorf("remote checksum not available to verify the resumed file: %w", err)
+		}
+		logutil.Warn("Remote checksum on '%s' not available, verified the size of %s only (set verify: size to skip the checksum): %v",
+			s.name, strings.TrimSuffix(path.Base(remote), ".partial"), err)
+		return nil
+	}
+	h := sha256.New()
+	if _, err := io.Copy(h, &contextReader{ctx: ctx, r: io.NewSectionReader(in, 0, size)}); err != nil {
+		return err
+	}
+	if localSum := hex.EncodeToString(h.Sum(nil)); remoteSum != localSum {
+		return fmt.Errorf("checksum mismatch: remote %s, local %s", remoteSum, localSum)
+	}
+	return nil
+}
+
+// remoteSHA256 runs sha256sum on the server.
+func (s *SFTP) remoteSHA256(sc *sftpSession, remote string) (string, error) {
+	session, err := sc.ssh.NewSession()
+	if err != nil {
+		return "", err
+	}
+	defer session.Close()
+	out, err := session.Output("sha256sum " + shellQuote(remote))
+	if err != nil {
+		return "", err
+	}
+	fields := strings.Fields(string(out))
+	if len(fields) == 0 || len(fields[0]) != sha256.Size*2 {
+		return "", fmt.Errorf("unexpected sha256sum output %q", out)
+	}
+	return fields[0], nil
+}
+
+// shellQuote quotes s for a POSIX shell.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+func (s *SFTP) List(ctx context.Context) ([]Object, error) {
+	sc, err := s.connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer sc.Close()
+
+	dir := s.cfg.Path
+	if dir == "" {
+		dir = "."
+	}
+	entries, err := sc.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var objects []Object
+	for _, e := range entries {
+		if e.Mode().IsRegular() {
+			objects = append(objects, Object{Name: e.Name(), Size: e.Size(), ModTime: e.ModTime()})
+		}
+	}
+	return objects, nil
+}
+
+func (s *SFTP) Stat(ctx context.Context, name string) (Object, error) {
+	sc, err := s.connect(ctx)
+	if err != nil {
+		return Object{}, err
+	}
+	defer sc.Close()
+	info, err := sc.Stat(s.remotePath(name))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return Object{}, ErrNotFound
+		}
+		return Object{}, err
+	}
+	return Object{Name: name, Size: info.Size(), ModTime: info.ModTime()}, nil
+}
+
+func (s *SFTP) Delete(ctx context.Context, name string) error {
+	sc, err := s.connect(ctx)
+	if err != nil {
+		return err
+	}
+	defer sc.Close()
+	return sc.Remove(s.remotePath(name))
+}
+
+func (s *SFTP) Download(ctx context.Context, name, localPath string) error {
+	sc, err := s.connect(ctx)
+	if err != nil {
+		return err
+	}
+	defer sc.Close()
+
+	in, err := sc.Open(s.remotePath(name))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return ErrNotFound
+		}
+		return err
+	}
+	defer in.Close()
+	return writeLocalFile(ctx, in, localPath)
+}
+
+// writeLocalFile stores r in localPath via a temporary file.
+func writeLocalFile(ctx context.Context, r io.Reader, localPath string) error {
+	if err := os.MkdirAll(filepath.Dir(localPath), 0755); err != nil {
+		return err
+	}
+	tmp := localPath + ".partial"
+	out, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, &contextReader{ctx: ctx, r: r})
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, localPath)
+}
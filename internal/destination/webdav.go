@@ -0,0 +1,199 @@
+package destination
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"docker-backup-tool/internal/config"
+)
+
+// WebDAV uploads archives to a WebDAV collection (Nextcloud, ownCloud, Apache mod_dav, ...).
+type WebDAV struct {
+	name   string
+	cfg    config.WebDAVDestinationConfig
+	base   *url.URL
+	client *http.Client
+}
+
+// NewWebDAV creates a WebDAV destination.
+func NewWebDAV(name string, cfg config.WebDAVDestinationConfig) (*WebDAV, error) {
+	if cfg.URL == "" {
+		return nil, fmt.Errorf("destination '%s': webdav.url is required", name)
+	}
+	base, err := url.Parse(cfg.URL)
+	if err != nil {
+		return nil, fmt.Errorf("destination '%s': invalid webdav.url: %w", name, err)
+	}
+	if !strings.HasSuffix(base.Path, "/") {
+		base.Path += "/"
+	}
+	return &WebDAV{name: name, cfg: cfg, base: base, client: &http.Client{}}, nil
+}
+
+func (w *WebDAV) Name() string { return w.name }
+
+func (w *WebDAV) objectURL(name string) string {
+	u := *w.base
+	u.Path = path.Join(w.base.Path, name)
+	return u.String()
+}
+
+func (w *WebDAV) do(ctx context.Context, method, target string, body io.Reader, size int64, headers map[string]string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, target, body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.ContentLength = size
+	}
+	if w.cfg.Username != "" {
+		req.SetBasicAuth(w.cfg.Username, w.cfg.Password)
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	return w.client.Do(req)
+}
+
+// ensureCollection creates the target collection if it does not exist yet.
+func (w *WebDAV) ensureCollection(ctx context.Context) error {
+	resp, err := w.do(ctx, "MKCOL", w.base.String(), nil, 0, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	// 405 Method Not Allowed means the collection already exists
+	if resp.StatusCode >= 300 && resp.StatusCode != http.StatusMethodNotAllowed {
+		return fmt.Errorf("MKCOL %s: %s", w.base.Redacted(), resp.Status)
+	}
+	return nil
+}
+
+func (w *WebDAV) Upload(ctx context.Context, localPath string) (TransferStats, error) {
+	start := time.Now()
+	if err := w.ensureCollection(ctx); err != nil {
+		return TransferStats{}, err
+	}
+	f, err := os.Open(localPath)
+	if err != nil {
+		return TransferStats{}, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return TransferStats{}, err
+	}
+
+	resp, err := w.do(ctx, http.MethodPut, w.objectURL(filepath.Base(localPath)), f, info.Size(),
+		map[string]string{"Content-Type": "application/octet-stream"})
+	if err != nil {
+		return TransferStats{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return TransferStats{}, fmt.Errorf("PUT %s: %s", filepath.Base(localPath), resp.Status)
+	}
+	return TransferStats{Files: 1, Bytes: info.Size(), Duration: time.Since(start)}, nil
+}
+
+// propfindBody requests the properties needed to list archives.
+const propfindBody = `<?xml version="1.0" encoding="utf-8"?>
+<d:propfind xmlns:d="DAV:"><d:prop><d:resourcetype/><d:getcontentlength/><d:getlastmodified/></d:prop></d:propfind>`
+
+type multistatus struct {
+	Responses []struct {
+		Href string `xml:"href"`
+		Prop struct {
+			ResourceType struct {
+				Collection *struct{} `xml:"collection"`
+			} `xml:"resourcetype"`
+			ContentLength int64  `xml:"getcontentlength"`
+			LastModified  string `xml:"getlastmodified"`
+		} `xml:"propstat>prop"`
+	} `xml:"response"`
+}
+
+func (w *WebDAV) List(ctx context.Context) ([]Object, error) {
+	resp, err := w.do(ctx, "PROPFIND", w.base.String(), strings.NewReader(propfindBody), int64(len(propfindBody)),
+		map[string]string{"Depth": "1", "Content-Type": "application/xml"})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if resp.StatusCode != http.StatusMultiStatus {
+		return nil, fmt.Errorf("PROPFIND %s: %s", w.base.Redacted(), resp.Status)
+	}
+
+	var ms multistatus
+	if err := xml.NewDecoder(resp.Body).Decode(&ms); err != nil {
+		return nil, fmt.Errorf("failed to parse PROPFIND response: %w", err)
+	}
+	var objects []Object
+	for _, r := range ms.Responses {
+		if r.Prop.ResourceType.Collection != nil {
+			continue
+		}
+		href, err := url.PathUnescape(r.Href)
+		if err != nil {
+			href = r.Href
+		}
+		modTime, _ := http.ParseTime(r.Prop.LastModified)
+		objects = append(objects, Object{Name: path.Base(href), Size: r.Prop.ContentLength, ModTime: modTime})
+	}
+	return objects, nil
+}
+
+func (w *WebDAV) Stat(ctx context.Context, name string) (Object, error) {
+	resp, err := w.do(ctx, http.MethodHead, w.objectURL(name), nil, 0, nil)
+	if err != nil {
+		return Object{}, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return Object{}, ErrNotFound
+	}
+	if resp.StatusCode >= 300 {
+		return Object{}, fmt.Errorf("HEAD %s: %s", name, resp.Status)
+	}
+	modTime, _ := http.ParseTime(resp.Header.Get("Last-Modified"))
+	return Object{Name: name, Size: resp.ContentLength, ModTime: modTime}, nil
+}
+
+func (w *WebDAV) Delete(ctx context.Context, name string) error {
+	resp, err := w.do(ctx, http.MethodDelete, w.objectURL(name), nil, 0, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 && resp.StatusCode != http.StatusNotFound {
+		return fmt.Errorf("DELETE %s: %s", name, resp.Status)
+	}
+	return nil
+}
+
+func (w *WebDAV) Download(ctx context.Context, name, localPath string) error {
+	resp, err := w.do(ctx, http.MethodGet, w.objectURL(name), nil, 0, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return ErrNotFound
+	}
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("GET %s: %s", name, resp.Status)
+	}
+	return writeLocalFile(ctx, resp.Body, localPath)
+}
@@ -0,0 +1,101 @@
+package discovery
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"docker-backup-tool/internal/docker"
+)
+
+// Unavailable is a project known from container labels that cannot be backed up
+// because its files are not reachable from here.
+type Unavailable struct {
+	Project docker.ComposeProject
+	Reason  string
+}
+
+// MergeContainers adds the projects known from container labels to the scanned ones.
+// A labelled project whose working directory is a scanned project is the same project
+// and keeps its scanned name and files. The others become projects of their own if
+// their working directory and compose files exist here, e.g. stacks started from
+// directories outside the compose roots. Their profiles and env files are taken
+// from the settings for their name, and docker compose is called with the project
+// name from the labels unless project_name is configured.
+func MergeContainers(scanned []Project, labelled []docker.ComposeProject, settings map[string]Settings) ([]Project, []Unavailable) {
+	projects := append([]Project{}, scanned...)
+	byPath := make(map[string]bool)
+	byName := make(map[string]Project)
+	for _, p := range scanned {
+		byPath[realPath(p.Path)] = true
+		byName[p.Name] = p
+	}
+
+	var unavailable []Unavailable
+	for _, lp := range labelled {
+		if lp.WorkingDir != "" && byPath[realPath(lp.WorkingDir)] {
+			continue
+		}
+		p, reason := containerProject(lp)
+		if reason == "" {
+			// The label holds the name the stack was started with (maybe with -p), so
+			// compose must be given it; otherwise it derives one from the directory
+			s := settings[p.Name]
+			if s.ProjectName == "" {
+				s.ProjectName = lp.Name
+			}
+			if err := p.apply(s); err != nil {
+				reason = err.Error()
+			}
+		}
+		if reason == "" {
+			if other, ok := byName[p.Name]; ok {
+				reason = fmt.Sprintf("its name is already used by %s", other.Path)
+			}
+		}
+		if reason != "" {
+			unavailable = append(unavailable, Unavailable{Project: lp, Reason: reason})
+			continue
+		}
+		byPath[realPath(p.Path)] = true
+		byName[p.Name] = p
+		projects = append(projects, p)
+	}
+	return projects, unavailable
+}
+
+// containerProject builds a project from container labels. The reason is set if the
+// project's files cannot be found.
+func containerProject(lp docker.ComposeProject) (Project, string) {
+	if lp.WorkingDir == "" {
+		return Project{}, "its containers have no " + docker.LabelWorkingDir + " label"
+	}
+	if info, err := os.Stat(lp.WorkingDir); err != nil || !info.IsDir() {
+		return Project{}, fmt.Sprintf("its directory %s does not exist here", lp.WorkingDir)
+	}
+	files := append([]string{}, lp.ConfigFiles...)
+	if len(files) == 0 {
+		var err error
+		if files, err = ComposeFiles(lp.WorkingDir, nil); err != nil || len(files) == 0 {
+			return Project{}, fmt.Sprintf("no compose file found in %s", lp.WorkingDir)
+		}
+	}
+	for i, f := range files {
+		if !filepath.IsAbs(f) {
+			f = filepath.Join(lp.WorkingDir, f)
+			files[i] = f
+		}
+		if !isFile(f) {
+			return Project{}, fmt.Sprintf("its compose file %s does not exist here", f)
+		}
+	}
+	return Project{Name: lp.Name, Path: lp.WorkingDir, ComposeFilePath: files[0], ComposeFiles: files, ComposeName: lp.Name}, ""
+}
+
+// realPath resolves symlinks so the same directory reached through different paths compares equal.
+func realPath(path string) string {
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		return resolved
+	}
+	return filepath.Clean(path)
+}
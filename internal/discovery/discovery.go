@@ -4,8 +4,13 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 
+	"docker-backup-tool/internal/docker"
 	"docker-backup-tool/internal/logutil"
+
+	"github.com/joho/godotenv"
+	"gopkg.in/yaml.v3"
 )
 
 // Project represents a discovered Docker Compose project.
@@ -13,65 +18,298 @@ import (
 type Project struct {
 	Name            string
 	Path            string
-	ComposeFilePath string
+	ComposeFilePath string   // Main compose file, the first of ComposeFiles
+	ComposeFiles    []string // Every compose file of the project in the order docker compose merges them
+
+	// Set from the project settings and passed to every docker compose call
+	ComposeName string   // --project-name, empty unless configured or known from container labels
+	EnvFiles    []string // Absolute paths
+	Profiles    []string
+}
+
+// Compose returns the project as passed to docker compose.
+func (p Project) Compose() docker.Project {
+	return docker.Project{Dir: p.Path, Files: p.ComposeFiles, Name: p.ComposeName, EnvFiles: p.EnvFiles, Profiles: p.Profiles}
+}
+
+// Settings are the docker compose options configured for a project. Relative file
+// names are resolved in the project directory.
+type Settings struct {
+	ComposeFiles []string
+	Profiles     []string
+	EnvFiles     []string
+	ProjectName  string // Overrides the discovered name
+}
+
+// Options configures project discovery.
+type Options struct {
+	MaxDepth int // Directory levels below a root that are searched
+	// Settings per project, keyed by project name, by the absolute path of the project
+	// directory or by the path relative to the root (with "/" or "-" as separator)
+	Projects map[string]Settings
 }
 
-// FindComposeProjects scans a directory for potential Docker Compose project subdirectories.
-func FindComposeProjects(composeDir string) ([]Project, error) {
+// IgnoreMarker is a file that excludes a directory and everything below it from discovery.
+const IgnoreMarker = ".nobackup"
+
+// FindComposeProjects scans the compose roots for project directories, descending at
+// most maxDepth levels below each root. A directory containing a compose file is a
+// project and is not searched further; hidden directories and directories with an
+// IgnoreMarker are skipped. Project names come from the compose file's name: field,
+// or from the path relative to the root with "/" replaced by "-". Names are not
+// checked for uniqueness here, see Duplicates.
+func FindComposeProjects(roots []string, opts Options) ([]Project, error) {
 	projects := []Project{}
 
-	entries, err := os.ReadDir(composeDir)
+	for _, root := range roots {
+		found, err := findInDir(root, root, 1, opts)
+		if err != nil {
+			return nil, err
+		}
+		projects = append(projects, found...)
+	}
+
+	if len(projects) == 0 {
+		// Return an empty slice, not an error, if the directory is just empty
+		// Main loop handles the "no projects found" message.
+		logutil.Debug("No projects with compose files found in %s", strings.Join(roots, ", "))
+	}
+
+	return projects, nil
+}
+
+// Duplicates separates the projects whose name is used by another project. Names
+// must be unique since they name the archives and the entries of the index, catalog
+// and metrics; none of the projects sharing a name is backed up, so that which one
+// would get it does not depend on the order of the compose roots.
+func Duplicates(projects []Project) (unique []Project, duplicates []Skipped) {
+	byName := make(map[string][]Project)
+	for _, p := range projects {
+		byName[p.Name] = append(byName[p.Name], p)
+	}
+	for _, p := range projects {
+		same := byName[p.Name]
+		if len(same) == 1 {
+			unique = append(unique, p)
+			continue
+		}
+		var others []string
+		for _, o := range same {
+			if o.Path != p.Path {
+				others = append(others, o.Path)
+			}
+		}
+		duplicates = append(duplicates, Skipped{Project: p, Reason: fmt.Sprintf(
+			"its project name '%s' is also used by %s; give it a unique name: in its compose file or a project_name under projects, keyed by '%s'",
+			p.Name, strings.Join(others, ", "), p.Path)})
+	}
+	return unique, duplicates
+}
+
+// findInDir returns the projects in the subdirectories of dir, which is depth levels below root.
+func findInDir(root, dir string, depth int, opts Options) ([]Project, error) {
+	entries, err := os.ReadDir(dir)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read compose directory '%s': %w", composeDir, err)
+		if dir == root {
+			return nil, fmt.Errorf("failed to read compose directory '%s': %w", root, err)
+		}
+		logutil.Warn("Skipping %s during discovery: %v", dir, err)
+		return nil, nil
 	}
 
+	var projects []Project
 	for _, entry := range entries {
-		if entry.IsDir() {
-			projectPath := filepath.Join(composeDir, entry.Name())
-			composeFile, err := FindFirstComposeFile(projectPath)
+		if !entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		projectPath := filepath.Join(dir, entry.Name())
+		if _, err := os.Stat(filepath.Join(projectPath, IgnoreMarker)); err == nil {
+			logutil.Info("Skipping %s: %s marker found.", projectPath, IgnoreMarker)
+			continue
+		}
+		project, err := findProject(root, projectPath, opts)
+		if err != nil {
+			logutil.Warn("Skipping %s: %v", projectPath, err)
+			continue
+		}
+		if project != nil {
+			projects = append(projects, *project)
+			continue
+		}
+		if depth < opts.MaxDepth {
+			nested, err := findInDir(root, projectPath, depth+1, opts)
 			if err != nil {
-				// Log this? Or let the main loop handle it?
-				// Let main loop log the error from FindFirstComposeFile if needed
-				logutil.Debug("Error finding compose file in %s: %v. Skipping directory.", projectPath, err)
-				continue
-			}
-			if composeFile != "" {
-				projects = append(projects, Project{
-					Name:            entry.Name(),
-					Path:            projectPath,
-					ComposeFilePath: composeFile,
-				})
+				return nil, err
 			}
+			projects = append(projects, nested...)
+		} else if yamlFiles := otherYAMLFiles(projectPath); len(yamlFiles) > 0 {
+			logutil.Warn("Skipping %s: no compose.yaml or docker-compose.yml, only %s. List the files in compose_files to back it up.",
+				projectPath, strings.Join(yamlFiles, ", "))
 		}
 	}
+	return projects, nil
+}
 
-	if len(projects) == 0 {
-		// Return an empty slice, not an error, if the directory is just empty
-		// Main loop handles the "no projects found" message.
-		logutil.Debug("No projects with compose files found directly in %s", composeDir)
+// findProject returns the project in dir, or nil if dir contains no compose file.
+func findProject(root, dir string, opts Options) (*Project, error) {
+	rel, err := filepath.Rel(root, dir)
+	if err != nil {
+		rel = filepath.Base(dir)
 	}
+	rel = filepath.ToSlash(rel)
+	pathName := strings.ReplaceAll(rel, "/", "-")
 
-	return projects, nil
+	settings, byPath := opts.Projects[dir]
+	if !byPath {
+		settings, byPath = opts.Projects[rel]
+	}
+	if !byPath {
+		settings, byPath = opts.Projects[pathName]
+	}
+	files, err := ComposeFiles(dir, settings.ComposeFiles)
+	if err != nil || len(files) == 0 {
+		return nil, err
+	}
+	name := composeProjectName(files)
+	if name == "" {
+		name = pathName
+	}
+	if named, ok := opts.Projects[name]; ok && !byPath {
+		settings = named
+		if files, err = ComposeFiles(dir, settings.ComposeFiles); err != nil {
+			return nil, err
+		}
+	}
+	if settings.ProjectName != "" {
+		name = settings.ProjectName
+	}
+	p := &Project{Name: name, Path: dir, ComposeFilePath: files[0], ComposeFiles: files}
+	if err := p.apply(settings); err != nil {
+		return nil, err
+	}
+	return p, nil
 }
 
-// FindFirstComposeFile finds the first file with a .yml or .yaml extension in a directory.
-func FindFirstComposeFile(dirPath string) (string, error) {
-	entries, err := os.ReadDir(dirPath)
+// apply sets the docker compose options of the project from its settings.
+func (p *Project) apply(s Settings) error {
+	envFiles, err := resolveFiles(p.Path, s.EnvFiles)
 	if err != nil {
-		return "", fmt.Errorf("failed to read project directory '%s': %w", dirPath, err)
+		return fmt.Errorf("env_file: %w", err)
 	}
+	p.ComposeName = s.ProjectName
+	p.EnvFiles = envFiles
+	p.Profiles = s.Profiles
+	return nil
+}
 
-	for _, entry := range entries {
-		if !entry.IsDir() {
-			ext := filepath.Ext(entry.Name())
-			if ext == ".yml" || ext == ".yaml" {
-				return filepath.Join(dirPath, entry.Name()), nil // Found one
+// composeProjectName reads the top-level name: field of the compose files; like in
+// docker compose, a later file overrides an earlier one. Names using variables are
+// ignored since they can only be resolved by docker compose.
+func composeProjectName(files []string) string {
+	name := ""
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			continue
+		}
+		var compose struct {
+			Name string `yaml:"name"`
+		}
+		if err := yaml.Unmarshal(data, &compose); err != nil || compose.Name == "" {
+			continue
+		}
+		name = strings.TrimSpace(compose.Name)
+	}
+	if strings.Contains(name, "$") {
+		return ""
+	}
+	return name
+}
+
+// composeFileNames are the files docker compose looks for, in its order of preference.
+var composeFileNames = []string{"compose.yaml", "compose.yml", "docker-compose.yaml", "docker-compose.yml"}
+
+// ComposeFiles returns the compose files of the project in dir, the way docker compose
+// picks them:
+//  1. the explicit list, if one is given (e.g. from compose_files in the config),
+//  2. COMPOSE_FILE from the project's .env file, split at COMPOSE_PATH_SEPARATOR,
+//  3. the first of compose.yaml, compose.yml, docker-compose.yaml and docker-compose.yml,
+//     followed by its override file (compose.override.yaml, ...) if there is one.
+//
+// Relative names are resolved in dir. It returns nil if dir has no compose file.
+func ComposeFiles(dir string, explicit []string) ([]string, error) {
+	if len(explicit) > 0 {
+		files, err := resolveFiles(dir, explicit)
+		if err != nil {
+			return nil, fmt.Errorf("compose_files: %w", err)
+		}
+		return files, nil
+	}
+	if env, err := godotenv.Read(filepath.Join(dir, ".env")); err == nil && env["COMPOSE_FILE"] != "" {
+		separator := env["COMPOSE_PATH_SEPARATOR"]
+		if separator == "" {
+			separator = string(os.PathListSeparator)
+		}
+		files, err := resolveFiles(dir, strings.Split(env["COMPOSE_FILE"], separator))
+		if err != nil {
+			return nil, fmt.Errorf("COMPOSE_FILE in .env: %w", err)
+		}
+		return files, nil
+	}
+
+	for _, name := range composeFileNames {
+		path := filepath.Join(dir, name)
+		if !isFile(path) {
+			continue
+		}
+		files := []string{path}
+		base := strings.TrimSuffix(name, filepath.Ext(name))
+		for _, ext := range []string{".yaml", ".yml"} {
+			if override := filepath.Join(dir, base+".override"+ext); isFile(override) {
+				files = append(files, override)
+				break
 			}
 		}
+		return files, nil
 	}
+	return nil, nil
+}
+
+// resolveFiles makes the file names absolute and checks that they exist.
+func resolveFiles(dir string, names []string) ([]string, error) {
+	var files []string
+	for _, name := range names {
+		if name = strings.TrimSpace(name); name == "" {
+			continue
+		}
+		if !filepath.IsAbs(name) {
+			name = filepath.Join(dir, name)
+		}
+		if !isFile(name) {
+			return nil, fmt.Errorf("file '%s' does not exist", name)
+		}
+		files = append(files, name)
+	}
+	return files, nil
+}
+
+// otherYAMLFiles returns the names of YAML files in dir, for explaining why it is not a project.
+func otherYAMLFiles(dir string) []string {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+	var names []string
+	for _, entry := range entries {
+		ext := filepath.Ext(entry.Name())
+		if !entry.IsDir() && !strings.HasPrefix(entry.Name(), ".") && (ext == ".yml" || ext == ".yaml") {
+			names = append(names, entry.Name())
+		}
+	}
+	return names
+}
 
-	// No compose file found - this is not necessarily an error for FindComposeProjects,
-	// but the caller (main loop) should know.
-	// log.Printf("Debug: No compose file found in %s", dirPath) // Reduce noise, main logs this
-	return "", nil // Return empty string, no error, if not found
+func isFile(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.Mode().IsRegular()
 }
@@ -0,0 +1,146 @@
+package discovery
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// EnableLabel is the service label that opts a project out of backups when set to false.
+const EnableLabel = "docker-backup.enable"
+
+// Filter selects projects by name. Patterns are names or globs as understood by
+// filepath.Match, e.g. "media-*".
+type Filter struct {
+	Include []string // If set, only matching projects are selected
+	Exclude []string // Matching projects are skipped, even if included
+}
+
+// Skipped is a discovered project that was not selected, with the reason for logging.
+type Skipped struct {
+	Project Project
+	Reason  string
+}
+
+// Select applies the filter and the EnableLabel opt-out to the discovered projects.
+// A project named exactly in Include is backed up even if its label opts it out.
+func (f Filter) Select(projects []Project) (selected []Project, skipped []Skipped) {
+	for _, p := range projects {
+		if reason := f.skipReason(p); reason != "" {
+			skipped = append(skipped, Skipped{Project: p, Reason: reason})
+			continue
+		}
+		selected = append(selected, p)
+	}
+	return selected, skipped
+}
+
+func (f Filter) skipReason(p Project) string {
+	if len(f.Include) > 0 && matchAny(f.Include, p.Name) == "" {
+		return "not selected by include_projects/--project"
+	}
+	if pattern := matchAny(f.Exclude, p.Name); pattern != "" {
+		return fmt.Sprintf("excluded by '%s'", pattern)
+	}
+	if f.named(p.Name) {
+		return ""
+	}
+	disabled, err := BackupDisabled(p.ComposeFiles)
+	if err != nil {
+		// Unreadable files are left to docker compose, which reports a better error
+		return ""
+	}
+	if disabled {
+		return fmt.Sprintf("opted out with label %s=false", EnableLabel)
+	}
+	return ""
+}
+
+// named reports whether a project is included by its exact name rather than a glob.
+func (f Filter) named(name string) bool {
+	for _, pattern := range f.Include {
+		if pattern == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Unmatched returns the include patterns that match none of the projects, which
+// usually are typos.
+func (f Filter) Unmatched(projects []Project) []string {
+	var unmatched []string
+	for _, pattern := range f.Include {
+		found := false
+		for _, p := range projects {
+			if ok, _ := filepath.Match(pattern, p.Name); ok {
+				found = true
+				break
+			}
+		}
+		if !found {
+			unmatched = append(unmatched, pattern)
+		}
+	}
+	return unmatched
+}
+
+// matchAny returns the first pattern matching name, or "" if none does.
+func matchAny(patterns []string, name string) string {
+	for _, pattern := range patterns {
+		if ok, _ := filepath.Match(pattern, name); ok {
+			return pattern
+		}
+	}
+	return ""
+}
+
+// BackupDisabled reports whether any service in the compose files carries the label
+// docker-backup.enable=false. Labels may be written as a map or as a list of
+// "key=value" strings.
+func BackupDisabled(composeFiles []string) (bool, error) {
+	for _, file := range composeFiles {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return false, err
+		}
+		var compose struct {
+			Services map[string]struct {
+				Labels interface{} `yaml:"labels"`
+			} `yaml:"services"`
+		}
+		if err := yaml.Unmarshal(data, &compose); err != nil {
+			return false, fmt.Errorf("failed to parse %s: %w", file, err)
+		}
+		for _, service := range compose.Services {
+			if value, ok := labelValue(service.Labels, EnableLabel); ok && strings.EqualFold(value, "false") {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// labelValue looks up a label in either of the compose label syntaxes.
+func labelValue(labels interface{}, key string) (string, bool) {
+	switch l := labels.(type) {
+	case map[string]interface{}:
+		if v, ok := l[key]; ok {
+			return fmt.Sprint(v), true
+		}
+	case []interface{}:
+		for _, item := range l {
+			s, ok := item.(string)
+			if !ok {
+				continue
+			}
+			if k, v, _ := strings.Cut(s, "="); strings.TrimSpace(k) == key {
+				return strings.TrimSpace(v), true
+			}
+		}
+	}
+	return "", false
+}
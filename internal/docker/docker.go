@@ -13,64 +13,109 @@ import (
 // has been moved to main.go's dependency check.
 // Functions now accept the command path as an argument.
 
-// runComposeCommand executes a docker compose command in a specific directory.
-func runComposeCommand(projectDir string, dockerCmd string, args ...string) (string, error) {
+// Project is a compose project as passed to docker compose: its directory and the
+// global options, which are given to every command so they all see the same project.
+type Project struct {
+	Dir      string
+	Files    []string // Absolute paths (-f); empty lets docker compose pick the files itself
+	Name     string   // --project-name; empty lets docker compose derive it
+	EnvFiles []string // --env-file, absolute paths
+	Profiles []string // --profile; "*" enables all profiles
+}
+
+// args returns the global docker compose arguments for the project.
+func (p Project) args() []string {
+	var args []string
+	if p.Name != "" {
+		args = append(args, "--project-name", p.Name)
+	}
+	for _, f := range p.Files {
+		args = append(args, "-f", f)
+	}
+	for _, f := range p.EnvFiles {
+		args = append(args, "--env-file", f)
+	}
+	for _, profile := range p.Profiles {
+		args = append(args, "--profile", profile)
+	}
+	return args
+}
+
+// WithAllProfiles returns the project with every profile enabled.
+func (p Project) WithAllProfiles() Project {
+	p.Profiles = []string{"*"}
+	return p
+}
+
+// runComposeCommand executes a docker compose command for a project. The output is
+// copied to the project log unless quiet is set.
+func runComposeCommand(p Project, dockerCmd string, quiet bool, args ...string) (string, error) {
 	// Determine base command (docker or docker-compose)
 	var baseCmd string
-	var composeArgs []string
+	composeArgs := append(p.args(), args...)
 	if dockerCmd == "docker-compose" {
 		baseCmd = dockerCmd
-		composeArgs = args
 	} else {
 		baseCmd = dockerCmd // Should be "docker"
-		composeArgs = append([]string{"compose"}, args...)
+		composeArgs = append([]string{"compose"}, composeArgs...)
 	}
 
 	cmd := exec.Command(baseCmd, composeArgs...)
-	cmd.Dir = projectDir
+	cmd.Dir = p.Dir
 
-	logutil.Debug("Running command in %s: %s", projectDir, strings.Join(cmd.Args, " ")) // Use logutil
+	logutil.Debug("Running command in %s: %s", p.Dir, strings.Join(cmd.Args, " ")) // Use logutil
 
 	var stdout, stderr bytes.Buffer
 	cmd.Stdout = &stdout
 	cmd.Stderr = &stderr
 
 	err := cmd.Run()
+	if quiet {
+		logutil.CommandOutput(cmd.Args, "", stderr.String(), err)
+	} else {
+		logutil.CommandOutput(cmd.Args, stdout.String(), stderr.String(), err)
+	}
 	if err != nil {
 		return stdout.String(), fmt.Errorf("failed to run %s in %s: %w\nStderr: %s",
-			strings.Join(cmd.Args, " "), projectDir, err, stderr.String())
+			strings.Join(cmd.Args, " "), p.Dir, err, stderr.String())
 	}
 	return stdout.String(), nil
 }
 
 // Down stops the docker compose stack.
-func Down(projectDir string, dockerCmd string) error {
-	_, err := runComposeCommand(projectDir, dockerCmd, "down")
+func Down(p Project, dockerCmd string) error {
+	_, err := runComposeCommand(p, dockerCmd, false, "down")
 	return err
 }
 
 // PsQuiet checks if any services are running for the project.
 // Returns true if services are running, false otherwise.
-func PsQuiet(projectDir string, dockerCmd string) (bool, error) {
-	output, err := runComposeCommand(projectDir, dockerCmd, "ps", "-q")
+func PsQuiet(p Project, dockerCmd string) (bool, error) {
+	output, err := runComposeCommand(p, dockerCmd, false, "ps", "-q")
 	if err != nil {
 		// If `ps -q` fails, we can't be sure, assume services might still be running?
 		// Return the error, let the caller decide how to interpret.
 		return true, fmt.Errorf("failed to check running services (ps -q): %w", err)
 	}
 	// If output is empty, no services are running
-	logutil.Debug("'ps -q' output for %s: [%s]", projectDir, strings.TrimSpace(output)) // Log output
+	logutil.Debug("'ps -q' output for %s: [%s]", p.Dir, strings.TrimSpace(output)) // Log output
 	return strings.TrimSpace(output) != "", nil
 }
 
 // Pull pulls the latest images for the project.
-func Pull(projectDir string, dockerCmd string) error {
-	_, err := runComposeCommand(projectDir, dockerCmd, "pull")
+func Pull(p Project, dockerCmd string) error {
+	_, err := runComposeCommand(p, dockerCmd, false, "pull")
 	return err
 }
 
 // UpDetached starts the docker compose stack in detached mode.
-func UpDetached(projectDir string, dockerCmd string) error {
-	_, err := runComposeCommand(projectDir, dockerCmd, "up", "-d")
+func UpDetached(p Project, dockerCmd string) error {
+	_, err := runComposeCommand(p, dockerCmd, false, "up", "-d")
 	return err
 }
+
+// Config returns the resolved configuration of the project (docker compose config).
+// The output may contain secrets from .env files, so only stderr goes to the project log.
+func Config(p Project, dockerCmd string) (string, error) {
+	return runComposeCommand(p, dockerCmd, true, "config")
+}
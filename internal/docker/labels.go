@@ -0,0 +1,96 @@
+package docker
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"sort"
+	"strings"
+
+	"docker-backup-tool/internal/logutil"
+)
+
+// Labels docker compose puts on every container it creates.
+const (
+	LabelProject     = "com.docker.compose.project"
+	LabelWorkingDir  = "com.docker.compose.project.working_dir"
+	LabelConfigFiles = "com.docker.compose.project.config_files"
+)
+
+// ComposeProject is a compose project as recorded in the labels of its containers.
+type ComposeProject struct {
+	Name        string
+	WorkingDir  string
+	ConfigFiles []string
+	Running     bool // At least one container of the project is running
+}
+
+// ListComposeProjects asks the Docker daemon for every container, running or not,
+// that was created by docker compose and groups them by project. It needs the docker
+// CLI even when compose itself runs as docker-compose.
+func ListComposeProjects() ([]ComposeProject, error) {
+	ids, err := dockerOutput("ps", "--all", "--quiet", "--no-trunc", "--filter", "label="+LabelProject)
+	if err != nil {
+		return nil, err
+	}
+	if strings.TrimSpace(ids) == "" {
+		return nil, nil
+	}
+
+	args := append([]string{"inspect", "--format", "{{json .Config.Labels}} {{.State.Running}}"}, strings.Fields(ids)...)
+	out, err := dockerOutput(args...)
+	if err != nil {
+		return nil, err
+	}
+
+	projects := make(map[string]*ComposeProject)
+	for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+		i := strings.LastIndex(line, " ")
+		if i < 0 {
+			continue
+		}
+		var labels map[string]string
+		if err := json.Unmarshal([]byte(line[:i]), &labels); err != nil {
+			return nil, fmt.Errorf("failed to parse container labels: %w", err)
+		}
+		name := labels[LabelProject]
+		if name == "" {
+			continue
+		}
+		p, ok := projects[name]
+		if !ok {
+			p = &ComposeProject{Name: name, WorkingDir: labels[LabelWorkingDir]}
+			for _, f := range strings.Split(labels[LabelConfigFiles], ",") {
+				if f = strings.TrimSpace(f); f != "" {
+					p.ConfigFiles = append(p.ConfigFiles, f)
+				}
+			}
+			projects[name] = p
+		}
+		if line[i+1:] == "true" {
+			p.Running = true
+		}
+	}
+
+	list := make([]ComposeProject, 0, len(projects))
+	for _, p := range projects {
+		list = append(list, *p)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list, nil
+}
+
+// dockerOutput runs a docker CLI command and returns its standard output.
+func dockerOutput(args ...string) (string, error) {
+	cmd := exec.Command("docker", args...)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	logutil.Debug("Running command: %s", strings.Join(cmd.Args, " "))
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("failed to run %s: %w\nStderr: %s", strings.Join(cmd.Args, " "), err, stderr.String())
+	}
+	return stdout.String(), nil
+}
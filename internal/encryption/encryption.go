@@ -0,0 +1,176 @@
+package encryption
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"docker-backup-tool/internal/config"
+
+	"filippo.io/age"
+)
+
+// Extension is appended to the names of encrypted archives.
+const Extension = ".age"
+
+// header is the first line of every age encrypted file.
+const header = "age-encryption.org/v1"
+
+// ErrWrongKey is returned when none of the configured identities or passphrases can decrypt a file.
+var ErrWrongKey = errors.New("none of the configured keys can decrypt this file (wrong identity file or passphrase?)")
+
+// Recipients returns the age recipients archives are encrypted to: either the configured
+// public keys, or a scrypt recipient derived from the passphrase. age does not allow mixing both.
+func Recipients(cfg config.EncryptionConfig) ([]age.Recipient, error) {
+	keys, err := publicKeys(cfg)
+	if err != nil {
+		return nil, err
+	}
+	passphrase, err := Passphrase(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case len(keys) > 0 && passphrase != "":
+		return nil, errors.New("encryption: recipients and a passphrase cannot be combined, configure one of them")
+	case len(keys) > 0:
+		var recipients []age.Recipient
+		for _, key := range keys {
+			r, err := age.ParseX25519Recipient(key)
+			if err != nil {
+				return nil, fmt.Errorf("encryption: invalid recipient '%s': %w", key, err)
+			}
+			recipients = append(recipients, r)
+		}
+		return recipients, nil
+	case passphrase != "":
+		r, err := age.NewScryptRecipient(passphrase)
+		if err != nil {
+			return nil, fmt.Errorf("encryption: %w", err)
+		}
+		return []age.Recipient{r}, nil
+	default:
+		return nil, fmt.Errorf("encryption is enabled but no recipients or passphrase are configured (set encryption.recipients, encryption.passphrase_file or $%s)", cfg.PassphraseEnv)
+	}
+}
+
+// Identities returns the identities used to decrypt archives: the keys from the
+// identity file and the passphrase, whichever are configured.
+func Identities(cfg config.EncryptionConfig) ([]age.Identity, error) {
+	var identities []age.Identity
+	if cfg.IdentityFile != "" {
+		f, err := os.Open(cfg.IdentityFile)
+		if err != nil {
+			return nil, fmt.Errorf("encryption: failed to open identity file: %w", err)
+		}
+		defer f.Close()
+		ids, err := age.ParseIdentities(f)
+		if err != nil {
+			return nil, fmt.Errorf("encryption: failed to parse identity file '%s': %w", cfg.IdentityFile, err)
+		}
+		identities = append(identities, ids...)
+	}
+
+	passphrase, err := Passphrase(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if passphrase != "" {
+		id, err := age.NewScryptIdentity(passphrase)
+		if err != nil {
+			return nil, fmt.Errorf("encryption: %w", err)
+		}
+		identities = append(identities, id)
+	}
+
+	if len(identities) == 0 {
+		return nil, fmt.Errorf("file is encrypted but no identity file or passphrase is configured (set encryption.identity_file, encryption.passphrase_file or $%s)", cfg.PassphraseEnv)
+	}
+	return identities, nil
+}
+
+// Passphrase returns the passphrase from the passphrase file or, failing that,
+// the configured environment variable. It returns "" if neither is set.
+func Passphrase(cfg config.EncryptionConfig) (string, error) {
+	if cfg.PassphraseFile != "" {
+		data, err := os.ReadFile(cfg.PassphraseFile)
+		if err != nil {
+			return "", fmt.Errorf("encryption: failed to read passphrase file: %w", err)
+		}
+		passphrase := strings.TrimRight(string(data), "\r\n")
+		if passphrase == "" {
+			return "", fmt.Errorf("encryption: passphrase file '%s' is empty", cfg.PassphraseFile)
+		}
+		return passphrase, nil
+	}
+	if cfg.PassphraseEnv != "" {
+		return os.Getenv(cfg.PassphraseEnv), nil
+	}
+	return "", nil
+}
+
+// publicKeys collects recipients from the config list and the recipients file.
+// Blank lines and '#' comments in the file are ignored.
+func publicKeys(cfg config.EncryptionConfig) ([]string, error) {
+	keys := append([]string{}, cfg.Recipients...)
+	if cfg.RecipientsFile == "" {
+		return keys, nil
+	}
+	f, err := os.Open(cfg.RecipientsFile)
+	if err != nil {
+		return nil, fmt.Errorf("encryption: failed to open recipients file: %w", err)
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		keys = append(keys, line)
+	}
+	return keys, scanner.Err()
+}
+
+// Encrypt returns a writer that encrypts everything written to it into w.
+// Close must be called to flush the final authenticated chunk.
+func Encrypt(w io.Writer, cfg config.EncryptionConfig) (io.WriteCloser, error) {
+	recipients, err := Recipients(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return age.Encrypt(w, recipients...)
+}
+
+// Decrypt returns a reader with the plaintext of r. Every chunk is authenticated;
+// reading a tampered or truncated file returns an error.
+func Decrypt(r io.Reader, cfg config.EncryptionConfig) (io.Reader, error) {
+	identities, err := Identities(cfg)
+	if err != nil {
+		return nil, err
+	}
+	plain, err := age.Decrypt(r, identities...)
+	if err != nil {
+		var noMatch *age.NoIdentityMatchError
+		if errors.As(err, &noMatch) {
+			return nil, ErrWrongKey
+		}
+		return nil, fmt.Errorf("failed to decrypt: %w", err)
+	}
+	return plain, nil
+}
+
+// IsEncrypted reports whether the data in r starts with an age header.
+func IsEncrypted(r io.ReaderAt) (bool, error) {
+	buf := make([]byte, len(header))
+	n, err := r.ReadAt(buf, 0)
+	if err != nil && err != io.EOF {
+		return false, err
+	}
+	return bytes.Equal(buf[:n], []byte(header)), nil
+}
@@ -0,0 +1,153 @@
+package logutil
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"sync"
+
+	"github.com/fatih/color"
+)
+
+// Color functions
+var (
+	colorInfo    = color.New(color.FgBlue).SprintfFunc()
+	colorWarn    = color.New(color.FgYellow).SprintfFunc()
+	colorError   = color.New(color.FgRed).SprintfFunc()
+	colorDebug   = color.New(color.FgMagenta).SprintfFunc()
+	colorSuccess = color.New(color.FgGreen).SprintfFunc()
+	colorFatal   = color.New(color.FgRed, color.Bold).SprintfFunc()
+)
+
+// consoleHandler writes "2006/01/02 15:04:05 [LEVEL]  message" lines with a coloured level.
+// Errors go to errW so they can be told apart from progress output, e.g. by cron.
+// Attributes are left out; they are meant for the JSON log file.
+type consoleHandler struct {
+	mu    *sync.Mutex
+	w     io.Writer
+	errW  io.Writer
+	level slog.Leveler
+	quiet bool // Only write the summary phase and fatal errors
+}
+
+func newConsoleHandler(w, errW io.Writer, level slog.Leveler, quiet bool) *consoleHandler {
+	return &consoleHandler{mu: &sync.Mutex{}, w: w, errW: errW, level: level, quiet: quiet}
+}
+
+func (h *consoleHandler) Enabled(_ context.Context, l slog.Level) bool {
+	if h.quiet {
+		return l >= slog.LevelInfo // The summary is written whatever the level
+	}
+	return l >= h.level.Level()
+}
+
+func (h *consoleHandler) Handle(_ context.Context, r slog.Record) error {
+	if h.quiet && r.Level < LevelFatal && !inSummary(r) {
+		return nil
+	}
+	var prefix string
+	switch r.Level {
+	case slog.LevelDebug:
+		prefix = colorDebug("[DEBUG] ")
+	case slog.LevelWarn:
+		prefix = colorWarn("[WARN] ")
+	case slog.LevelError:
+		prefix = colorError("[ERROR] ")
+	case LevelSuccess:
+		prefix = colorSuccess("[SUCCESS] ")
+	case LevelFatal:
+		prefix = colorFatal("[FATAL] ")
+	default:
+		prefix = colorInfo("[INFO] ")
+	}
+	line := r.Time.Format("2006/01/02 15:04:05") + " " + prefix + " " + r.Message + "\n"
+	w := h.w
+	if r.Level >= slog.LevelError {
+		w = h.errW
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, err := io.WriteString(w, line)
+	return err
+}
+
+// inSummary reports whether a record was logged in the summary phase of a run.
+func inSummary(r slog.Record) bool {
+	found := false
+	r.Attrs(func(a slog.Attr) bool {
+		if a.Key == "phase" {
+			found = a.Value.String() == PhaseSummary
+			return false
+		}
+		return true
+	})
+	return found
+}
+
+func (h *consoleHandler) WithAttrs([]slog.Attr) slog.Handler { return h }
+func (h *consoleHandler) WithGroup(string) slog.Handler      { return h }
+
+// textHandler writes the plain "[2006/01/02 03:04:05 PM] message" lines of the text log file.
+type textHandler struct {
+	mu    *sync.Mutex
+	w     io.Writer
+	level slog.Leveler
+}
+
+func newTextHandler(w io.Writer, level slog.Leveler) *textHandler {
+	return &textHandler{mu: &sync.Mutex{}, w: w, level: level}
+}
+
+func (h *textHandler) Enabled(_ context.Context, l slog.Level) bool {
+	return l >= h.level.Level()
+}
+
+func (h *textHandler) Handle(_ context.Context, r slog.Record) error {
+	line := r.Time.Format("[2006/01/02 03:04:05 PM]") + " " + r.Message + "\n" // Custom 12-hour format with brackets
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, err := io.WriteString(h.w, line)
+	return err
+}
+
+func (h *textHandler) WithAttrs([]slog.Attr) slog.Handler { return h }
+func (h *textHandler) WithGroup(string) slog.Handler      { return h }
+
+// multiHandler passes each record to every handler that accepts its level.
+type multiHandler []slog.Handler
+
+func (m multiHandler) Enabled(ctx context.Context, l slog.Level) bool {
+	for _, h := range m {
+		if h.Enabled(ctx, l) {
+			return true
+		}
+	}
+	return false
+}
+
+func (m multiHandler) Handle(ctx context.Context, r slog.Record) error {
+	var errs []error
+	for _, h := range m {
+		if h.Enabled(ctx, r.Level) {
+			errs = append(errs, h.Handle(ctx, r.Clone()))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (m multiHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	out := make(multiHandler, len(m))
+	for i, h := range m {
+		out[i] = h.WithAttrs(attrs)
+	}
+	return out
+}
+
+func (m multiHandler) WithGroup(name string) slog.Handler {
+	out := make(multiHandler, len(m))
+	for i, h := range m {
+		out[i] = h.WithGroup(name)
+	}
+	return out
+}
@@ -1,10 +1,13 @@
 package logutil
 
 import (
+	"context"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"os"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/fatih/color"
@@ -12,119 +15,174 @@ import (
 	"gopkg.in/natefinch/lumberjack.v2"
 )
 
+// Log file formats.
+const (
+	FormatText = "text" // One "[timestamp] message" line per record
+	FormatJSON = "json" // One JSON object per record, for log shippers such as Promtail
+)
+
+// Levels besides the slog defaults. Success is informational, Fatal ends the process.
+const (
+	LevelSuccess = slog.LevelInfo + 2
+	LevelFatal   = slog.LevelError + 4
+)
+
+// PhaseSummary is the phase of the final summary of a run, the only output in quiet mode.
+const PhaseSummary = "summary"
+
+// Options configures the loggers.
+type Options struct {
+	LogFile      string
+	ConsoleLevel string // debug, info, warn or error; see ParseLevel
+	FileLevel    string
+	Quiet        bool // Only write the summary of a run to the console
+	MaxSizeMB    int  // Rotation settings of the log file
+	MaxBackups   int
+	MaxAgeDays   int
+	Compress     bool
+	Format       string // FormatText or FormatJSON, only affects the file
+}
+
 var (
-	// File Loggers
-	infoFileLogger    *log.Logger
-	warnFileLogger    *log.Logger
-	errorFileLogger   *log.Logger
-	debugFileLogger   *log.Logger
-	fatalFileLogger   *log.Logger
-	successFileLogger *log.Logger
-
-	// Console Loggers
-	infoConsoleLogger    *log.Logger
-	warnConsoleLogger    *log.Logger
-	errorConsoleLogger   *log.Logger
-	debugConsoleLogger   *log.Logger
-	fatalConsoleLogger   *log.Logger
-	successConsoleLogger *log.Logger
+	// logger writes every record to the console and, after Init, to the log file.
+	// Before Init only the console is used so early messages are not lost.
+	logger = slog.New(newConsoleHandler(os.Stdout, os.Stderr, slog.LevelInfo, false))
 
 	logFileHandle io.WriteCloser // Keep handle to close later if needed
 
-	verboseMode bool
+	consoleOutput = os.Stdout // Where console loggers write, see ConsoleToStderr
+
+	fileFormat = FormatText // Format of the log file, also used for project logs
 
-	// Color functions
-	colorInfo    = color.New(color.FgBlue).SprintfFunc()
-	colorWarn    = color.New(color.FgYellow).SprintfFunc()
-	colorError   = color.New(color.FgRed).SprintfFunc()
-	colorDebug   = color.New(color.FgMagenta).SprintfFunc()
-	colorSuccess = color.New(color.FgGreen).SprintfFunc()
-	colorFatal   = color.New(color.FgRed, color.Bold).SprintfFunc()
+	// Fields added to every record, see SetRun, SetProject and SetPhase
+	fieldsMu sync.Mutex
+	fields   struct{ run, project, phase string }
 )
 
 // Init initializes the logging system based on the provided configuration parameters.
-func Init(logFile string, verbose bool, maxSizeMB, maxBackups, maxAgeDays int, compress bool) {
-	verboseMode = verbose
+func Init(opts Options) {
+	consoleLevel, err := ParseLevel(opts.ConsoleLevel)
+	if err != nil {
+		consoleLevel = slog.LevelInfo
+	}
+	fileLevel, err := ParseLevel(opts.FileLevel)
+	if err != nil {
+		fileLevel = slog.LevelInfo
+	}
 
 	// --- Setup File Writer (Lumberjack) ---
 	fileWriter := &lumberjack.Logger{
-		Filename:   logFile,
-		MaxSize:    maxSizeMB, // megabytes
-		MaxBackups: maxBackups,
-		MaxAge:     maxAgeDays, // days
-		Compress:   compress,
+		Filename:   opts.LogFile,
+		MaxSize:    opts.MaxSizeMB, // megabytes
+		MaxBackups: opts.MaxBackups,
+		MaxAge:     opts.MaxAgeDays, // days
+		Compress:   opts.Compress,
 		LocalTime:  true, // Use local time for timestamps in backups
 	}
 	logFileHandle = fileWriter // Store for potential Close() later
+	fileFormat = opts.Format
 
 	// --- Setup Console Writer (with Color detection) ---
-	consoleWriter := io.Writer(os.Stdout)
-	isTerm := isatty.IsTerminal(os.Stdout.Fd())
-	color.NoColor = !isTerm // Disable color if not a TTY
-
-	// --- Combine Writers ---
-	// File output always gets standard log prefixes
-	// Console output gets color prefixes, no standard flags initially
-	consoleFlags := log.Ldate | log.Ltime // Keep standard timestamp for console
-
-	// --- Create File Loggers (No flags, manual timestamp) ---
-	infoFileLogger = log.New(fileWriter, "", 0)
-	warnFileLogger = log.New(fileWriter, "", 0)
-	errorFileLogger = log.New(fileWriter, "", 0)
-	successFileLogger = log.New(fileWriter, "", 0)
-	debugFileLogger = log.New(fileWriter, "", 0)
-	fatalFileLogger = log.New(fileWriter, "", 0)
-
-	// --- Create Console Loggers (Standard flags, manual color prefix) ---
-	infoConsoleLogger = log.New(consoleWriter, "", consoleFlags)
-	warnConsoleLogger = log.New(consoleWriter, "", consoleFlags)
-	errorConsoleLogger = log.New(consoleWriter, "", consoleFlags)
-	successConsoleLogger = log.New(consoleWriter, "", consoleFlags)
-	debugConsoleLogger = log.New(consoleWriter, "", consoleFlags)
-	fatalConsoleLogger = log.New(consoleWriter, "", consoleFlags)
+	isTerm := isatty.IsTerminal(consoleOutput.Fd())
+	color.NoColor = !useColor(isTerm)
+
+	// The console keeps the coloured human format, the file gets text or JSON
+	logger = slog.New(multiHandler{
+		newConsoleHandler(consoleOutput, os.Stderr, consoleLevel, opts.Quiet),
+		newFileHandler(fileWriter, fileLevel),
+	})
 
 	// Initial message
-	Info("Logging initialized. File: %s, Verbose: %v, TTY: %v", logFile, verboseMode, isTerm)
-	// Redirect standard log output to our logger if possible?
-	// log.SetOutput(infoLogger.Writer()) // This might mess up prefixes/colors
+	Info("Logging initialized. File: %s, Console level: %s, File level: %s, TTY: %v",
+		opts.LogFile, levelName(consoleLevel), levelName(fileLevel), isTerm)
+}
+
+// ParseLevel parses the level names accepted by log_level: debug, info, warn and error.
+func ParseLevel(s string) (slog.Level, error) {
+	switch strings.ToLower(s) {
+	case "debug":
+		return slog.LevelDebug, nil
+	case "info", "":
+		return slog.LevelInfo, nil
+	case "warn", "warning":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	default:
+		return slog.LevelInfo, fmt.Errorf("unknown log level '%s': must be debug, info, warn or error", s)
+	}
+}
+
+// useColor decides whether console output is coloured: NO_COLOR disables colour,
+// FORCE_COLOR enables it when the console is not a terminal (e.g. docker logs, CI).
+// See https://no-color.org and https://force-color.org.
+func useColor(isTerm bool) bool {
+	if os.Getenv("NO_COLOR") != "" {
+		return false
+	}
+	if v := os.Getenv("FORCE_COLOR"); v != "" && v != "0" && v != "false" {
+		return true
+	}
+	return isTerm
+}
+
+// ConsoleToStderr sends console output to stderr, for commands whose stdout is data
+// (tables, JSON) that may be piped into other tools. Call it before Init.
+func ConsoleToStderr() {
+	consoleOutput = os.Stderr
+	logger = slog.New(newConsoleHandler(consoleOutput, os.Stderr, slog.LevelInfo, false))
+}
+
+// SetRun sets the run ID added to every following record and resets project and
+// phase. An empty ID removes all three.
+func SetRun(id string) {
+	fieldsMu.Lock()
+	defer fieldsMu.Unlock()
+	fields.run, fields.project, fields.phase = id, "", ""
+}
+
+// SetProject sets the project added to every following record and resets the phase.
+func SetProject(name string) {
+	fieldsMu.Lock()
+	defer fieldsMu.Unlock()
+	fields.project, fields.phase = name, ""
+}
+
+// SetPhase sets the step of the run (stop, archive, transfer, ...) added to every following record.
+func SetPhase(phase string) {
+	fieldsMu.Lock()
+	defer fieldsMu.Unlock()
+	fields.phase = phase
 }
 
 // Info logs an informational message.
 func Info(format string, v ...interface{}) {
-	logToFile(infoFileLogger, format, v...)
-	logToConsole(infoConsoleLogger, colorInfo, "[INFO] ", format, v...)
+	logAt(slog.LevelInfo, format, v...)
 }
 
 // Warn logs a warning message.
 func Warn(format string, v ...interface{}) {
-	logToFile(warnFileLogger, format, v...)
-	logToConsole(warnConsoleLogger, colorWarn, "[WARN] ", format, v...)
+	logAt(slog.LevelWarn, format, v...)
 }
 
 // Error logs an error message.
 func Error(format string, v ...interface{}) {
-	logToFile(errorFileLogger, format, v...)
-	logToConsole(errorConsoleLogger, colorError, "[ERROR] ", format, v...)
+	logAt(slog.LevelError, format, v...)
 }
 
 // Success logs a success message.
 func Success(format string, v ...interface{}) {
-	logToFile(successFileLogger, format, v...)
-	logToConsole(successConsoleLogger, colorSuccess, "[SUCCESS] ", format, v...)
+	logAt(LevelSuccess, format, v...)
 }
 
-// Debug logs a debug message only if verbose mode is enabled.
+// Debug logs a debug message; handlers below the debug level drop it.
 func Debug(format string, v ...interface{}) {
-	if verboseMode {
-		logToFile(debugFileLogger, format, v...)
-		logToConsole(debugConsoleLogger, colorDebug, "[DEBUG] ", format, v...)
-	}
+	logAt(slog.LevelDebug, format, v...)
 }
 
 // Fatal logs an error message and exits the application.
 func Fatal(format string, v ...interface{}) {
-	logToFile(fatalFileLogger, format, v...)
-	logToConsole(fatalConsoleLogger, colorFatal, "[FATAL] ", format, v...)
+	logAt(LevelFatal, format, v...)
 	os.Exit(1)
 }
 
@@ -138,15 +196,71 @@ func Close() {
 
 // -- Helper Functions --
 
-// logToFile handles formatting for the file logger
-func logToFile(logger *log.Logger, format string, v ...interface{}) {
-	now := time.Now()
-	timestamp := now.Format("[2006/01/02 03:04:05 PM]") // Custom 12-hour format with brackets
-	logger.Printf("%s %s", timestamp, fmt.Sprintf(format, v...))
+// newFileHandler returns a handler writing records to w in the log file format.
+func newFileHandler(w io.Writer, level slog.Leveler) slog.Handler {
+	if fileFormat == FormatJSON {
+		return slog.NewJSONHandler(w, &slog.HandlerOptions{Level: level, ReplaceAttr: replaceLevel})
+	}
+	return newTextHandler(w, level)
 }
 
-// logToConsole handles formatting for the console logger
-func logToConsole(logger *log.Logger, colorFunc func(format string, a ...interface{}) string, prefix string, format string, v ...interface{}) {
-	// Console logger already has Ldate|Ltime flags set
-	logger.Printf("%s %s", colorFunc(prefix), fmt.Sprintf(format, v...))
+// logAt formats the message and adds the run fields and the first error argument as attributes.
+func logAt(level slog.Level, format string, v ...interface{}) {
+	ctx := context.Background()
+	project := projectHandler()
+	if !logger.Enabled(ctx, level) && project == nil {
+		return
+	}
+	r := newRecord(level, fmt.Sprintf(format, v...))
+	for _, arg := range v {
+		if err, ok := arg.(error); ok && err != nil {
+			r.AddAttrs(slog.String("error", err.Error()))
+			break
+		}
+	}
+	if logger.Enabled(ctx, level) {
+		logger.Handler().Handle(ctx, r)
+	}
+	if project != nil {
+		project.Handle(ctx, r)
+	}
+}
+
+// newRecord creates a record carrying the run fields.
+func newRecord(level slog.Level, msg string) slog.Record {
+	r := slog.NewRecord(time.Now(), level, msg, 0)
+	fieldsMu.Lock()
+	if fields.run != "" {
+		r.AddAttrs(slog.String("run_id", fields.run))
+	}
+	if fields.project != "" {
+		r.AddAttrs(slog.String("project", fields.project))
+	}
+	if fields.phase != "" {
+		r.AddAttrs(slog.String("phase", fields.phase))
+	}
+	fieldsMu.Unlock()
+	return r
+}
+
+// levelName returns the name used in log output, including the custom levels.
+func levelName(l slog.Level) string {
+	switch l {
+	case LevelSuccess:
+		return "SUCCESS"
+	case LevelFatal:
+		return "FATAL"
+	default:
+		return l.String()
+	}
+}
+
+// replaceLevel writes the custom levels by name in JSON records.
+func replaceLevel(groups []string, a slog.Attr) slog.Attr {
+	if a.Key == slog.LevelKey && len(groups) == 0 {
+		if l, ok := a.Value.Any().(slog.Level); ok {
+			a.Value = slog.StringValue(levelName(l))
+		}
+	}
+	return a
 }
@@ -0,0 +1,90 @@
+package logutil
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// The project log receives every record logged while it is open, debug records
+// included, and the output of the commands run for the project.
+var (
+	projectMu   sync.Mutex
+	projectFile *os.File
+	projectH    slog.Handler
+)
+
+// StartProjectLog opens a log for the project being processed, replacing any
+// previous one. The file is written in the format of the main log file.
+func StartProjectLog(path string) error {
+	StopProjectLog()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create project log directory: %w", err)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create project log: %w", err)
+	}
+	projectMu.Lock()
+	defer projectMu.Unlock()
+	projectFile = f
+	projectH = newFileHandler(f, slog.LevelDebug)
+	return nil
+}
+
+// StopProjectLog closes the project log. It does nothing if none is open.
+func StopProjectLog() error {
+	projectMu.Lock()
+	defer projectMu.Unlock()
+	if projectFile == nil {
+		return nil
+	}
+	err := projectFile.Close()
+	projectFile, projectH = nil, nil
+	return err
+}
+
+// CommandOutput writes the captured output of an external command to the project log.
+// The main log only gets the command line at the debug level, so this is where the output
+// of a failed docker compose or rsync call can be looked up later.
+func CommandOutput(args []string, stdout, stderr string, err error) {
+	h := projectHandler()
+	if h == nil {
+		return
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "Command: %s", strings.Join(args, " "))
+	if err != nil {
+		fmt.Fprintf(&b, " (%v)", err)
+	}
+	writeStream(&b, "stdout", stdout)
+	writeStream(&b, "stderr", stderr)
+	r := newRecord(slog.LevelDebug, b.String())
+	r.AddAttrs(slog.String("command", strings.Join(args, " ")))
+	if err != nil {
+		r.AddAttrs(slog.String("error", err.Error()))
+	}
+	h.Handle(context.Background(), r)
+}
+
+// writeStream appends a stream of command output, each line indented below a header.
+func writeStream(b *strings.Builder, name, output string) {
+	output = strings.TrimRight(output, "\n")
+	if output == "" {
+		return
+	}
+	fmt.Fprintf(b, "\n  %s:", name)
+	for _, line := range strings.Split(output, "\n") {
+		b.WriteString("\n    " + line)
+	}
+}
+
+func projectHandler() slog.Handler {
+	projectMu.Lock()
+	defer projectMu.Unlock()
+	return projectH
+}
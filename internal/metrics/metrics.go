@@ -0,0 +1,235 @@
+package metrics
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"docker-backup-tool/internal/report"
+)
+
+// Project holds the metrics of one project. Values that describe a backup
+// (archive size, files) come from the last successful run, so a failure does
+// not reset them to zero.
+type Project struct {
+	LastRun          time.Time        `json:"last_run"`
+	LastSuccess      time.Time        `json:"last_success"`
+	LastRunSuccess   bool             `json:"last_run_success"`
+	Duration         time.Duration    `json:"duration"`
+	Downtime         time.Duration    `json:"downtime"`
+	ArchiveSize      int64            `json:"archive_size"`
+	Files            int              `json:"files"`
+	TransferredBytes map[string]int64 `json:"transferred_bytes"` // Per destination, last run
+	Failures         int              `json:"failures"`          // Failed runs since the state file was created
+}
+
+// Store keeps the metrics of all projects. It is persisted to a state file so
+// timestamps and counters survive between runs, which is what alerts such as
+// "no successful backup in 48 hours" rely on.
+type Store struct {
+	mu       sync.Mutex
+	path     string
+	LastRun  time.Time           `json:"last_run"`
+	Projects map[string]*Project `json:"projects"`
+}
+
+// Open reads the state file. A missing file starts an empty store.
+func Open(path string) (*Store, error) {
+	s := &Store{path: path, Projects: make(map[string]*Project)}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, s); err != nil {
+		return nil, fmt.Errorf("invalid metrics state file '%s': %w", path, err)
+	}
+	if s.Projects == nil {
+		s.Projects = make(map[string]*Project)
+	}
+	return s, nil
+}
+
+// Update records the outcome of a run.
+func (s *Store) Update(r *report.Report) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.LastRun = r.End
+	for _, rp := range r.Projects {
+		p := s.Projects[rp.Name]
+		if p == nil {
+			p = &Project{}
+			s.Projects[rp.Name] = p
+		}
+		p.LastRun = rp.Start.Add(rp.Duration)
+		p.LastRunSuccess = rp.Success
+		p.Duration = rp.Duration
+		p.Downtime = rp.Downtime
+		p.TransferredBytes = make(map[string]int64)
+		for _, t := range rp.Transfers {
+			p.TransferredBytes[t.Destination] += t.Bytes
+		}
+		if rp.Success {
+			p.LastSuccess = p.LastRun
+			p.ArchiveSize = rp.ArchiveSize
+			p.Files = rp.Files
+		} else {
+			p.Failures++
+		}
+	}
+}
+
+// Save writes the state file.
+func (s *Store) Save() error {
+	s.mu.Lock()
+	data, err := json.MarshalIndent(s, "", "  ")
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return writeAtomic(s.path, data)
+}
+
+// WriteTextfile writes the metrics for the node_exporter textfile collector. The
+// file is replaced atomically so the collector never reads a partial file.
+func (s *Store) WriteTextfile(path string) error {
+	var b strings.Builder
+	if err := s.Write(&b); err != nil {
+		return err
+	}
+	return writeAtomic(path, []byte(b.String()))
+}
+
+// Handler serves the metrics in the Prometheus text format.
+func (s *Store) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		s.Write(w)
+	})
+}
+
+// metric describes one exported metric family.
+type metric struct {
+	name  string
+	typ   string
+	help  string
+	value func(p *Project) float64
+}
+
+var projectMetrics = []metric{
+	{"docker_backup_last_run_timestamp_seconds", "gauge", "Time the last backup of the project finished.",
+		func(p *Project) float64 { return unix(p.LastRun) }},
+	{"docker_backup_last_success_timestamp_seconds", "gauge", "Time the last successful backup of the project finished.",
+		func(p *Project) float64 { return unix(p.LastSuccess) }},
+	{"docker_backup_last_run_success", "gauge", "Whether the last backup of the project succeeded (1) or failed (0).",
+		func(p *Project) float64 { return boolValue(p.LastRunSuccess) }},
+	{"docker_backup_last_run_duration_seconds", "gauge", "Duration of the last backup of the project.",
+		func(p *Project) float64 { return p.Duration.Seconds() }},
+	{"docker_backup_downtime_seconds", "gauge", "Time the stack was down during the last backup.",
+		func(p *Project) float64 { return p.Downtime.Seconds() }},
+	{"docker_backup_archive_size_bytes", "gauge", "Size of the last successful archive.",
+		func(p *Project) float64 { return float64(p.ArchiveSize) }},
+	{"docker_backup_archive_files", "gauge", "Number of files in the last successful archive.",
+		func(p *Project) float64 { return float64(p.Files) }},
+	{"docker_backup_failures_total", "counter", "Number of failed backups of the project.",
+		func(p *Project) float64 { return float64(p.Failures) }},
+}
+
+// Write writes all metrics in the Prometheus text format.
+func (s *Store) Write(w io.Writer) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	names := make([]string, 0, len(s.Projects))
+	for name := range s.Projects {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	bw := bufio.NewWriter(w)
+	for _, m := range projectMetrics {
+		fmt.Fprintf(bw, "# HELP %s %s\n# TYPE %s %s\n", m.name, m.help, m.name, m.typ)
+		for _, name := range names {
+			fmt.Fprintf(bw, "%s{project=\"%s\"} %s\n", m.name, escapeLabel(name), formatValue(m.value(s.Projects[name])))
+		}
+	}
+
+	fmt.Fprintf(bw, "# HELP docker_backup_transferred_bytes Bytes transferred to each destination in the last backup of the project.\n")
+	fmt.Fprintf(bw, "# TYPE docker_backup_transferred_bytes gauge\n")
+	for _, name := range names {
+		p := s.Projects[name]
+		destinations := make([]string, 0, len(p.TransferredBytes))
+		for d := range p.TransferredBytes {
+			destinations = append(destinations, d)
+		}
+		sort.Strings(destinations)
+		for _, d := range destinations {
+			fmt.Fprintf(bw, "docker_backup_transferred_bytes{project=\"%s\",destination=\"%s\"} %d\n", escapeLabel(name), escapeLabel(d), p.TransferredBytes[d])
+		}
+	}
+
+	fmt.Fprintf(bw, "# HELP docker_backup_run_timestamp_seconds Time the last backup run finished.\n")
+	fmt.Fprintf(bw, "# TYPE docker_backup_run_timestamp_seconds gauge\n")
+	fmt.Fprintf(bw, "docker_backup_run_timestamp_seconds %s\n", formatValue(unix(s.LastRun)))
+	return bw.Flush()
+}
+
+// formatValue avoids the exponent notation of %g for timestamps and sizes.
+func formatValue(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+func unix(t time.Time) float64 {
+	if t.IsZero() {
+		return 0
+	}
+	return float64(t.Unix())
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// escapeLabel escapes a label value for the text format.
+func escapeLabel(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+}
+
+// writeAtomic writes data to a temporary file next to path and renames it into place.
+func writeAtomic(path string, data []byte) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	// CreateTemp uses 0600, the collector may run as another user
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,181 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"docker-backup-tool/internal/config"
+)
+
+func newHTTPClient() *http.Client {
+	return &http.Client{Timeout: sendTimeout}
+}
+
+// post sends body to url and fails on any status other than 2xx.
+func post(ctx context.Context, client *http.Client, url, contentType string, body []byte, header http.Header) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	req.Header.Set("Content-Type", contentType)
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	io.Copy(io.Discard, resp.Body)
+	return nil
+}
+
+func postJSON(ctx context.Context, client *http.Client, url string, payload interface{}, header http.Header) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	return post(ctx, client, url, "application/json", body, header)
+}
+
+func basicAuth(username, password string) string {
+	return "Basic " + base64.StdEncoding.EncodeToString([]byte(username+":"+password))
+}
+
+// truncate shortens s to at most n runes for services with message size limits.
+func truncate(s string, n int) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	return string(r[:n-1]) + "…"
+}
+
+// Webhook posts the message and the full run report as JSON to a URL.
+type Webhook struct {
+	name   string
+	cfg    config.NotifierConfig
+	client *http.Client
+}
+
+func (w *Webhook) Name() string { return w.name }
+
+func (w *Webhook) Send(ctx context.Context, msg Message) error {
+	header := make(http.Header)
+	for k, v := range w.cfg.Headers {
+		header.Set(k, v)
+	}
+	if w.cfg.Username != "" {
+		header.Set("Authorization", basicAuth(w.cfg.Username, w.cfg.Password))
+	}
+	payload := struct {
+		Title   string      `json:"title"`
+		Message string      `json:"message"`
+		Success bool        `json:"success"`
+		Report  interface{} `json:"report"`
+	}{msg.Title, msg.Body, msg.Success, msg.Report}
+	return postJSON(ctx, w.client, w.cfg.URL, payload, header)
+}
+
+// Ntfy publishes to an ntfy topic URL such as https://ntfy.sh/my-backups.
+type Ntfy struct {
+	name   string
+	cfg    config.NotifierConfig
+	client *http.Client
+}
+
+func (n *Ntfy) Name() string { return n.name }
+
+func (n *Ntfy) Send(ctx context.Context, msg Message) error {
+	header := make(http.Header)
+	header.Set("Title", msg.Title)
+	priority, tags := n.cfg.Priority, "white_check_mark"
+	if !msg.Success {
+		tags = "rotating_light"
+		if priority == 0 {
+			priority = 4 // high
+		}
+	}
+	if priority != 0 {
+		header.Set("Priority", strconv.Itoa(priority))
+	}
+	header.Set("Tags", tags)
+	switch {
+	case n.cfg.Token != "":
+		header.Set("Authorization", "Bearer "+n.cfg.Token)
+	case n.cfg.Username != "":
+		header.Set("Authorization", basicAuth(n.cfg.Username, n.cfg.Password))
+	}
+	return post(ctx, n.client, n.cfg.URL, "text/plain; charset=utf-8", []byte(msg.Body), header)
+}
+
+// Gotify sends to a Gotify server with an application token.
+type Gotify struct {
+	name   string
+	cfg    config.NotifierConfig
+	client *http.Client
+}
+
+func (g *Gotify) Name() string { return g.name }
+
+func (g *Gotify) Send(ctx context.Context, msg Message) error {
+	priority := g.cfg.Priority
+	if priority == 0 {
+		priority = 4
+		if !msg.Success {
+			priority = 8
+		}
+	}
+	header := make(http.Header)
+	header.Set("X-Gotify-Key", g.cfg.Token)
+	payload := map[string]interface{}{"title": msg.Title, "message": msg.Body, "priority": priority}
+	return postJSON(ctx, g.client, strings.TrimSuffix(g.cfg.URL, "/")+"/message", payload, header)
+}
+
+// Discord posts an embed to a Discord webhook URL.
+type Discord struct {
+	name   string
+	cfg    config.NotifierConfig
+	client *http.Client
+}
+
+func (d *Discord) Name() string { return d.name }
+
+func (d *Discord) Send(ctx context.Context, msg Message) error {
+	color := 0x2ecc71 // green
+	if !msg.Success {
+		color = 0xe74c3c // red
+	}
+	// Embed limits: 256 characters for the title, 4096 for the description
+	embed := map[string]interface{}{
+		"title":       truncate(msg.Title, 256),
+		"description": "```\n" + truncate(msg.Body, 4000) + "\n```",
+		"color":       color,
+	}
+	return postJSON(ctx, d.client, d.cfg.URL, map[string]interface{}{"embeds": []interface{}{embed}}, nil)
+}
+
+// Slack posts to a Slack incoming webhook URL.
+type Slack struct {
+	name   string
+	cfg    config.NotifierConfig
+	client *http.Client
+}
+
+func (s *Slack) Name() string { return s.name }
+
+func (s *Slack) Send(ctx context.Context, msg Message) error {
+	text := "*" + msg.Title + "*\n```\n" + truncate(msg.Body, 3500) + "\n```"
+	return postJSON(ctx, s.client, s.cfg.URL, map[string]string{"text": text}, nil)
+}
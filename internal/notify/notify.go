@@ -0,0 +1,259 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"docker-backup-tool/internal/config"
+	"docker-backup-tool/internal/logutil"
+	"docker-backup-tool/internal/report"
+)
+
+// sendTimeout limits how long a single provider may take to deliver a message.
+const sendTimeout = 30 * time.Second
+
+// Message is a rendered notification.
+type Message struct {
+	Title   string
+	Body    string
+	Success bool
+	Report  *report.Report // The run being reported, sent as-is by the webhook provider
+}
+
+// Provider delivers messages to one service.
+type Provider interface {
+	// Name identifies the provider in logs.
+	Name() string
+	Send(ctx context.Context, msg Message) error
+}
+
+// Notifier renders the run summary and sends it to every provider whose trigger matches.
+type Notifier struct {
+	cfg       config.NotifyConfig
+	templates *templates
+	providers []entry
+}
+
+type entry struct {
+	provider Provider
+	trigger  string
+}
+
+// New creates the providers described by cfg and parses the message templates.
+// It returns nil if no provider is configured.
+func New(cfg config.NotifyConfig) (*Notifier, error) {
+	if len(cfg.Providers) == 0 {
+		return nil, nil
+	}
+	t, err := parseTemplates(cfg.Title, cfg.Body)
+	if err != nil {
+		return nil, err
+	}
+	n := &Notifier{cfg: cfg, templates: t}
+	for _, pc := range cfg.Providers {
+		p, err := NewProvider(pc)
+		if err != nil {
+			return nil, err
+		}
+		trigger := pc.Trigger
+		if trigger == "" {
+			trigger = cfg.Trigger
+		}
+		n.providers = append(n.providers, entry{provider: p, trigger: trigger})
+	}
+	return n, nil
+}
+
+// NewProvider creates the provider described by pc.
+func NewProvider(pc config.NotifierConfig) (Provider, error) {
+	switch pc.Type {
+	case config.NotifierWebhook:
+		return &Webhook{name: pc.Name, cfg: pc, client: newHTTPClient()}, nil
+	case config.NotifierNtfy:
+		return &Ntfy{name: pc.Name, cfg: pc, client: newHTTPClient()}, nil
+	case config.NotifierGotify:
+		return &Gotify{name: pc.Name, cfg: pc, client: newHTTPClient()}, nil
+	case config.NotifierDiscord:
+		return &Discord{name: pc.Name, cfg: pc, client: newHTTPClient()}, nil
+	case config.NotifierSlack:
+		return &Slack{name: pc.Name, cfg: pc, client: newHTTPClient()}, nil
+	case config.NotifierSMTP:
+		return NewSMTP(pc.Name, pc.SMTP), nil
+	default:
+		return nil, fmt.Errorf("notifier '%s': unknown type '%s'", pc.Name, pc.Type)
+	}
+}
+
+// Notify sends the summary of a finished run. Providers are tried independently;
+// the returned error joins the failures. The state file is only updated when a due
+// notification was delivered by at least one provider, or when none was due, so a
+// status change is reported again on the next run if every provider failed. With
+// dryRun nothing is sent and the state file is left alone.
+func (n *Notifier) Notify(ctx context.Context, r *report.Report, dryRun bool) error {
+	previous, err := loadState(n.cfg.StateFile)
+	if err != nil {
+		logutil.Warn("Cannot read notification state %s: %v. Treating this run as a status change.", n.cfg.StateFile, err)
+	}
+	current := newState(r, previous)
+	changed := current.differs(previous)
+
+	msg, err := n.templates.render(r, changed)
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	due, delivered := 0, 0
+	for _, e := range n.providers {
+		if !shouldSend(e.trigger, r.Success(), changed) {
+			logutil.Debug("Skipping notification via '%s' (trigger: %s)", e.provider.Name(), e.trigger)
+			continue
+		}
+		if dryRun {
+			logutil.Info("[DRY RUN] Would send notification '%s' via '%s'.", msg.Title, e.provider.Name())
+			continue
+		}
+		due++
+		if err := send(ctx, e.provider, msg); err != nil {
+			errs = append(errs, err)
+		} else {
+			delivered++
+		}
+	}
+
+	if !dryRun && due > 0 && delivered == 0 {
+		logutil.Warn("No notification was delivered, keeping the previous notification state so this run is reported again.")
+	} else if !dryRun {
+		if err := current.save(n.cfg.StateFile); err != nil {
+			logutil.Warn("Cannot write notification state %s: %v", n.cfg.StateFile, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Test sends a sample report to every provider regardless of its trigger.
+func (n *Notifier) Test(ctx context.Context) error {
+	r := sampleReport()
+	msg, err := n.templates.render(r, true)
+	if err != nil {
+		return err
+	}
+	msg.Title = "[TEST] " + msg.Title
+	var errs []error
+	for _, e := range n.providers {
+		if err := send(ctx, e.provider, msg); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func send(ctx context.Context, p Provider, msg Message) error {
+	ctx, cancel := context.WithTimeout(ctx, sendTimeout)
+	defer cancel()
+	if err := p.Send(ctx, msg); err != nil {
+		logutil.Error("Notification via '%s' failed: %v", p.Name(), err)
+		return fmt.Errorf("notification via '%s': %w", p.Name(), err)
+	}
+	logutil.Info("Notification sent via '%s'.", p.Name())
+	return nil
+}
+
+// shouldSend applies a trigger to the outcome of a run.
+func shouldSend(trigger string, success, changed bool) bool {
+	switch trigger {
+	case config.NotifyAlways:
+		return true
+	case config.NotifyChange:
+		return changed
+	default:
+		return !success
+	}
+}
+
+// state is the status of a run, kept between runs for trigger: change.
+type state struct {
+	Time     time.Time       `json:"time"`
+	Success  bool            `json:"success"`
+	Projects map[string]bool `json:"projects"`
+}
+
+// newState returns the state after the run r. Projects the run did not include, e.g.
+// in a --project run, keep their status from the previous state.
+func newState(r *report.Report, previous *state) *state {
+	s := &state{Time: r.End, Success: r.Success(), Projects: make(map[string]bool)}
+	if previous != nil {
+		for name, ok := range previous.Projects {
+			s.Projects[name] = ok
+		}
+	}
+	for _, p := range r.Projects {
+		s.Projects[p.Name] = p.Success
+	}
+	return s
+}
+
+// differs reports whether the overall status or the status of a project seen in
+// both runs changed. Without a previous state every run counts as a change.
+func (s *state) differs(previous *state) bool {
+	if previous == nil || s.Success != previous.Success {
+		return true
+	}
+	for name, ok := range s.Projects {
+		if was, seen := previous.Projects[name]; seen && was != ok {
+			return true
+		}
+	}
+	return false
+}
+
+// loadState reads the previous state. A missing file returns nil without error.
+func loadState(path string) (*state, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var s state
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+func (s *state) save(path string) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// sampleReport is the run reported by Test: one successful and one failed project.
+func sampleReport() *report.Report {
+	r := report.New(false)
+	ok := r.AddProject("example-app")
+	ok.Archive = "example-app_" + r.Start.Format("20060102") + ".zip"
+	ok.ArchiveSize = 42 << 20
+	ok.Duration = 75 * time.Second
+	ok.Transfers = []report.Transfer{{Destination: "rsync", Files: 1, Bytes: 42 << 20, Duration: 12 * time.Second, Attempts: 1}}
+	failed := r.AddProject("example-db")
+	failed.Duration = 3 * time.Second
+	failed.Fail("stack still running after 'down'")
+	r.Finish()
+	return r
+}
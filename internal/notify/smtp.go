@@ -0,0 +1,112 @@
+package notify
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+
+	"docker-backup-tool/internal/config"
+)
+
+// SMTP sends notifications as plain text mail.
+type SMTP struct {
+	name string
+	cfg  config.SMTPConfig
+}
+
+// NewSMTP creates an SMTP provider, filling in the default port and security.
+func NewSMTP(name string, cfg config.SMTPConfig) *SMTP {
+	if cfg.Security == "" {
+		cfg.Security = config.SMTPStartTLS
+	}
+	if cfg.Port == 0 {
+		cfg.Port = 587
+		if cfg.Security == config.SMTPTLS {
+			cfg.Port = 465
+		}
+	}
+	return &SMTP{name: name, cfg: cfg}
+}
+
+func (s *SMTP) Name() string { return s.name }
+
+func (s *SMTP) Send(ctx context.Context, msg Message) error {
+	addr := net.JoinHostPort(s.cfg.Host, strconv.Itoa(s.cfg.Port))
+	tlsConfig := &tls.Config{ServerName: s.cfg.Host}
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	if s.cfg.Security == config.SMTPTLS {
+		conn = tls.Client(conn, tlsConfig)
+	}
+	c, err := smtp.NewClient(conn, s.cfg.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+
+	if s.cfg.Security == config.SMTPStartTLS {
+		if ok, _ := c.Extension("STARTTLS"); !ok {
+			return fmt.Errorf("server %s does not support STARTTLS (set smtp.security to 'tls' or 'none')", addr)
+		}
+		if err := c.StartTLS(tlsConfig); err != nil {
+			return err
+		}
+	}
+	if s.cfg.Username != "" {
+		// PlainAuth refuses to send the password over an unencrypted connection except to localhost
+		if err := c.Auth(smtp.PlainAuth("", s.cfg.Username, s.cfg.Password, s.cfg.Host)); err != nil {
+			return fmt.Errorf("authentication failed: %w", err)
+		}
+	}
+	if err := c.Mail(s.cfg.From); err != nil {
+		return err
+	}
+	for _, to := range s.cfg.To {
+		if err := c.Rcpt(to); err != nil {
+			return fmt.Errorf("recipient %s rejected: %w", to, err)
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(s.message(msg)); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// message builds the mail headers and body with CRLF line endings.
+func (s *SMTP) message(msg Message) []byte {
+	var b strings.Builder
+	b.WriteString("From: " + s.cfg.From + "\r\n")
+	b.WriteString("To: " + strings.Join(s.cfg.To, ", ") + "\r\n")
+	b.WriteString("Subject: " + mime.QEncoding.Encode("utf-8", msg.Title) + "\r\n")
+	b.WriteString("Date: " + time.Now().Format(time.RFC1123Z) + "\r\n")
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("Content-Transfer-Encoding: 8bit\r\n")
+	b.WriteString("\r\n")
+	// Leading dots are escaped by the writer returned from Data
+	for _, line := range strings.Split(msg.Body, "\n") {
+		b.WriteString(strings.TrimRight(line, "\r") + "\r\n")
+	}
+	return []byte(b.String())
+}
@@ -0,0 +1,88 @@
+package notify
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+
+	"docker-backup-tool/internal/report"
+	"docker-backup-tool/internal/util"
+)
+
+// defaultTitle and defaultBody are used when notify.title or notify.body is empty.
+const defaultTitle = `Backup on {{.Hostname}} {{if .Success}}succeeded{{else}}FAILED{{end}}: {{.Succeeded}}/{{len .Projects}} projects OK`
+
+const defaultBody = `{{range .Projects -}}
+{{if .Success}}OK    {{else}}FAILED{{end}} {{.Name}} ({{duration .Duration}}){{if .Archive}} {{.Archive}}, {{bytes .ArchiveSize}}{{end}}
+{{- if .Error}}
+       {{.Error}}{{end}}
+{{- range .Transfers}}
+       -> {{.Destination}}: {{if .Error}}FAILED: {{.Error}}{{else}}{{bytes .Bytes}} in {{duration .Duration}}{{end}}{{end}}
+{{end -}}
+{{range .Transfers}}backup directory -> {{.Destination}}: {{if .Error}}FAILED: {{.Error}}{{else}}{{bytes .Bytes}} in {{duration .Duration}}{{end}}
+{{end -}}
+Duration: {{duration .Duration}}{{if .DryRun}} (dry run){{end}}`
+
+// Data is passed to the title and body templates. The fields and methods of
+// report.Report (Projects, Transfers, Start, End, DryRun, Succeeded, Failed)
+// are available directly.
+type Data struct {
+	*report.Report
+	Success  bool          // No project or transfer failed
+	Changed  bool          // Status differs from the previous run
+	Hostname string        // Host the backup ran on
+	Duration time.Duration // Length of the whole run
+}
+
+// templateFuncs are available in notification templates.
+var templateFuncs = template.FuncMap{
+	"bytes":    util.FormatBytes,
+	"duration": func(d time.Duration) string { return d.Round(time.Second).String() },
+	"upper":    strings.ToUpper,
+}
+
+type templates struct {
+	title *template.Template
+	body  *template.Template
+}
+
+func parseTemplates(title, body string) (*templates, error) {
+	if title == "" {
+		title = defaultTitle
+	}
+	if body == "" {
+		body = defaultBody
+	}
+	t, err := template.New("title").Funcs(templateFuncs).Parse(title)
+	if err != nil {
+		return nil, fmt.Errorf("invalid notify.title template: %w", err)
+	}
+	b, err := template.New("body").Funcs(templateFuncs).Parse(body)
+	if err != nil {
+		return nil, fmt.Errorf("invalid notify.body template: %w", err)
+	}
+	return &templates{title: t, body: b}, nil
+}
+
+// render executes both templates for a run.
+func (t *templates) render(r *report.Report, changed bool) (Message, error) {
+	hostname, _ := os.Hostname()
+	data := Data{Report: r, Success: r.Success(), Changed: changed, Hostname: hostname, Duration: r.End.Sub(r.Start)}
+
+	var title, body strings.Builder
+	if err := t.title.Execute(&title, data); err != nil {
+		return Message{}, fmt.Errorf("rendering notify.title: %w", err)
+	}
+	if err := t.body.Execute(&body, data); err != nil {
+		return Message{}, fmt.Errorf("rendering notify.body: %w", err)
+	}
+	// Titles end up in mail subjects and HTTP headers, which cannot contain line breaks
+	return Message{
+		Title:   strings.Join(strings.Fields(title.String()), " "),
+		Body:    strings.TrimSpace(body.String()),
+		Success: data.Success,
+		Report:  r,
+	}, nil
+}
@@ -0,0 +1,162 @@
+package report
+
+import (
+	"fmt"
+	"time"
+
+	"docker-backup-tool/internal/logutil"
+	"docker-backup-tool/internal/util"
+)
+
+// Report summarizes one backup run. It is filled in while projects are processed
+// and logged at the end of the run.
+type Report struct {
+	ID       string     `json:"id"` // Derived from the start time, see IDFormat
+	Start    time.Time  `json:"start"`
+	End      time.Time  `json:"end"`
+	DryRun   bool       `json:"dry_run"`
+	Projects []*Project `json:"projects"`
+
+	// Transfers that are not tied to one project, e.g. syncing the whole backup directory
+	Transfers []Transfer `json:"transfers,omitempty"`
+}
+
+// Project is the outcome of backing up one project.
+type Project struct {
+	Name        string        `json:"name"`
+	Start       time.Time     `json:"start"`
+	Duration    time.Duration `json:"duration"`
+	Success     bool          `json:"success"`
+	Error       string        `json:"error,omitempty"` // First error that failed the project
+	Archive     string        `json:"archive,omitempty"`
+	Path        string        `json:"path,omitempty"`         // Full path of the archive or repository snapshot
+	Checksum    string        `json:"checksum,omitempty"`     // SHA-256 of the archive as stored
+	ArchiveSize int64         `json:"archive_size,omitempty"` // Sum of all parts of a split archive
+	Files       int           `json:"files,omitempty"`        // Files in the archive
+	Downtime    time.Duration `json:"downtime,omitempty"`     // From stopping the stack until it was started again or the project finished
+	Log         string        `json:"log,omitempty"`          // Project log with the output of every command
+	Transfers   []Transfer    `json:"transfers,omitempty"`
+}
+
+// Transfer is the outcome of uploading a project's backup to one destination.
+type Transfer struct {
+	Destination string        `json:"destination"`
+	Files       int           `json:"files"`
+	Bytes       int64         `json:"bytes"`
+	Duration    time.Duration `json:"duration"`
+	Attempts    int           `json:"attempts,omitempty"`
+	Error       string        `json:"error,omitempty"`
+}
+
+// Speed returns the average transfer rate in bytes per second.
+func (t Transfer) Speed() float64 {
+	if t.Duration <= 0 {
+		return 0
+	}
+	return float64(t.Bytes) / t.Duration.Seconds()
+}
+
+// IDFormat is the layout of run IDs: the start time in UTC, which sorts chronologically.
+const IDFormat = "20060102T150405.000000000Z"
+
+// New starts a report for a run.
+func New(dryRun bool) *Report {
+	start := time.Now()
+	return &Report{ID: start.UTC().Format(IDFormat), Start: start, DryRun: dryRun}
+}
+
+// AddProject records the start of a project.
+func (r *Report) AddProject(name string) *Project {
+	p := &Project{Name: name, Start: time.Now(), Success: true}
+	r.Projects = append(r.Projects, p)
+	return p
+}
+
+// Finish records the end of the run.
+func (r *Report) Finish() {
+	r.End = time.Now()
+}
+
+// Succeeded returns the number of projects without errors.
+func (r *Report) Succeeded() int {
+	n := 0
+	for _, p := range r.Projects {
+		if p.Success {
+			n++
+		}
+	}
+	return n
+}
+
+// Failed returns the number of projects with errors.
+func (r *Report) Failed() int {
+	return len(r.Projects) - r.Succeeded()
+}
+
+// Success reports whether every project and every run-level transfer succeeded.
+func (r *Report) Success() bool {
+	if r.Failed() > 0 {
+		return false
+	}
+	for _, t := range r.Transfers {
+		if t.Error != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// Fail marks the project as failed. Only the first error is kept, later ones
+// are usually consequences of it.
+func (p *Project) Fail(format string, args ...interface{}) {
+	if p.Success {
+		p.Error = fmt.Sprintf(format, args...)
+	}
+	p.Success = false
+}
+
+// Finish records the duration of the project.
+func (p *Project) Finish() {
+	p.Duration = time.Since(p.Start)
+}
+
+// Log writes the per-project summary of the run.
+func Log(r *Report) {
+	for _, p := range r.Projects {
+		status := "OK"
+		if !p.Success {
+			status = "FAILED"
+		}
+		line := fmt.Sprintf("  %-20s %-6s %8s", p.Name, status, p.Duration.Round(time.Second))
+		if p.Archive != "" {
+			line += fmt.Sprintf("  %s (%s)", p.Archive, util.FormatBytes(p.ArchiveSize))
+		}
+		if p.Success {
+			logutil.Info("%s", line)
+		} else {
+			logutil.Error("%s: %s", line, p.Error)
+		}
+		for _, t := range p.Transfers {
+			logTransfer(t)
+		}
+	}
+	if len(r.Transfers) > 0 {
+		logutil.Info("  %-20s", "backup directory")
+		for _, t := range r.Transfers {
+			logTransfer(t)
+		}
+	}
+}
+
+func logTransfer(t Transfer) {
+	if t.Error != "" {
+		logutil.Error("      -> %s: %s", t.Destination, t.Error)
+		return
+	}
+	line := fmt.Sprintf("      -> %s: %d file(s), %s in %s (%s/s)", t.Destination, t.Files,
+		util.FormatBytes(t.Bytes), t.Duration.Round(time.Second), util.FormatBytes(int64(t.Speed())))
+	if t.Attempts > 1 {
+		line += fmt.Sprintf(", %d attempts", t.Attempts)
+	}
+	logutil.Info("%s", line)
+}
@@ -0,0 +1,93 @@
+package repository
+
+import (
+	"io"
+)
+
+// Chunk size bounds for content-defined chunking. The average chunk size is
+// roughly 1 MiB; boundaries depend only on the data around them, so an insert
+// or change in a large file only produces new chunks near the edit.
+const (
+	minChunkSize = 512 << 10
+	maxChunkSize = 8 << 20
+	avgChunkBits = 20
+)
+
+// chunkMask selects the top bits of the gear hash, which depend on the most bytes.
+const chunkMask = uint64(1<<avgChunkBits-1) << (64 - avgChunkBits)
+
+// gearTable maps every byte value to a pseudo-random 64 bit value.
+// It is generated from a fixed seed and must never change, otherwise
+// existing repositories would stop deduplicating against new backups.
+var gearTable = func() [256]uint64 {
+	var table [256]uint64
+	state := uint64(0x6a09e667f3bcc908)
+	for i := range table {
+		// splitmix64
+		state += 0x9e3779b97f4a7c15
+		z := state
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		table[i] = z ^ (z >> 31)
+	}
+	return table
+}()
+
+// Chunker splits a stream into content-defined chunks using a gear rolling hash.
+type Chunker struct {
+	r          io.Reader
+	buf        []byte
+	start, end int
+	eof        bool
+}
+
+// NewChunker returns a Chunker reading from r.
+func NewChunker(r io.Reader) *Chunker {
+	return &Chunker{r: r, buf: make([]byte, 2*maxChunkSize)}
+}
+
+// Next returns the next chunk, or io.EOF once the stream is exhausted.
+// The returned slice is only valid until the next call.
+func (c *Chunker) Next() ([]byte, error) {
+	if c.end-c.start < maxChunkSize && !c.eof {
+		copy(c.buf, c.buf[c.start:c.end])
+		c.end -= c.start
+		c.start = 0
+		for c.end < len(c.buf) && !c.eof {
+			n, err := c.r.Read(c.buf[c.end:])
+			c.end += n
+			if err == io.EOF {
+				c.eof = true
+			} else if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	data := c.buf[c.start:c.end]
+	if len(data) == 0 {
+		return nil, io.EOF
+	}
+	n := cutPoint(data)
+	c.start += n
+	return data[:n], nil
+}
+
+// cutPoint returns the length of the next chunk at the start of data.
+func cutPoint(data []byte) int {
+	if len(data) <= minChunkSize {
+		return len(data)
+	}
+	limit := len(data)
+	if limit > maxChunkSize {
+		limit = maxChunkSize
+	}
+	var h uint64
+	for i := minChunkSize; i < limit; i++ {
+		h = (h << 1) + gearTable[data[i]]
+		if h&chunkMask == 0 {
+			return i + 1
+		}
+	}
+	return limit
+}
@@ -0,0 +1,340 @@
+package repository
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// BackupStats summarizes how much data a backup added to the repository.
+type BackupStats struct {
+	Files      int
+	TotalBytes int64
+	NewChunks  int
+	NewBytes   int64
+}
+
+// Backup stores the tree below root as a new snapshot for project and
+// returns the path of the snapshot file.
+func (r *Repository) Backup(project, root string) (string, BackupStats, error) {
+	var stats BackupStats
+	snap := &Snapshot{Project: project, Time: time.Now().UTC()}
+
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		relPath, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		if relPath == "." {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		node := Node{
+			Path:    filepath.ToSlash(relPath),
+			Mode:    info.Mode(),
+			ModTime: info.ModTime().UTC(),
+		}
+		switch {
+		case d.IsDir():
+			node.Type = NodeDir
+		case d.Type()&fs.ModeSymlink != 0:
+			node.Type = NodeSymlink
+			if node.Target, err = os.Readlink(path); err != nil {
+				return err
+			}
+		case d.Type().IsRegular():
+			node.Type = NodeFile
+			node.Size = info.Size()
+			if node.Chunks, err = r.storeFile(path, &stats); err != nil {
+				return fmt.Errorf("failed to store '%s': %w", relPath, err)
+			}
+			stats.Files++
+			stats.TotalBytes += node.Size
+		default:
+			// Sockets, devices and pipes cannot be restored meaningfully
+			return nil
+		}
+		snap.Nodes = append(snap.Nodes, node)
+		return nil
+	})
+	if err != nil {
+		return "", stats, err
+	}
+
+	snap.Size = stats.TotalBytes
+	path, err := r.SaveSnapshot(snap)
+	return path, stats, err
+}
+
+// storeFile chunks a file and stores chunks that are not in the repository yet.
+func (r *Repository) storeFile(path string, stats *BackupStats) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var hashes []string
+	c := NewChunker(f)
+	for {
+		chunk, err := c.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		hash, isNew, err := r.putChunk(chunk)
+		if err != nil {
+			return nil, err
+		}
+		if isNew {
+			stats.NewChunks++
+			stats.NewBytes += int64(len(chunk))
+		}
+		hashes = append(hashes, hash)
+	}
+	return hashes, nil
+}
+
+// Latest returns the newest snapshot of a project.
+func (r *Repository) Latest(project string) (*Snapshot, error) {
+	snapshots, err := r.Snapshots(project)
+	if err != nil {
+		return nil, err
+	}
+	if len(snapshots) == 0 {
+		return nil, fmt.Errorf("%w for project '%s'", ErrNoSnapshots, project)
+	}
+	return snapshots[len(snapshots)-1], nil
+}
+
+// Restore writes the tree of a snapshot below targetDir.
+func (r *Repository) Restore(s *Snapshot, targetDir string) error {
+	if err := os.MkdirAll(targetDir, 0755); err != nil {
+		return fmt.Errorf("failed to create restore directory '%s': %w", targetDir, err)
+	}
+	var dirs []Node
+	for _, n := range s.Nodes {
+		dst := filepath.Join(targetDir, filepath.FromSlash(n.Path))
+		if rel, err := filepath.Rel(targetDir, dst); err != nil || strings.HasPrefix(rel, "..") {
+			return fmt.Errorf("snapshot entry '%s' points outside the restore directory", n.Path)
+		}
+		switch n.Type {
+		case NodeDir:
+			if err := os.MkdirAll(dst, 0755); err != nil {
+				return err
+			}
+			dirs = append(dirs, n)
+		case NodeSymlink:
+			os.Remove(dst)
+			if err := os.Symlink(n.Target, dst); err != nil {
+				return fmt.Errorf("failed to restore symlink '%s': %w", n.Path, err)
+			}
+		case NodeFile:
+			if err := r.restoreFile(n, dst); err != nil {
+				return fmt.Errorf("failed to restore '%s': %w", n.Path, err)
+			}
+		}
+	}
+	// Directory metadata last, writing files into them changes their mtime
+	for _, n := range dirs {
+		dst := filepath.Join(targetDir, filepath.FromSlash(n.Path))
+		os.Chmod(dst, n.Mode.Perm())
+		os.Chtimes(dst, n.ModTime, n.ModTime)
+	}
+	return nil
+}
+
+func (r *Repository) restoreFile(n Node, dst string) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, n.Mode.Perm())
+	if err != nil {
+		return err
+	}
+	if err := r.copyChunks(out, n); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	os.Chmod(dst, n.Mode.Perm())
+	return os.Chtimes(dst, n.ModTime, n.ModTime)
+}
+
+// Policy decides which snapshots prune keeps for each project.
+// A snapshot is kept if it matches any rule; with no rules set everything is kept.
+type Policy struct {
+	KeepLast int // Keep the newest N snapshots
+	KeepDays int // Keep snapshots younger than N days
+}
+
+// Forget applies the policy to every project and deletes the snapshots it does not keep.
+// It returns the removed snapshots.
+func (r *Repository) Forget(p Policy, dryRun bool) ([]*Snapshot, error) {
+	if p.KeepLast <= 0 && p.KeepDays <= 0 {
+		return nil, nil
+	}
+	all, err := r.Snapshots("")
+	if err != nil {
+		return nil, err
+	}
+	byProject := make(map[string][]*Snapshot)
+	for _, s := range all {
+		byProject[s.Project] = append(byProject[s.Project], s)
+	}
+
+	cutoff := time.Now().AddDate(0, 0, -p.KeepDays)
+	var removed []*Snapshot
+	for _, snapshots := range byProject {
+		for i, s := range snapshots {
+			newerCount := len(snapshots) - 1 - i
+			keep := (p.KeepLast > 0 && newerCount < p.KeepLast) || (p.KeepDays > 0 && s.Time.After(cutoff))
+			if keep {
+				continue
+			}
+			if !dryRun {
+				if err := r.DeleteSnapshot(s.ID); err != nil {
+					return removed, fmt.Errorf("failed to delete snapshot %s: %w", s.ID, err)
+				}
+			}
+			removed = append(removed, s)
+		}
+	}
+	return removed, nil
+}
+
+// GarbageCollect deletes chunks no longer referenced by any snapshot.
+// It returns the number of chunks and bytes freed.
+func (r *Repository) GarbageCollect(dryRun bool) (int, int64, error) {
+	referenced, err := r.referencedChunks()
+	if err != nil {
+		return 0, 0, err
+	}
+	var count int
+	var freed int64
+	err = r.walkChunks(func(hash, path string, size int64) error {
+		if _, ok := referenced[hash]; ok {
+			return nil
+		}
+		if !dryRun {
+			if err := os.Remove(path); err != nil {
+				return err
+			}
+		}
+		count++
+		freed += size
+		return nil
+	})
+	return count, freed, err
+}
+
+// CheckResult lists the problems found by Check.
+type CheckResult struct {
+	Snapshots      int
+	Chunks         int
+	MissingChunks  []string
+	CorruptChunks  []string
+	UnusedChunks   int
+	BrokenSnapshot []string
+}
+
+// OK reports whether the repository is consistent.
+func (c CheckResult) OK() bool {
+	return len(c.MissingChunks) == 0 && len(c.CorruptChunks) == 0 && len(c.BrokenSnapshot) == 0
+}
+
+// Check verifies that every snapshot is readable and every referenced chunk exists.
+// With readData, chunk contents are also re-hashed.
+func (r *Repository) Check(readData bool) (CheckResult, error) {
+	var res CheckResult
+	ids, err := r.snapshotIDs()
+	if err != nil {
+		return res, err
+	}
+	referenced := make(map[string]struct{})
+	for _, id := range ids {
+		s, err := r.readSnapshot(id)
+		if err != nil {
+			res.BrokenSnapshot = append(res.BrokenSnapshot, id)
+			continue
+		}
+		res.Snapshots++
+		for _, n := range s.Nodes {
+			for _, hash := range n.Chunks {
+				referenced[hash] = struct{}{}
+			}
+		}
+	}
+
+	present := make(map[string]struct{})
+	err = r.walkChunks(func(hash, path string, size int64) error {
+		present[hash] = struct{}{}
+		res.Chunks++
+		if _, ok := referenced[hash]; !ok {
+			res.UnusedChunks++
+			return nil
+		}
+		if readData {
+			if _, err := r.readChunk(hash); err != nil {
+				res.CorruptChunks = append(res.CorruptChunks, hash)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return res, err
+	}
+	for hash := range referenced {
+		if _, ok := present[hash]; !ok {
+			res.MissingChunks = append(res.MissingChunks, hash)
+		}
+	}
+	return res, nil
+}
+
+func (r *Repository) referencedChunks() (map[string]struct{}, error) {
+	snapshots, err := r.Snapshots("")
+	if err != nil {
+		return nil, err
+	}
+	referenced := make(map[string]struct{})
+	for _, s := range snapshots {
+		for _, n := range s.Nodes {
+			for _, hash := range n.Chunks {
+				referenced[hash] = struct{}{}
+			}
+		}
+	}
+	return referenced, nil
+}
+
+func (r *Repository) walkChunks(fn func(hash, path string, size int64) error) error {
+	root := filepath.Join(r.Path, "chunks")
+	return filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || strings.Contains(d.Name(), ".tmp") {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		return fn(d.Name(), path, info.Size())
+	})
+}
@@ -0,0 +1,308 @@
+package repository
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// formatVersion is written to the repository config and checked on open.
+const formatVersion = 1
+
+// Node types stored in a snapshot tree.
+const (
+	NodeDir     = "dir"
+	NodeFile    = "file"
+	NodeSymlink = "symlink"
+)
+
+// Repository is a local content-addressed store. Layout:
+//
+//	config.json            repository format version
+//	chunks/ab/abcdef...    chunk data, named by SHA-256 of the content
+//	snapshots/<id>.json    one snapshot tree per backup
+type Repository struct {
+	Path string
+	lock string
+}
+
+// Node is one entry of a snapshot tree. Paths are relative and use forward slashes.
+type Node struct {
+	Path    string      `json:"path"`
+	Type    string      `json:"type"`
+	Mode    os.FileMode `json:"mode"`
+	ModTime time.Time   `json:"mtime"`
+	Size    int64       `json:"size,omitempty"`
+	Chunks  []string    `json:"chunks,omitempty"`
+	Target  string      `json:"target,omitempty"` // Symlink target
+}
+
+// Snapshot records the tree of one backup run.
+type Snapshot struct {
+	ID      string    `json:"id"`
+	Project string    `json:"project"`
+	Time    time.Time `json:"time"`
+	Size    int64     `json:"size"` // Total size of all files in the snapshot
+	Nodes   []Node    `json:"nodes"`
+}
+
+type repoConfig struct {
+	Version int `json:"version"`
+}
+
+// Init opens the repository at path for writing, initializing it when the directory
+// is empty or missing. The repository is locked until Close is called.
+func Init(path string) (*Repository, error) {
+	return open(path, true, true)
+}
+
+// Open opens the existing repository at path for commands that delete data. It fails
+// if path holds no repository. The repository is locked until Close is called.
+func Open(path string) (*Repository, error) {
+	return open(path, false, true)
+}
+
+// OpenReadOnly opens the existing repository at path without locking it, for commands
+// that only read snapshots and chunks. It fails if path holds no repository.
+func OpenReadOnly(path string) (*Repository, error) {
+	return open(path, false, false)
+}
+
+func open(path string, create, lock bool) (*Repository, error) {
+	r := &Repository{Path: path}
+	cfgPath := filepath.Join(path, "config.json")
+
+	data, err := os.ReadFile(cfgPath)
+	switch {
+	case os.IsNotExist(err) && create:
+		if err := r.init(); err != nil {
+			return nil, err
+		}
+	case os.IsNotExist(err):
+		return nil, fmt.Errorf("no repository at '%s' (missing config.json); it is created by the first backup with output: repository", path)
+	case err != nil:
+		return nil, fmt.Errorf("failed to read repository config: %w", err)
+	default:
+		var rc repoConfig
+		if err := json.Unmarshal(data, &rc); err != nil {
+			return nil, fmt.Errorf("failed to parse repository config '%s': %w", cfgPath, err)
+		}
+		if rc.Version != formatVersion {
+			return nil, fmt.Errorf("repository '%s' has unsupported format version %d", path, rc.Version)
+		}
+	}
+
+	if lock {
+		if err := r.acquireLock(); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// Close releases the repository lock.
+func (r *Repository) Close() error {
+	if r.lock == "" {
+		return nil
+	}
+	err := os.Remove(r.lock)
+	r.lock = ""
+	return err
+}
+
+func (r *Repository) init() error {
+	for _, dir := range []string{r.Path, filepath.Join(r.Path, "chunks"), filepath.Join(r.Path, "snapshots")} {
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return fmt.Errorf("failed to initialize repository '%s': %w", r.Path, err)
+		}
+	}
+	data, _ := json.Marshal(repoConfig{Version: formatVersion})
+	return writeFileAtomic(filepath.Join(r.Path, "config.json"), data)
+}
+
+// acquireLock creates an exclusive lock file so a prune cannot delete chunks
+// that a concurrent backup is about to reference.
+func (r *Repository) acquireLock() error {
+	lock := filepath.Join(r.Path, "lock")
+	f, err := os.OpenFile(lock, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		if os.IsExist(err) {
+			return fmt.Errorf("repository '%s' is locked by another process (remove '%s' if it is stale)", r.Path, lock)
+		}
+		return fmt.Errorf("failed to lock repository: %w", err)
+	}
+	fmt.Fprintf(f, "%d\n", os.Getpid())
+	f.Close()
+	r.lock = lock
+	return nil
+}
+
+// chunkPath returns the file holding the chunk with the given hash.
+func (r *Repository) chunkPath(hash string) string {
+	return filepath.Join(r.Path, "chunks", hash[:2], hash)
+}
+
+// putChunk stores data unless a chunk with the same hash already exists.
+// It reports whether the chunk was new.
+func (r *Repository) putChunk(data []byte) (string, bool, error) {
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:])
+	path := r.chunkPath(hash)
+	if _, err := os.Stat(path); err == nil {
+		return hash, false, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return "", false, err
+	}
+	if err := writeFileAtomic(path, data); err != nil {
+		return "", false, fmt.Errorf("failed to store chunk %s: %w", hash, err)
+	}
+	return hash, true, nil
+}
+
+// readChunk returns the content of a chunk after verifying its hash.
+func (r *Repository) readChunk(hash string) ([]byte, error) {
+	data, err := os.ReadFile(r.chunkPath(hash))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read chunk %s: %w", hash, err)
+	}
+	sum := sha256.Sum256(data)
+	if hex.EncodeToString(sum[:]) != hash {
+		return nil, fmt.Errorf("chunk %s is corrupt (hash mismatch)", hash)
+	}
+	return data, nil
+}
+
+// SaveSnapshot stores a snapshot, assigning it a new ID.
+func (r *Repository) SaveSnapshot(s *Snapshot) (string, error) {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	s.ID = hex.EncodeToString(id)
+	data, err := json.Marshal(s)
+	if err != nil {
+		return "", err
+	}
+	path := r.snapshotPath(s.ID)
+	if err := writeFileAtomic(path, data); err != nil {
+		return "", fmt.Errorf("failed to write snapshot: %w", err)
+	}
+	return path, nil
+}
+
+func (r *Repository) snapshotPath(id string) string {
+	return filepath.Join(r.Path, "snapshots", id+".json")
+}
+
+// LoadSnapshot reads a snapshot by ID. Unique ID prefixes are accepted.
+func (r *Repository) LoadSnapshot(id string) (*Snapshot, error) {
+	ids, err := r.snapshotIDs()
+	if err != nil {
+		return nil, err
+	}
+	var match string
+	for _, candidate := range ids {
+		if strings.HasPrefix(candidate, id) {
+			if match != "" {
+				return nil, fmt.Errorf("snapshot ID prefix '%s' is ambiguous", id)
+			}
+			match = candidate
+		}
+	}
+	if match == "" {
+		return nil, fmt.Errorf("snapshot '%s' not found in %s", id, r.Path)
+	}
+	return r.readSnapshot(match)
+}
+
+func (r *Repository) readSnapshot(id string) (*Snapshot, error) {
+	data, err := os.ReadFile(r.snapshotPath(id))
+	if err != nil {
+		return nil, err
+	}
+	var s Snapshot
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("failed to parse snapshot %s: %w", id, err)
+	}
+	return &s, nil
+}
+
+func (r *Repository) snapshotIDs() ([]string, error) {
+	entries, err := os.ReadDir(filepath.Join(r.Path, "snapshots"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list snapshots: %w", err)
+	}
+	var ids []string
+	for _, e := range entries {
+		if !e.IsDir() && strings.HasSuffix(e.Name(), ".json") {
+			ids = append(ids, strings.TrimSuffix(e.Name(), ".json"))
+		}
+	}
+	return ids, nil
+}
+
+// Snapshots returns all snapshots, optionally limited to one project, oldest first.
+func (r *Repository) Snapshots(project string) ([]*Snapshot, error) {
+	ids, err := r.snapshotIDs()
+	if err != nil {
+		return nil, err
+	}
+	var snapshots []*Snapshot
+	for _, id := range ids {
+		s, err := r.readSnapshot(id)
+		if err != nil {
+			return nil, err
+		}
+		if project == "" || s.Project == project {
+			snapshots = append(snapshots, s)
+		}
+	}
+	sort.Slice(snapshots, func(i, j int) bool { return snapshots[i].Time.Before(snapshots[j].Time) })
+	return snapshots, nil
+}
+
+// DeleteSnapshot removes a snapshot. Its chunks are only freed by GarbageCollect.
+func (r *Repository) DeleteSnapshot(id string) error {
+	return os.Remove(r.snapshotPath(id))
+}
+
+// writeFileAtomic writes data to a temporary file and renames it into place.
+func writeFileAtomic(path string, data []byte) error {
+	tmp := path + ".tmp" + strconv.Itoa(os.Getpid())
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// ErrNoSnapshots is returned when a project has no snapshot to restore.
+var ErrNoSnapshots = errors.New("no snapshots found")
+
+// copyChunks streams the chunks of a file node to w.
+func (r *Repository) copyChunks(w io.Writer, n Node) error {
+	for _, hash := range n.Chunks {
+		data, err := r.readChunk(hash)
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
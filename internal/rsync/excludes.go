@@ -0,0 +1,32 @@
+package rsync
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// ExcludeArgs translates the tool's exclude patterns (see util.MatchesExclude) into
+// rsync --exclude rules with the same meaning:
+//
+//	*.log       top-level files only, like filepath.Match on the relative path -> /*.log
+//	cache/**    the directory and everything below it                           -> /cache/***
+//	**/*.tmp    a base name at any depth                                        -> *.tmp
+//	*/cache/*   anchored like *.log, '*' does not match '/'                     -> /*/cache/*
+func ExcludeArgs(patterns []string) []string {
+	var args []string
+	add := func(rule string) {
+		args = append(args, "--exclude="+rule)
+	}
+	for _, pattern := range patterns {
+		pattern = filepath.ToSlash(pattern)
+		switch {
+		case strings.HasSuffix(pattern, "/**"):
+			add("/" + strings.TrimSuffix(pattern, "/**") + "/***")
+		case strings.HasPrefix(pattern, "**/"):
+			add(strings.TrimPrefix(pattern, "**/"))
+		default:
+			add("/" + strings.TrimPrefix(pattern, "/"))
+		}
+	}
+	return args
+}
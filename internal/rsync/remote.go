@@ -0,0 +1,226 @@
+package rsync
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"docker-backup-tool/internal/config"
+	"docker-backup-tool/internal/logutil"
+)
+
+// Entry is a file in a directory listing produced by rsync --list-only.
+type Entry struct {
+	Name    string
+	Size    int64
+	ModTime time.Time
+	Dir     bool
+}
+
+// listPattern matches a --list-only line, e.g.
+// "-rw-r--r--      1,234,567 2025/04/28 03:00:12 myproject_20250428.zip".
+var listPattern = regexp.MustCompile(`^([dl-])[rwxsStT-]{9}\s+([\d,]+)\s+(\d{4}/\d{2}/\d{2} \d{2}:\d{2}:\d{2})\s+(.+)$`)
+
+// List returns the entries of the directory dir (e.g. user@host:/backups/) without
+// descending into subdirectories. The connection settings of the configured options
+// (-e "ssh ...", --port, ...) apply to the listing as well, see transportOptions.
+func List(ctx context.Context, rc config.RsyncConfig, dir string) ([]Entry, error) {
+	args, err := transportOptions(rc)
+	if err != nil {
+		return nil, err
+	}
+	args = append(args, "--list-only", "--dirs", RemotePath(dir, ""))
+
+	ctx, cancel := withTimeout(ctx, rc)
+	defer cancel()
+	var out []byte
+	_, err = retry(ctx, rc, func() error {
+		var err error
+		out, err = output(ctx, rc, args)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []Entry
+	for _, line := range strings.Split(string(out), "\n") {
+		m := listPattern.FindStringSubmatch(strings.TrimRight(line, "\r"))
+		if m == nil || m[4] == "." {
+			continue
+		}
+		size, _ := parseNumber(m[2])
+		modTime, _ := time.ParseInLocation("2006/01/02 15:04:05", m[3], time.Local)
+		entries = append(entries, Entry{Name: m[4], Size: size, ModTime: modTime, Dir: m[1] == "d"})
+	}
+	return entries, nil
+}
+
+// Delete removes files from the directory dir. rsync has no remote delete command,
+// so an empty local directory is synced onto dir with --delete, limited by filter
+// rules to exactly the given names. Names ending in "/" are removed as directories,
+// including their contents. Only the connection settings of the configured options
+// are used: flags such as --delete-excluded or --backup would change what is deleted.
+func Delete(ctx context.Context, rc config.RsyncConfig, dir string, names ...string) error {
+	if len(names) == 0 {
+		return nil
+	}
+	empty, err := os.MkdirTemp("", "docker-backup-rsync-empty-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(empty)
+
+	args, err := transportOptions(rc)
+	if err != nil {
+		return err
+	}
+	// Never touch the attributes of the remote directory itself
+	args = append(args, "--recursive", "--delete", "--no-perms", "--no-owner", "--no-group", "--omit-dir-times")
+	for _, name := range names {
+		dir := strings.HasSuffix(name, "/")
+		name = strings.TrimSuffix(name, "/")
+		if name == "" || name == "." || name == ".." || strings.ContainsAny(name, "/\n") {
+			return fmt.Errorf("invalid file name '%s'", name)
+		}
+		rule := "/" + escapeFilter(name)
+		if dir {
+			rule += "/***"
+		}
+		args = append(args, "--include="+rule)
+	}
+	args = append(args, "--exclude=*", empty+"/", RemotePath(dir, ""))
+
+	ctx, cancel := withTimeout(ctx, rc)
+	defer cancel()
+	_, err = retry(ctx, rc, func() error {
+		_, err := output(ctx, rc, args)
+		return err
+	})
+	return err
+}
+
+// escapeFilter escapes the wildcard characters of a filter rule.
+func escapeFilter(name string) string {
+	var b strings.Builder
+	for _, r := range name {
+		if strings.ContainsRune(`*?[\`, r) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// output runs rsync once and returns its standard output.
+func output(ctx context.Context, rc config.RsyncConfig, args []string) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, rc.Command, args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	cmd.WaitDelay = 5 * time.Second
+
+	logutil.Debug("Running rsync: %s %s", rc.Command, strings.Join(args, " "))
+	out, err := cmd.Output()
+	// stdout is a file listing or empty, only stderr is worth keeping
+	logutil.CommandOutput(cmd.Args, "", stderr.String(), err)
+	if err != nil {
+		return nil, fmt.Errorf("rsync command failed: %w\nStderr: %s", err, stderr.String())
+	}
+	return out, nil
+}
+
+// MkdirAll creates dir and its parents below the existing directory base, e.g.
+// base "host:/backups/" and dir "myproject/20250428-030000". Older rsync versions
+// lack --mkpath, so an empty local tree of the same shape is transferred instead.
+func MkdirAll(ctx context.Context, rc config.RsyncConfig, base, dir string) error {
+	skeleton, err := os.MkdirTemp("", "docker-backup-rsync-mkdir-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(skeleton)
+	if err := os.MkdirAll(filepath.Join(skeleton, filepath.FromSlash(dir)), 0755); err != nil {
+		return err
+	}
+
+	// --delete and similar flags from the configured options must not empty the target
+	args, err := transportOptions(rc)
+	if err != nil {
+		return err
+	}
+	args = append(args, "--recursive", "--no-perms", "--no-owner", "--no-group", "--omit-dir-times",
+		"--include=*/", "--exclude=*", skeleton+"/", RemotePath(base, ""))
+
+	ctx, cancel := withTimeout(ctx, rc)
+	defer cancel()
+	_, err = retry(ctx, rc, func() error {
+		_, err := output(ctx, rc, args)
+		return err
+	})
+	return err
+}
+
+// transportFlags are the options that only affect how rsync connects, with whether
+// they take a value. They are the only ones taken from the configured options for
+// listing, deleting and creating directories.
+var transportFlags = map[string]bool{
+	"--rsh":           true,
+	"--rsync-path":    true,
+	"--port":          true,
+	"--password-file": true,
+	"--timeout":       true,
+	"--contimeout":    true,
+	"--address":       true,
+	"--sockopts":      true,
+	"--bwlimit":       true,
+	"--ipv4":          false,
+	"--ipv6":          false,
+	"--protect-args":  false,
+	"--secluded-args": false,
+}
+
+// transportOptions returns the connection settings of the configured options, e.g.
+// -e "ssh -p 2222" or --port=8730, without flags that change what is transferred or deleted.
+func transportOptions(rc config.RsyncConfig) ([]string, error) {
+	args, err := options(rc)
+	if err != nil {
+		return nil, err
+	}
+	var kept []string
+	for i := 0; i < len(args); i++ {
+		a := args[i]
+		switch {
+		case a == "-4" || a == "-6" || a == "-s":
+			kept = append(kept, a)
+		case strings.HasPrefix(a, "--"):
+			name, _, hasValue := strings.Cut(a, "=")
+			takesValue, ok := transportFlags[name]
+			if !ok {
+				continue
+			}
+			kept = append(kept, a)
+			if takesValue && !hasValue && i+1 < len(args) {
+				i++
+				kept = append(kept, args[i])
+			}
+		case strings.HasPrefix(a, "-"):
+			// -e may end a group of short flags such as -avze, its value is the rest or the next argument
+			j := strings.IndexByte(a, 'e')
+			if j < 0 {
+				continue
+			}
+			if value := a[j+1:]; value != "" {
+				kept = append(kept, "--rsh="+value)
+			} else if i+1 < len(args) {
+				i++
+				kept = append(kept, "--rsh="+args[i])
+			}
+		}
+	}
+	return kept, nil
+}
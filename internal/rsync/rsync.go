@@ -1,42 +1,255 @@
 package rsync
 
 import (
+	"bufio"
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"os/exec"
+	"regexp"
+	"strconv"
 	"strings"
+	"time"
 
 	"docker-backup-tool/internal/config"
 	"docker-backup-tool/internal/logutil"
+	"docker-backup-tool/internal/util"
 	// Use shlex for potentially safer splitting of options string
 	"github.com/google/shlex"
 )
 
-// Transfer executes the rsync command to transfer a file.
-// It now accepts the config.Rsync struct for clarity.
-func TransferBackup(cfg config.Config, sourceFile string) error {
-	// Split the options string into arguments respecting quotes
-	// This allows options like -e "ssh -p 2222" to be parsed correctly.
-	optsArgs, err := shlex.Split(cfg.Rsync.Options)
+// maxRetryDelay caps the exponential backoff between attempts.
+const maxRetryDelay = 5 * time.Minute
+
+// Stats describes a completed transfer.
+type Stats struct {
+	Files    int           // Regular files transferred
+	Bytes    int64         // Size of the transferred files
+	Sent     int64         // Bytes sent over the wire (after rsync compression and delta transfer)
+	Duration time.Duration // Including retries
+	Attempts int
+}
+
+// Speed returns the average transfer rate in bytes per second.
+func (s Stats) Speed() float64 {
+	if s.Duration <= 0 {
+		return 0
+	}
+	return float64(s.Bytes) / s.Duration.Seconds()
+}
+
+// retryableExitCodes are rsync exit codes caused by network problems or timeouts.
+var retryableExitCodes = map[int]bool{
+	5:   true, // Error starting client-server protocol
+	10:  true, // Error in socket I/O
+	12:  true, // Error in rsync protocol data stream
+	30:  true, // Timeout in data send/receive
+	35:  true, // Timeout waiting for daemon connection
+	255: true, // SSH connection failed
+}
+
+// Transfer runs rsync with the configured options, copying sources to destination.
+// The destination may be remote (user@host:/path/) or a local path. Failures caused
+// by the network are retried with exponential backoff; rc.Timeout limits the whole
+// transfer including retries.
+func Transfer(ctx context.Context, rc config.RsyncConfig, destination string, sources ...string) (Stats, error) {
+	return TransferWith(ctx, rc, nil, destination, sources...)
+}
+
+// TransferWith is Transfer with additional rsync arguments (filters, --delete, ...)
+// placed after the configured options.
+func TransferWith(ctx context.Context, rc config.RsyncConfig, extraArgs []string, destination string, sources ...string) (Stats, error) {
+	optsArgs, err := options(rc)
 	if err != nil {
-		return fmt.Errorf("failed to parse rsync options string '%s': %w", cfg.Rsync.Options, err)
+		return Stats{}, err
+	}
+	// --stats is parsed for the final numbers, progress2 for periodic progress lines
+	optsArgs = append(optsArgs, "--stats")
+	if rc.ProgressInterval > 0 {
+		optsArgs = append(optsArgs, "--info=progress2")
 	}
+	optsArgs = append(optsArgs, extraArgs...)
 
 	// Construct the full rsync command arguments
-	args := append(optsArgs, sourceFile, cfg.Rsync.Destination)
+	args := append(optsArgs, sources...)
+	args = append(args, destination)
+
+	ctx, cancel := withTimeout(ctx, rc)
+	defer cancel()
+
+	start := time.Now()
+	var stats Stats
+	attempts, err := retry(ctx, rc, func() error {
+		var err error
+		stats, err = run(ctx, rc, args, rc.ProgressInterval)
+		return err
+	})
+	stats.Attempts = attempts
+	stats.Duration = time.Since(start)
+	return stats, err
+}
+
+// options splits the configured options string into arguments, respecting quotes.
+// This allows options like -e "ssh -p 2222" to be parsed correctly.
+func options(rc config.RsyncConfig) ([]string, error) {
+	args, err := shlex.Split(rc.Options)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse rsync options string '%s': %w", rc.Options, err)
+	}
+	return args, nil
+}
+
+// withTimeout applies rc.Timeout to ctx.
+func withTimeout(ctx context.Context, rc config.RsyncConfig) (context.Context, context.CancelFunc) {
+	if rc.Timeout > 0 {
+		return context.WithTimeout(ctx, rc.Timeout)
+	}
+	return context.WithCancel(ctx)
+}
 
+// retry calls fn until it succeeds, fails with an error that is not caused by the
+// network, or rc.Retries is exhausted. It returns the number of attempts made.
+func retry(ctx context.Context, rc config.RsyncConfig, fn func() error) (int, error) {
+	delay := rc.RetryDelay
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil {
+			return attempt, nil
+		}
+		if ctx.Err() != nil {
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				err = fmt.Errorf("rsync timed out after %s: %w", rc.Timeout, err)
+			}
+			return attempt, err
+		}
+		var exitErr *exec.ExitError
+		if attempt > rc.Retries || !errors.As(err, &exitErr) || !retryableExitCodes[exitErr.ExitCode()] {
+			return attempt, err
+		}
+
+		logutil.Warn("Rsync attempt %d of %d failed: %v. Retrying in %s...", attempt, rc.Retries+1, firstLine(err.Error()), delay)
+		select {
+		case <-ctx.Done():
+			return attempt, fmt.Errorf("rsync timed out after %s while waiting to retry: %w", rc.Timeout, err)
+		case <-time.After(delay):
+		}
+		delay *= 2
+		if delay > maxRetryDelay {
+			delay = maxRetryDelay
+		}
+	}
+}
+
+// run executes rsync once, logging progress and collecting the --stats output.
+func run(ctx context.Context, rc config.RsyncConfig, args []string, progressInterval time.Duration) (Stats, error) {
 	// Use the provided rsync command path
-	cmd := exec.Command(cfg.Rsync.Command, args...)
+	cmd := exec.CommandContext(ctx, rc.Command, args...)
 
 	var stderr bytes.Buffer
 	cmd.Stderr = &stderr
+	// Child processes (ssh) may keep the output open after rsync was killed on timeout
+	cmd.WaitDelay = 5 * time.Second
+	stdout, progress := io.Pipe()
+	cmd.Stdout = progress
 
-	logutil.Debug("Running rsync: %s %s", cfg.Rsync.Command, strings.Join(args, " "))
+	logutil.Debug("Running rsync: %s %s", rc.Command, strings.Join(args, " "))
 
-	err = cmd.Run()
+	if err := cmd.Start(); err != nil {
+		return Stats{}, fmt.Errorf("failed to start rsync: %w", err)
+	}
+	parsed := make(chan Stats)
+	var output strings.Builder // Everything but progress lines, for the project log
+	go func() {
+		parsed <- parseOutput(stdout, progressInterval, &output)
+		io.Copy(io.Discard, stdout)
+	}()
+	err := cmd.Wait()
+	progress.Close()
+	stats := <-parsed
+	logutil.CommandOutput(cmd.Args, output.String(), stderr.String(), err)
 	if err != nil {
-		return fmt.Errorf("rsync command failed: %w\nStderr: %s", err, stderr.String())
+		return stats, fmt.Errorf("rsync command failed: %w\nStderr: %s", err, stderr.String())
+	}
+	return stats, nil
+}
+
+// progressPattern matches a --info=progress2 line, e.g.
+// "    123,456,789  45%   12.34MB/s    0:01:02 (xfr#3, to-chk=10/20)".
+var progressPattern = regexp.MustCompile(`^\s*([\d,]+)\s+(\d+)%\s+(\S+/s)\s+(\d+:\d{2}:\d{2})`)
+
+// parseOutput reads rsync's stdout. Progress lines (separated by \r) are logged at
+// most once per interval; the --stats block is parsed into Stats. All other lines
+// are copied to out.
+func parseOutput(r io.Reader, interval time.Duration, out io.Writer) Stats {
+	var stats Stats
+	var lastLog time.Time
+	scanner := bufio.NewScanner(r)
+	scanner.Split(scanLinesOrCR)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if m := progressPattern.FindStringSubmatch(line); m != nil {
+			if interval > 0 && time.Since(lastLog) >= interval {
+				done, _ := parseNumber(m[1])
+				logutil.Info("Rsync progress: %s%% (%s) at %s, %s remaining", m[2], util.FormatBytes(done), m[3], m[4])
+				lastLog = time.Now()
+			}
+			continue
+		}
+		if line != "" {
+			fmt.Fprintln(out, line)
+		}
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		n, err := parseNumber(value)
+		if err != nil {
+			continue
+		}
+		switch strings.TrimSpace(key) {
+		case "Number of regular files transferred", "Number of files transferred":
+			stats.Files = int(n)
+		case "Total transferred file size":
+			stats.Bytes = n
+		case "Total bytes sent":
+			stats.Sent = n
+		}
+	}
+	return stats
+}
+
+// parseNumber parses the leading number of a --stats value such as " 1,234 bytes".
+func parseNumber(s string) (int64, error) {
+	fields := strings.Fields(s)
+	if len(fields) == 0 {
+		return 0, errors.New("empty value")
+	}
+	return strconv.ParseInt(strings.ReplaceAll(fields[0], ",", ""), 10, 64)
+}
+
+// scanLinesOrCR is a bufio.SplitFunc that splits on \n and on the \r rsync uses
+// to redraw its progress line.
+func scanLinesOrCR(data []byte, atEOF bool) (int, []byte, error) {
+	if i := bytes.IndexAny(data, "\r\n"); i >= 0 {
+		return i + 1, data[:i], nil
 	}
+	if atEOF && len(data) > 0 {
+		return len(data), data, nil
+	}
+	return 0, nil, nil
+}
 
-	return nil
+func firstLine(s string) string {
+	line, _, _ := strings.Cut(s, "\n")
+	return line
+}
+
+// RemotePath joins a file name onto an rsync destination such as user@host:/path/.
+func RemotePath(destination, name string) string {
+	if strings.HasSuffix(destination, "/") {
+		return destination + name
+	}
+	return destination + "/" + name
 }
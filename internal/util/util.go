@@ -3,6 +3,7 @@ package util
 import (
 	"fmt"
 	"path/filepath"
+	"strconv"
 	"strings"
 )
 
@@ -63,3 +64,48 @@ func MatchesExclude(path string, patterns []string) (bool, error) {
 
 	return false, nil
 }
+
+// FormatBytes renders a byte count using binary units, e.g. "1.5 GiB".
+func FormatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// ParseSize parses a size such as "4GiB", "500M", "700MB" or "1048576" into bytes.
+// Both decimal (KB, MB, GB, TB) and binary (KiB, MiB, GiB, TiB) suffixes are accepted;
+// single letters (K, M, G, T) are treated as binary.
+func ParseSize(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, nil
+	}
+	i := len(s)
+	for i > 0 && (s[i-1] < '0' || s[i-1] > '9') {
+		i--
+	}
+	number, suffix := strings.TrimSpace(s[:i]), strings.ToUpper(strings.TrimSpace(s[i:]))
+	n, err := strconv.ParseFloat(number, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size '%s'", s)
+	}
+	multipliers := map[string]float64{
+		"": 1, "B": 1,
+		"K": 1 << 10, "KIB": 1 << 10, "KB": 1e3,
+		"M": 1 << 20, "MIB": 1 << 20, "MB": 1e6,
+		"G": 1 << 30, "GIB": 1 << 30, "GB": 1e9,
+		"T": 1 << 40, "TIB": 1 << 40, "TB": 1e12,
+	}
+	m, ok := multipliers[suffix]
+	if !ok {
+		return 0, fmt.Errorf("invalid size unit '%s' in '%s'", s[i:], s)
+	}
+	return int64(n * m), nil
+}